@@ -0,0 +1,902 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file holds the computations shared by the HTML pages and
+the versioned JSON API, so both always return the same numbers.*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"webtest/solarpos"
+)
+
+/*This is the struct holding the user's input for an estimate. It can be
+filled in from the HTML form values or from a JSON request body.*/
+type EstimateRequest struct {
+	Latitude  float64 `json:"latitude"`   //Latitude of the user, north positive
+	Longitude float64 `json:"longitude"`  //Longitude of the user, east positive
+	Location  string  `json:"location"`   //Location as the user typed it, if typed together
+	HouseSize float64 `json:"house_size"` //House size in square feet
+	RoofSize  float64 `json:"roof_size"`  //Roof size in square feet
+	Mode      string  `json:"mode"`       //How to get the site's climate data: nearest, idw or idw-latitude
+	Neighbors int     `json:"neighbors"`  //Number of cities to blend (DefaultNeighbors if 0)
+	Power     float64 `json:"power"`      //Inverse-distance power (DefaultIDWPower if 0)
+
+	Roof          *Plane `json:"roof"`          //Which way the roof faces (flat if nil)
+	Transposition string `json:"transposition"` //Transposition model for tilted panels (DefaultTransposition if empty)
+	Losses        Losses `json:"losses"`        //Losses changed from their defaults
+
+	Inverter  string  `json:"inverter"`    //Catalog inverter whose efficiency curve is used (the loss chain's inverter efficiency if empty)
+	DCACRatio float64 `json:"dc_ac_ratio"` //Panel watts over inverter AC watts (DefaultDCACRatio if 0)
+
+	Layout RoofPlan   `json:"layout"`      //Roof plane and setbacks the panels are packed into
+	Faces  []RoofFace `json:"roof_planes"` //Planes of the roof, each with its own size and direction (the whole roof facing Roof if empty)
+	Shade  *Shade     `json:"shade"`       //Horizon and obstructions around the roof, for planes without their own
+
+	Finance FinanceInputs `json:"finance"`       //Finance settings changed from their defaults
+	Tariff  string        `json:"tariff"`        //Key of the utility rate the bills are priced on (a flat rate at the electricity price if empty)
+	Export  string        `json:"export_policy"` //Key of the export policy solar sent to the grid is credited under (the city's if empty)
+
+	InstallDate string `json:"install_date"` //Day the system is installed, like 2025-06-30, for the incentives that have ended (today if empty)
+}
+
+/*This is the struct storing the number of panels and total cost for one
+brand of solar panel. The estimate has one for every panel in the catalog,
+in alphabetical order.*/
+type PanelOption struct {
+	Name       string         `json:"name"`               //Panel brand name
+	Efficiency float64        `json:"efficiency_percent"` //Panel efficiency
+	Watts      float64        `json:"watts"`              //Rated watts of one panel
+	NumPanels  int            `json:"num_panels"`         //Number of panels, the ones needed or as many as fit on the roof
+	Needed     int            `json:"needed"`             //Number of panels needed to cover the usage
+	Cost       int            `json:"cost"`               //Cost of the panels plus installation, before incentives
+	Inverter   InverterChoice `json:"inverter"`           //Recommended inverter model and count (the installation cost already covers it)
+	Layouts    []RoofLayout   `json:"layouts"`            //Where the panels go on each plane of the roof
+	Finance    Finance        `json:"finance"`            //Payback, NPV, IRR, LCOE and cash flows of the system
+	Incentives CostBreakdown  `json:"incentives"`         //Gross and net cost, with each incentive applied
+	Financing  Financing      `json:"financing"`          //Cash, loans, lease and PPA side by side
+}
+
+/*This is the struct storing the recommended panel brand for each of the
+user preferences.*/
+type PanelRecommendation struct {
+	MinCost       string `json:"min_cost"`       //Lowest cost brand
+	MaxOutput     string `json:"max_output"`     //Highest output brand
+	MaxEfficiency string `json:"max_efficiency"` //Most efficient brand
+}
+
+/*This is the struct storing everything computed for the user's home. It is
+returned as-is by the JSON API and copied into PageVariables for the HTML page.*/
+type Estimate struct {
+	Latitude       float64             `json:"latitude"`                 //Latitude of the user, north positive
+	Longitude      float64             `json:"longitude"`                //Longitude of the user, east positive
+	Location       string              `json:"location"`                 //Same location with hemisphere letters
+	City           string              `json:"city"`                     //City name that is closest to the user
+	DistanceKm     float64             `json:"distance_km"`              //Great-circle distance to that city
+	DistanceMiles  float64             `json:"distance_miles"`           //Same distance in miles
+	NearestCities  []Neighbor          `json:"nearest_cities"`           //The 3 closest cities, nearest first
+	Warnings       []string            `json:"warnings,omitempty"`       //Warnings about the estimate, such as a far away city
+	Interpolation  *Interpolation      `json:"interpolation,omitempty"`  //Cities blended into the site's climate data, if any
+	Roof           Plane               `json:"roof"`                     //Which way the roof faces, with the transposition model used (its first plane if it has more than one)
+	Faces          []FaceResult        `json:"roof_planes"`              //Output of each plane of the roof and the order panels go on them
+	Output         float64             `json:"output_kwh_month"`         //Expected solar energy output of panels lying on the roof
+	Heat           HeatLoss            `json:"heat"`                     //How much of the output was lost to heat
+	Losses         LossWaterfall       `json:"losses"`                   //The output with no losses, and what heat and each loss took from it
+	Inverter       InverterLoss        `json:"inverter"`                 //Inverter efficiency and clipping at the DC/AC ratio
+	OptAngle       float64             `json:"optimal_angle_deg"`        //Optimal angle for panels
+	OptOutput      float64             `json:"optimal_output_kwh_month"` //Optimal solar energy output
+	Usage          float64             `json:"usage_kwh_month"`          //Average energy usage
+	Percentage     int                 `json:"percentage_covered"`       //Percentage that their energy is covered by solar
+	Optimal        string              `json:"recommendation"`           //Is it optimal to install solar power?
+	InstCost       float64             `json:"installation_cost"`        //Installation cost
+	Companies      []string            `json:"companies"`                //3 company names
+	Panels         []PanelOption       `json:"panels"`                   //Number and cost of panels for each brand
+	Recommendation PanelRecommendation `json:"panel_recommendation"`     //Recommended brand for each preference
+	Monthly        []MonthRow          `json:"monthly"`                  //Output and usage for each month
+	Bill           BillComparison      `json:"bill"`                     //Bills before and after solar each month, on the tariff and under the export policy
+	MonthlySource  string              `json:"monthly_source"`           //"measured" if the monthly radiation is from monthly.csv, "estimated" if it is shaped from the yearly average
+	Model          string              `json:"model"`                    //"hourly" if the output was simulated from a weather file, "quick" otherwise
+	Simulation     *Simulation         `json:"simulation,omitempty"`     //The hourly simulation at the optimal angle, without the hours
+}
+
+/*This is the struct storing the color of one city on the heat map.*/
+type CityColor struct {
+	City  string `json:"city"`  //City name
+	Color string `json:"color"` //red, yellow or green
+}
+
+/*This is the struct storing everything shown on the heat map page.*/
+type Heatmap struct {
+	Cities        []CityColor `json:"cities"`         //Color for each city, alphabetically
+	Map           []string    `json:"-"`              //Hex map colors for each city, alphabetically
+	RedList       []string    `json:"red"`            //List of cities in red
+	YellowList    []string    `json:"yellow"`         //List of cities in yellow
+	GreenList     []string    `json:"green"`          //List of cities in green
+	RedPercent    float64     `json:"red_percent"`    //Percentage of cities in red
+	YellowPercent float64     `json:"yellow_percent"` //Percentage of cities in yellow
+	GreenPercent  float64     `json:"green_percent"`  //Percentage of cities in green
+}
+
+/*This is the struct used to list a city over the API.*/
+type CityInfo struct {
+	Name         string   `json:"name"`
+	Latitude     float64  `json:"latitude"`
+	Longitude    float64  `json:"longitude"`
+	Temp         float64  `json:"temp_f"`
+	SolarRad     float64  `json:"solar_rad_kwh_m2_day"`
+	OptAngle     float64  `json:"optimal_angle_deg"`
+	OptRad       float64  `json:"optimal_rad_kwh_m2_day"`
+	AvgEnergy    float64  `json:"avg_energy_kwh_month"`
+	InstCostRate float64  `json:"installation_cost_per_watt"`
+	Companies    []string `json:"companies"`
+}
+
+/*This is the response of /api/v1/sun: where the sun is at a time, and its
+rise, noon and set on that day. Sunrise and Sunset are left out when the sun
+doesn't rise or doesn't set.*/
+type SunInfo struct {
+	Time           time.Time         `json:"time"`
+	Observer       solarpos.Observer `json:"observer"`
+	Position       solarpos.Position `json:"position"`
+	Sunrise        *time.Time        `json:"sunrise,omitempty"`
+	SolarNoon      time.Time         `json:"solar_noon"`
+	Sunset         *time.Time        `json:"sunset,omitempty"`
+	DayLengthHours float64           `json:"day_length_hours"`
+	PolarDay       bool              `json:"polar_day,omitempty"`
+	PolarNight     bool              `json:"polar_night,omitempty"`
+}
+
+/*This is the struct used to list a solar panel over the API.*/
+type PanelInfo struct {
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Technology   string   `json:"technology,omitempty"`
+	Efficiency   float64  `json:"efficiency_percent"`
+	Watts        float64  `json:"watts"`
+	PTCWatts     float64  `json:"ptc_watts,omitempty"`
+	Area         float64  `json:"area_m2"`
+	Length       float64  `json:"length_m,omitempty"`
+	Width        float64  `json:"width_m,omitempty"`
+	Price        *float64 `json:"price,omitempty"`         //left out for panels without a price, which aren't offered
+	PriceDate    string   `json:"price_updated,omitempty"` //when the price was last updated
+	Bifaciality  float64  `json:"bifaciality,omitempty"`
+	Source       string   `json:"source,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	Thermal
+	Voc      float64 `json:"voc_v,omitempty"` //the voltages and currents are left out if not known
+	Vmp      float64 `json:"vmp_v,omitempty"`
+	Isc      float64 `json:"isc_a,omitempty"`
+	Imp      float64 `json:"imp_a,omitempty"`
+	VocCoeff float64 `json:"voc_coeff_percent_per_c,omitempty"`
+	IscCoeff float64 `json:"isc_coeff_percent_per_c,omitempty"`
+}
+
+/*This is the struct used to list an inverter over the API.*/
+type InverterInfo struct {
+	Name          string     `json:"name"`
+	Type          string     `json:"type"`
+	ACWatts       float64    `json:"ac_watts"`
+	MaxDCVolts    float64    `json:"max_dc_volts"`
+	MPPTMinVolts  float64    `json:"mppt_min_volts"`
+	MPPTMaxVolts  float64    `json:"mppt_max_volts"`
+	MPPTCount     int        `json:"mppt_count"`
+	MaxInputAmps  float64    `json:"max_input_amps"`
+	MaxIscAmps    float64    `json:"max_isc_amps"`
+	Modules       int        `json:"modules"`
+	Curve         [6]float64 `json:"efficiency_curve_percent"` //efficiency at 10, 20, 30, 50, 75 and 100% load
+	CECEfficiency float64    `json:"cec_efficiency_percent"`
+	Price         float64    `json:"price"`
+}
+
+/*This is the struct returned by the strings API: the string design for a
+panel and inverter at the closest city's temperatures, and the layout of a
+number of panels if one was asked for.*/
+type StringSizing struct {
+	City string `json:"city"`
+	StringDesign
+	Layout *StringLayout `json:"layout,omitempty"`
+}
+
+/*This is the struct returned by the API when a request can't be answered.*/
+type APIError struct {
+	Error string `json:"error"`
+}
+
+//Registers the versioned JSON API handlers.
+func RegisterAPI() {
+	http.HandleFunc("/api/v1/estimate", APIEstimate)
+	http.HandleFunc("/api/v1/heatmap", APIHeatmap)
+	http.HandleFunc("/api/v1/cities", APICities)
+	http.HandleFunc("/api/v1/panels", APIPanels)
+	http.HandleFunc("/api/v1/inverters", APIInverters)
+	http.HandleFunc("/api/v1/tariffs", APITariffs)
+	http.HandleFunc("/api/v1/export-policies", APIExportPolicies)
+	http.HandleFunc("/api/v1/incentives", APIIncentives)
+	http.HandleFunc("/api/v1/strings", APIStrings)
+	http.HandleFunc("/api/v1/layout", APILayout)
+	http.HandleFunc("/api/v1/datasets", APIDatasets)
+	http.HandleFunc("/api/v1/nearest", APINearest)
+	http.HandleFunc("/api/v1/hourly", APIHourly)
+	http.HandleFunc("/api/v1/shading", APIShading)
+	http.HandleFunc("/api/v1/sun", APISun)
+}
+
+//Computes everything shown on the results page for one home.
+func ComputeEstimate(dataset *Dataset, req EstimateRequest) Estimate {
+	solarPanels := dataset.Panels
+	nearest := dataset.Index.Nearest(req.Latitude, req.Longitude, 3)
+	closestcity := nearest[0].City
+	cityData, siteName, interpolation := SiteClimate(dataset, req, nearest)
+	var warnings []string
+	if limit := getMaxStationKm(); nearest[0].DistanceKm > limit {
+		warnings = append(warnings, fmt.Sprintf("The closest city, %s, is %.0f km (%.0f miles) away, more than the %.0f km limit, so this estimate may not match your location.",
+			closestcity, nearest[0].DistanceKm, nearest[0].DistanceMiles, limit))
+	}
+	transposition := req.Transposition
+	if transposition == "" {
+		transposition = DefaultTransposition
+	}
+	azimuth := EquatorAzimuth(req.Latitude)
+	roof := Plane{Tilt: 0, Azimuth: azimuth, Model: transposition}
+	if req.Roof != nil {
+		roof.Tilt, roof.Azimuth = req.Roof.Tilt, req.Roof.Azimuth
+	}
+	system := DefaultSystem()
+	system.Losses = req.Losses
+	if req.DCACRatio != 0 {
+		system.DCACRatio = req.DCACRatio
+	}
+	if inverter, ok := dataset.Inverters[req.Inverter]; ok {
+		system.InverterName, system.Inverter = req.Inverter, &inverter
+	}
+	faces := req.RoofFaces(roof)
+	shades := ShadeFaces(faces, req.Latitude, req.Longitude, NearestWeather(dataset.Weather, req.Latitude, req.Longitude))
+	outputs := make([]float64, len(faces))
+	tables := make([][]MonthRow, len(faces))
+	heats := make([]HeatLoss, len(faces))
+	inverters := make([]InverterLoss, len(faces))
+	var solarOutput float64
+	for i, face := range faces {
+		outputs[i] = PlaneOutput(siteName, cityData, face.Plane, face.System(system), face.Area)
+		outputs[i] = float64(int(outputs[i]*100)) / 100
+		solarOutput += outputs[i]
+		tables[i], heats[i], inverters[i] = MonthlyTable(siteName, cityData, face.Plane, face.System(system), face.Area, req.HouseSize)
+	}
+	solarOutput = math.Round(solarOutput*100) / 100
+	results := RankFaces(faces, outputs, req.Losses)
+	for i := range shades {
+		results[i].Shade = shades[i]
+	}
+	optAngle := OptAngle(cityData, siteName)
+	optAngle = math.Round(optAngle*10) / 10
+	optEnergy := OptEnergy(cityData, siteName, 15, req.RoofSize)
+	optEnergy = float64(int(optEnergy*100)) / 100
+	avgUsage := AverageEnergy(cityData, siteName) * req.HouseSize
+	avgUsage = float64(int(avgUsage*100)) / 100
+	monthly := CombineMonthly(tables, faces)
+	heat, inverter := CombineHeat(heats, outputs), CombineInverter(inverters, outputs)
+
+	//use the hourly simulation instead of the quick estimate if there is a weather file near the site
+	model := ModelQuick
+	var simulation *Simulation
+	sims := make([]Simulation, len(faces))
+	var found bool
+	var err error
+	for i, face := range faces {
+		if sims[i], found, err = SimulateSite(dataset.Weather, req.Latitude, req.Longitude, face.Plane, face.System(system), face.Area); !found || err != nil {
+			break
+		}
+	}
+	onRoof := CombineSimulations(sims)
+	var tilted Simulation
+	if found && err == nil {
+		optimal := Plane{Tilt: cityData[siteName].optAng, Azimuth: azimuth, Model: transposition}
+		tilted, _, err = SimulateSite(dataset.Weather, req.Latitude, req.Longitude, optimal, system, req.RoofSize)
+	}
+	if err != nil {
+		warnings = append(warnings, "The weather file near you couldn't be used, so this is the quick estimate: "+err.Error())
+	} else if found {
+		model = ModelHourly
+		solarOutput = float64(int(onRoof.Annual/12*100)) / 100
+		optEnergy = float64(int(tilted.Annual/12*100)) / 100
+		SimulatedTable(monthly, onRoof, tilted)
+		heat, inverter = onRoof.Heat, onRoof.Inverter
+		for i := range sims {
+			results[i].Output = math.Round(sims[i].Annual/12*100) / 100
+		}
+		tilted.Hourly = nil //available from /api/v1/hourly
+		simulation = &tilted
+	}
+	companylist := Companies(siteName, cityData)
+	instCost := InstallationCost(cityData, siteName)
+	instCost = float64(int(instCost*100)) / 100
+	numPanels, needed, panelCost, layouts := FitPanels(faces, RankFaces(faces, outputs, req.Losses), req.HouseSize, cityData, siteName, solarPanels)
+	var capped []string
+	for _, name := range dataset.PanelNames {
+		if numPanels[name] < needed[name] {
+			capped = append(capped, fmt.Sprintf("%s (%d of %d)", name, numPanels[name], needed[name]))
+		}
+	}
+	if len(capped) > 0 {
+		warnings = append(warnings, "Not every panel needed fits on your roof inside the fire-code setbacks, so these brands have as many as fit: "+strings.Join(capped, ", ")+".")
+	}
+	preferences := Preferences(panelCost, solarPanels, siteName, cityData, req.HouseSize)
+
+	//price the house's bills before and after solar, hour by hour
+	tariff := FlatTariff(req.Finance.Value("electricity_price"))
+	if rate, ok := dataset.Tariffs[req.Tariff]; ok {
+		tariff = rate
+	}
+	policyKey := req.Export
+	if policyKey == "" {
+		policyKey = cityData[siteName].export
+	}
+	policy := dataset.ExportPolicy(policyKey)
+	var monthlyUsage, monthlyOutput, shares [12]float64
+	var yearOutput float64
+	for m, row := range monthly {
+		monthlyUsage[m], monthlyOutput[m] = row.Usage, row.Output
+		yearOutput += row.Output
+	}
+	for m := range shares {
+		shares[m] = 1.0 / 12
+		if yearOutput > 0 {
+			shares[m] = monthlyOutput[m] / yearOutput
+		}
+	}
+	usage, solar := HourlyUsage(monthlyUsage), HourlySolar(monthlyOutput, req.Latitude, req.Longitude)
+	if model == ModelHourly && len(onRoof.Hourly) == HoursPerYear {
+		solar = onRoof.Hourly
+	}
+	bill := CompareBills(tariff, policy, usage, solar)
+	percent, recommendation := IsItOptimal(avgUsage, solarOutput)
+
+	installed := time.Now()
+	if req.InstallDate != "" {
+		installed, _ = time.Parse("2006-01-02", req.InstallDate)
+	}
+	panels := make([]PanelOption, len(dataset.PanelNames))
+	for i, name := range dataset.PanelNames {
+		choice := RecommendInverter(solarPanels[name], numPanels[name], dataset.Inverters, system.DCACRatio, cityData[siteName].DesignTemps())
+		panel := solarPanels[name]
+		production := OptionProduction(faces, results, layouts[name], panel)
+		value := req.Finance.Value("electricity_price")
+		if production > 0 {
+			value = CompareBills(tariff, policy, usage, ScaleHours(solar, production)).Savings / production
+		}
+		systemKW := float64(numPanels[name]) * panel.watts / 1000
+		incentives := ApplyIncentives(dataset.Incentives, cityData[siteName].state, tariff.Utility, installed, float64(panelCost[name]), systemKW, production)
+		finance := AnalyzeFinance(req.Finance, incentives.Net, systemKW, production, value, incentives.Applied)
+		financing := AnalyzeFinancing(req.Finance, incentives, systemKW, production, value, shares)
+		panels[i] = PanelOption{name, panel.efficiency, panel.watts, numPanels[name], needed[name], panelCost[name], choice, layouts[name], finance, incentives, financing}
+	}
+
+	return Estimate{
+		Latitude:      req.Latitude,
+		Longitude:     req.Longitude,
+		Location:      FormatLocation(req.Latitude, req.Longitude),
+		City:          closestcity,
+		DistanceKm:    math.Round(nearest[0].DistanceKm*10) / 10,
+		DistanceMiles: math.Round(nearest[0].DistanceMiles*10) / 10,
+		NearestCities: nearest,
+		Interpolation: interpolation,
+		Warnings:      warnings,
+		Roof:          faces[0].Plane,
+		Faces:         results,
+		Output:        solarOutput,
+		Heat:          heat,
+		Losses:        MakeWaterfall(solarOutput, heat, inverter, FaceLosses(req.Losses, results)),
+		Inverter:      inverter,
+		OptAngle:      optAngle,
+		OptOutput:     optEnergy,
+		Usage:         avgUsage,
+		Percentage:    int(percent * 100),
+		Optimal:       recommendation,
+		InstCost:      instCost,
+		Companies:     companylist,
+		Panels:        panels,
+		Recommendation: PanelRecommendation{
+			MinCost:       preferences[0],
+			MaxOutput:     preferences[1],
+			MaxEfficiency: preferences[2],
+		},
+		Monthly:       monthly,
+		Bill:          bill,
+		MonthlySource: cityData[siteName].monthlySource,
+		Model:         model,
+		Simulation:    simulation,
+	}
+}
+
+//Computes the heat map colors and city lists for a house and roof size.
+func ComputeHeatmap(dataset *Dataset, houseSize, roofSize float64) Heatmap {
+	cityData := dataset.Cities
+	heatMap := MakeColorMarkers(cityData, houseSize, roofSize)
+	cities := make([]CityColor, 0)
+	for _, cityName := range dataset.CityNames {
+		if color, ok := heatMap[cityName]; ok {
+			cities = append(cities, CityColor{cityName, color})
+		}
+	}
+	return Heatmap{
+		Cities:        cities,
+		Map:           MakeColors(dataset.CityNames, cityData, houseSize, roofSize),
+		RedList:       MakeList(heatMap, "red"),
+		YellowList:    MakeList(heatMap, "yellow"),
+		GreenList:     MakeList(heatMap, "green"),
+		RedPercent:    ColorPercent(heatMap, "red"),
+		YellowPercent: ColorPercent(heatMap, "yellow"),
+		GreenPercent:  ColorPercent(heatMap, "green"),
+	}
+}
+
+//Leaves out the financing options' monthly cash flows, keeping their yearly
+//net.
+func (e *Estimate) DropMonthlyCashFlows() {
+	for i := range e.Panels {
+		for j := range e.Panels[i].Financing.Options {
+			e.Panels[i].Financing.Options[j].Months = nil
+		}
+	}
+}
+
+//Copies an estimate into the variables displayed on the results page.
+func (e Estimate) PageVariables() PageVariables {
+	panels := make([]PanelRow, len(e.Panels))
+	for i, panel := range e.Panels {
+		panels[i] = PanelRow{panel.Name, panel.Efficiency, panel.Watts, panel.NumPanels, panel.Needed, panel.Cost, "", template.HTML(FacesSVG(panel.Layouts, 400)), panel.Finance, panel.Incentives, panel.Financing}
+		if choice := panel.Inverter; choice.Name != "" {
+			panels[i].Inverter = fmt.Sprintf("%d × %s (%s inverter, DC/AC ratio %.2f, $%d)", choice.Count, choice.Name, choice.Type, choice.DCACRatio, choice.Cost)
+			if choice.Strings != nil && choice.Type != InverterMicro {
+				panels[i].Inverter += ", wired as " + choice.Strings.String()
+			}
+		}
+	}
+	return PageVariables{
+		PageTitle:      "Your Home",
+		MyCity:         e.City,
+		Location:       e.Location,
+		DistanceKm:     e.DistanceKm,
+		DistanceMiles:  e.DistanceMiles,
+		Warnings:       e.Warnings,
+		Interpolation:  e.Interpolation,
+		Roof:           e.Roof,
+		Faces:          e.Faces,
+		Output:         e.Output,
+		Heat:           e.Heat,
+		Losses:         e.Losses,
+		LossChart:      WaterfallChart(e.Losses, 300),
+		Inverter:       e.Inverter,
+		OptAngle:       e.OptAngle,
+		OptOutput:      e.OptOutput,
+		Usage:          e.Usage,
+		Optimal:        e.Optimal,
+		InstCost:       e.InstCost,
+		Companies:      e.Companies,
+		Panels:         panels,
+		Recommendation: []string{e.Recommendation.MinCost, e.Recommendation.MaxOutput, e.Recommendation.MaxEfficiency},
+		Percentage:     e.Percentage,
+		Monthly:        e.Monthly,
+		Bill:           e.Bill,
+		MonthlySource:  e.MonthlySource,
+		MonthlyChart:   MonthlyChart(e.Monthly, 480, 150),
+		Simulation:     e.Simulation,
+	}
+}
+
+//Copies a heat map into the variables displayed on the heat map page.
+func (h Heatmap) PageVariables() PageVariables {
+	return PageVariables{
+		PageTitle:     "House Size Map",
+		Map:           h.Map,
+		RedList:       h.RedList,
+		YellowList:    h.YellowList,
+		GreenList:     h.GreenList,
+		RedPercent:    h.RedPercent,
+		YellowPercent: h.YellowPercent,
+		GreenPercent:  h.GreenPercent,
+	}
+}
+
+//Returns an estimate as JSON. Accepts either a JSON body (Content-Type
+//application/json, with any parameters such as charset) or the same
+//query/form values as the HTML form (latitude and longitude or location,
+//housesize, roofsize). A JSON body with a key it doesn't know is refused.
+//The financing options' monthly cash flows are left out unless
+//monthly_cash_flows=true is in the query.
+//Invalid input gets a 400 with every field error.
+func APIEstimate(w http.ResponseWriter, r *http.Request) {
+	var req EstimateRequest
+	var errs ValidationErrors
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var body EstimateBody
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			WriteJSON(w, http.StatusBadRequest, APIError{"invalid JSON body: " + err.Error()})
+			return
+		}
+		req, errs = body.Validate()
+	} else {
+		r.ParseForm()
+		req, errs = ParseEstimateForm(r.Form)
+	}
+	dataset := Data.Get()
+	if len(errs) == 0 {
+		CheckCoverage(dataset, req.Latitude, req.Longitude, req.LocationField("latitude"), &errs)
+	}
+	CheckInverter(dataset, req.Inverter, "inverter", &errs)
+	CheckTariff(dataset, req.Tariff, "tariff", &errs)
+	CheckExportPolicy(dataset, req.Export, "export_policy", &errs)
+	if len(errs) > 0 {
+		WriteValidationErrors(w, errs)
+		return
+	}
+	estimate := ComputeEstimate(dataset, req)
+	if r.URL.Query().Get("monthly_cash_flows") != "true" {
+		estimate.DropMonthlyCashFlows()
+	}
+	WriteJSON(w, http.StatusOK, estimate)
+}
+
+//Returns the heat map as JSON for the housesize and roofsize query values.
+func APIHeatmap(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	houseSize, roofSize, errs := ParseHeatmapForm(r.Form, "housesize")
+	if len(errs) > 0 {
+		WriteValidationErrors(w, errs)
+		return
+	}
+	WriteJSON(w, http.StatusOK, ComputeHeatmap(Data.Get(), houseSize, roofSize))
+}
+
+//Returns every city in the dataset as JSON, sorted by name.
+func APICities(w http.ResponseWriter, r *http.Request) {
+	cityData := Data.Get().Cities
+	cities := make([]CityInfo, 0, len(cityData))
+	for name, city := range cityData {
+		cities = append(cities, CityInfo{name, city.lat, city.lon, city.temp, city.solarRad,
+			city.optAng, city.optRad, city.avgEnergy, city.instCost, city.companies})
+	}
+	sort.Slice(cities, func(i, j int) bool { return cities[i].Name < cities[j].Name })
+	WriteJSON(w, http.StatusOK, cities)
+}
+
+//Returns every solar panel in the catalog as JSON, sorted by name.
+func APIPanels(w http.ResponseWriter, r *http.Request) {
+	solarPanels := Data.Get().Panels
+	panels := make([]PanelInfo, 0, len(solarPanels))
+	for name, panel := range solarPanels {
+		info := PanelInfo{Name: name, Manufacturer: panel.manufacturer, Model: panel.model, Technology: panel.technology,
+			Efficiency: panel.efficiency, Watts: panel.watts, PTCWatts: panel.ptcWatts, Area: panel.area, Length: panel.length, Width: panel.width,
+			Bifaciality: panel.bifaciality, Source: panel.source, Version: panel.version, Thermal: panel.Thermal(), IscCoeff: panel.iscCoeff}
+		if panel.priced {
+			price := panel.price
+			info.Price, info.PriceDate = &price, panel.priceDate
+		}
+		if panel.HasElectrical() {
+			info.Voc, info.Vmp, info.Isc, info.Imp, info.VocCoeff = panel.voc, panel.vmp, panel.isc, panel.imp, panel.vocCoeff
+		}
+		panels = append(panels, info)
+	}
+	sort.Slice(panels, func(i, j int) bool { return panels[i].Name < panels[j].Name })
+	WriteJSON(w, http.StatusOK, panels)
+}
+
+//Packs a panel onto a roof and returns where the panels go, as JSON or, with
+//format=svg, as an SVG drawing. It takes panel, the roof's shape (roof_width
+//and roof_length or roof_polygon in feet, or roofsize for a square roof),
+//setback, ridge_setback, orientation and optionally panels, how many of the
+//spots to fill.
+func APILayout(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var errs ValidationErrors
+	dataset := Data.Get()
+	panelName := strings.TrimSpace(r.Form.Get("panel"))
+	panel, ok := dataset.Panels[panelName]
+	if panelName == "" {
+		errs.Add("panel", CodeRequired, "Please choose a panel.")
+	} else if !ok {
+		errs.Add("panel", CodeUnknown, panelName+" is not in the panel catalog.")
+	}
+	var roofSize float64
+	if r.Form.Get("roof_width") == "" && r.Form.Get("roof_length") == "" && r.Form.Get("roof_polygon") == "" {
+		roofSize = ParseSize(r.Form, "roofsize", "roof size", &errs)
+	}
+	plan := ParseRoofPlanForm(r.Form, roofSize, &errs)
+	used := ParseOptional(r.Form, "panels", "number of panels", 0, 10000, -1, &errs)
+	width := ParseOptional(r.Form, "width", "drawing width", 50, 4000, 400, &errs)
+	format := strings.TrimSpace(r.Form.Get("format"))
+	if format != "" && format != "json" && format != "svg" {
+		errs.Add("format", CodeOutOfRange, "The format must be json or svg.")
+	}
+	if len(errs) > 0 {
+		WriteValidationErrors(w, errs)
+		return
+	}
+	layout := PackRoof(plan, panel)
+	if used >= 0 && int(used) < layout.Fits {
+		layout.Used = int(used)
+	}
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprint(w, layout.SVG(width))
+		return
+	}
+	WriteJSON(w, http.StatusOK, layout)
+}
+
+//Returns every tariff in the tariffs directory as JSON, sorted by key.
+func APITariffs(w http.ResponseWriter, r *http.Request) {
+	tariffs := Data.Get().Tariffs
+	list := make([]Tariff, 0, len(tariffs))
+	for _, key := range TariffKeys(tariffs) {
+		list = append(list, tariffs[key])
+	}
+	WriteJSON(w, http.StatusOK, list)
+}
+
+//Returns every export policy in export.csv as JSON, sorted by key.
+func APIExportPolicies(w http.ResponseWriter, r *http.Request) {
+	policies := Data.Get().Export
+	list := make([]ExportPolicy, 0, len(policies))
+	for _, key := range ExportPolicyKeys(policies) {
+		list = append(list, policies[key])
+	}
+	WriteJSON(w, http.StatusOK, list)
+}
+
+//Returns every incentive in incentives.csv as JSON, in the order they are
+//applied. state limits them to the ones for everywhere and that state.
+func APIIncentives(w http.ResponseWriter, r *http.Request) {
+	state := strings.ToUpper(strings.TrimSpace(r.FormValue("state")))
+	list := make([]Incentive, 0)
+	for _, incentive := range Data.Get().Incentives {
+		if state == "" || incentive.State == "" || incentive.State == state {
+			list = append(list, incentive)
+		}
+	}
+	WriteJSON(w, http.StatusOK, list)
+}
+
+//Returns every inverter in the catalog as JSON, sorted by name.
+func APIInverters(w http.ResponseWriter, r *http.Request) {
+	catalog := Data.Get().Inverters
+	inverters := make([]InverterInfo, 0, len(catalog))
+	for name, inverter := range catalog {
+		inverters = append(inverters, InverterInfo{name, inverter.kind, inverter.acWatts, inverter.maxDCVolts,
+			inverter.mpptMinVolts, inverter.mpptMaxVolts, inverter.mpptCount, inverter.maxInputAmps, inverter.maxIscAmps, inverter.modules,
+			inverter.curve, math.Round(inverter.CECEfficiency()*100) / 100, inverter.price})
+	}
+	sort.Slice(inverters, func(i, j int) bool { return inverters[i].Name < inverters[j].Name })
+	WriteJSON(w, http.StatusOK, inverters)
+}
+
+//Returns the string design for the panel and inverter query values at the
+//temperatures of the city closest to the latitude and longitude (or
+//location). record_low_c replaces the city's record low, and with panels (a
+//number of panels) and inverters (how many, 1 string inverter or enough
+//micro inverters if not given) it also lays the panels out on the inverters. Answers 404 if they can't be wired without
+//breaking a limit.
+func APIStrings(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var errs ValidationErrors
+	dataset := Data.Get()
+	lat, lon, _ := ParseLocationForm(r.Form, &errs)
+	panelName := strings.TrimSpace(r.Form.Get("panel"))
+	panel, ok := dataset.Panels[panelName]
+	if panelName == "" {
+		errs.Add("panel", CodeRequired, "Please choose a panel.")
+	} else if !ok {
+		errs.Add("panel", CodeUnknown, panelName+" is not in the panel catalog.")
+	} else if !panel.HasElectrical() {
+		errs.Add("panel", CodeUnknown, panelName+" has no voltages and currents in the panel catalog.")
+	}
+	inverterName := strings.TrimSpace(r.Form.Get("inverter"))
+	if inverterName == "" {
+		errs.Add("inverter", CodeRequired, "Please choose an inverter.")
+	}
+	CheckInverter(dataset, inverterName, "inverter", &errs)
+	numPanels := ParseOptional(r.Form, "panels", "number of panels", 1, 10000, 0, &errs)
+	numInverters := ParseOptional(r.Form, "inverters", "number of inverters", 1, 10000, 0, &errs)
+	var recordLow float64
+	if r.Form.Get("record_low_c") != "" {
+		recordLow = ParseOptional(r.Form, "record_low_c", "record low", -70, 30, 0, &errs)
+	}
+	if len(errs) > 0 {
+		WriteValidationErrors(w, errs)
+		return
+	}
+	city := dataset.Index.Nearest(lat, lon, 1)[0].City
+	temps := dataset.Cities[city].DesignTemps()
+	if r.Form.Get("record_low_c") != "" {
+		temps.ColdC, temps.ColdEstimated = recordLow, false
+	}
+	sizing := StringSizing{City: city, StringDesign: DesignStrings(panel, dataset.Inverters[inverterName], temps)}
+	sizing.Panel, sizing.Inverter = panelName, inverterName
+	if inverter := dataset.Inverters[inverterName]; numInverters == 0 && inverter.kind == InverterMicro {
+		numInverters = math.Ceil(numPanels / float64(inverter.modules))
+	} else if numInverters == 0 {
+		numInverters = 1
+	}
+	if numPanels > 0 {
+		sizing.Layout = sizing.StringDesign.Layout(int(numPanels), int(numInverters))
+		if sizing.Layout == nil {
+			WriteJSON(w, http.StatusNotFound, APIError{fmt.Sprintf("%d %s panels can't be wired to %d %s without breaking a limit", int(numPanels), panelName, int(numInverters), inverterName)})
+			return
+		}
+	}
+	WriteJSON(w, http.StatusOK, sizing)
+}
+
+//Returns the 8760 hourly kwh values of the panels on the roof from the
+//closest weather file. Takes the latitude and longitude (or location) and
+//roofsize query values, and optionally tilt (degrees or rise/run, the optimal
+//angle if not given), azimuth (degrees clockwise from north or a compass
+//point, facing the equator if not given), transposition, the loss_ values
+//of ParseLossForm, inverter (a catalog model) and dc_ac_ratio. Answers 404
+//if there is no weather file near enough.
+func APIHourly(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var errs ValidationErrors
+	dataset := Data.Get()
+	lat, lon, _ := ParseLocationForm(r.Form, &errs)
+	roof := ParseSize(r.Form, "roofsize", "roof size", &errs)
+	var optAngle float64
+	if len(errs) == 0 {
+		if nearest := dataset.Index.Nearest(lat, lon, 1); len(nearest) > 0 {
+			optAngle = dataset.Cities[nearest[0].City].optAng
+		}
+	}
+	tilt := r.Form.Get("tilt")
+	if strings.TrimSpace(tilt) == "" {
+		tilt = FormatNumber(optAngle)
+	}
+	plane := ParseRoof(tilt, r.Form.Get("azimuth"), lat, "tilt", "azimuth", &errs)
+	system := DefaultSystem()
+	system.Losses = ParseLossForm(r.Form, &errs)
+	system.DCACRatio = ParseOptional(r.Form, "dc_ac_ratio", "DC/AC ratio", 0.5, 2, DefaultDCACRatio, &errs)
+	system.InverterName = strings.TrimSpace(r.Form.Get("inverter"))
+	CheckInverter(dataset, system.InverterName, "inverter", &errs)
+	if inverter, ok := dataset.Inverters[system.InverterName]; ok {
+		system.Inverter = &inverter
+	}
+	plane.Model = strings.TrimSpace(r.Form.Get("transposition"))
+	CheckTransposition(plane.Model, "transposition", &errs)
+	if plane.Model == "" {
+		plane.Model = DefaultTransposition
+	}
+	if len(errs) > 0 {
+		WriteValidationErrors(w, errs)
+		return
+	}
+	sim, found, err := SimulateSite(dataset.Weather, lat, lon, *plane, system, roof)
+	if !found {
+		WriteJSON(w, http.StatusNotFound, APIError{fmt.Sprintf("no weather file within %.0f km of %s", getWeatherMaxKm(), FormatLocation(lat, lon))})
+		return
+	}
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, APIError{err.Error()})
+		return
+	}
+	WriteJSON(w, http.StatusOK, sim)
+}
+
+//Returns the shading of a roof plane as JSON, for the latitude and longitude
+//(or location), pitch and azimuth (the optimal angle facing the equator if
+//neither is given), the roof's shape as in the layout API and horizon,
+//obstructions and eave_height as in ParseShadeForm. hourly=true adds the
+//share of the plane in shade each hour.
+func APIShading(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var errs ValidationErrors
+	dataset := Data.Get()
+	lat, lon, _ := ParseLocationForm(r.Form, &errs)
+	plane := ParseRoof(r.Form.Get("pitch"), r.Form.Get("azimuth"), lat, "pitch", "azimuth", &errs)
+	var roofSize float64
+	if r.Form.Get("roof_width") == "" && r.Form.Get("roof_length") == "" && r.Form.Get("roof_polygon") == "" {
+		roofSize = ParseSize(r.Form, "roofsize", "roof size", &errs)
+	}
+	face := RoofFace{Name: "Roof", Area: roofSize, Layout: ParseRoofPlanForm(r.Form, roofSize, &errs)}
+	shade := ParseShadeForm(r.Form, &errs)
+	if shade == nil && len(errs) == 0 {
+		errs.Add("horizon", CodeRequired, "Please enter a horizon or obstructions.")
+	}
+	if len(errs) > 0 {
+		WriteValidationErrors(w, errs)
+		return
+	}
+	if plane == nil {
+		plane = &Plane{Azimuth: EquatorAzimuth(lat)}
+		if nearest := dataset.Index.Nearest(lat, lon, 1); len(nearest) > 0 {
+			plane.Tilt = dataset.Cities[nearest[0].City].optAng
+		}
+	}
+	face.Plane = *plane
+	report := AnalyzeShade(*shade, face, lat, lon, NearestWeather(dataset.Weather, lat, lon), r.Form.Get("hourly") == "true")
+	WriteJSON(w, http.StatusOK, report)
+}
+
+//Works out where the sun is at a time and its rise, noon and set that day.
+func ComputeSun(t time.Time, obs solarpos.Observer) SunInfo {
+	day := solarpos.SunTimes(t, obs)
+	info := SunInfo{
+		Time:           t,
+		Observer:       obs,
+		Position:       solarpos.SunPosition(t, obs),
+		SolarNoon:      day.SolarNoon,
+		DayLengthHours: math.Round(day.DayLength.Hours()*10000) / 10000,
+		PolarDay:       day.PolarDay,
+		PolarNight:     day.PolarNight,
+	}
+	if !day.PolarDay && !day.PolarNight {
+		info.Sunrise, info.Sunset = &day.Sunrise, &day.Sunset
+	}
+	return info
+}
+
+//Returns where the sun is and its rise, noon and set, for the query values
+//read by ParseSunForm.
+func APISun(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	t, obs, errs := ParseSunForm(r.Form)
+	if len(errs) > 0 {
+		WriteValidationErrors(w, errs)
+		return
+	}
+	WriteJSON(w, http.StatusOK, ComputeSun(t, obs))
+}
+
+//Returns the k cities nearest to the latitude and longitude (or location)
+//query values (k is 5 if not given, at most 50), with their great-circle distances.
+func APINearest(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var errs ValidationErrors
+	lat, lon, _ := ParseLocationForm(r.Form, &errs)
+	k := 5.0
+	if r.Form.Get("k") != "" {
+		if value, ok := ParseField(r.Form, "k", "number of cities", &errs); ok {
+			CheckRange(value, 1, 50, "k", "number of cities", &errs)
+			k = value
+		}
+	}
+	if len(errs) > 0 {
+		WriteValidationErrors(w, errs)
+		return
+	}
+	WriteJSON(w, http.StatusOK, Data.Get().Index.Nearest(lat, lon, int(k)))
+}
+
+//Returns when the data files were last loaded and whether the last reload failed.
+func APIDatasets(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, Data.Status())
+}
+
+//Writes the field errors as a 400 response.
+func WriteValidationErrors(w http.ResponseWriter, errs ValidationErrors) {
+	WriteJSON(w, http.StatusBadRequest, ValidationResponse{"invalid input", errs})
+}
+
+//Writes a value as JSON with the given status code.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Print("json encoding error: ", err)
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(APIError{"could not encode response"})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
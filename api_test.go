@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//Loads the data files in the repository into Data for the handlers.
+func loadTestData(t *testing.T) {
+	t.Helper()
+	if Data != nil {
+		return
+	}
+	store, err := NewDataStore(DefaultDataFiles)
+	if err != nil {
+		t.Fatalf("loading the data files: %v", err)
+	}
+	Data = store
+}
+
+func TestAPIShadingWithoutRoof(t *testing.T) {
+	loadTestData(t)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/shading?latitude=37.77&longitude=-122.42&roofsize=1000&horizon=90,5", nil)
+	w := httptest.NewRecorder()
+	APIShading(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var report map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decoding the report: %v", err)
+	}
+}
+
+func TestAPIShadingWithoutHorizon(t *testing.T) {
+	loadTestData(t)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/shading?latitude=37.77&longitude=-122.42&roofsize=1000", nil)
+	w := httptest.NewRecorder()
+	APIShading(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
+
+func TestAPIEstimateJSONWithCharset(t *testing.T) {
+	loadTestData(t)
+	body := `{"latitude": 40.71, "longitude": -74.0, "house_size": 2000, "roof_size": 800}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/estimate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	w := httptest.NewRecorder()
+	APIEstimate(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestAPIEstimateUnknownKey(t *testing.T) {
+	loadTestData(t)
+	body := `{"latitude": 40.71, "longitude": -74.0, "house_size": 2000, "roofsize": 800}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/estimate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	APIEstimate(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "roofsize") {
+		t.Fatalf("status %d, want %d naming roofsize: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
+
+func TestAPIEstimateWithoutPanels(t *testing.T) {
+	loadTestData(t)
+	body := `{"latitude": 40.71, "longitude": -74.0, "house_size": 2000, "roof_size": 1}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/estimate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	APIEstimate(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var estimate Estimate
+	if err := json.Unmarshal(w.Body.Bytes(), &estimate); err != nil {
+		t.Fatalf("decoding the estimate: %v", err)
+	}
+	for _, panel := range estimate.Panels {
+		if panel.NumPanels != 0 {
+			continue
+		}
+		if panel.Finance.LCOE != nil {
+			t.Errorf("%s: LCOE %v with no panels, want null", panel.Name, *panel.Finance.LCOE)
+		}
+		for _, option := range panel.Financing.Options {
+			if option.Payback != nil {
+				t.Errorf("%s: %s pays back in %v years with no panels, want null", panel.Name, option.Kind, *option.Payback)
+			}
+		}
+	}
+}
+
+//A south facing system in Phoenix makes a realistic amount for its size and
+//pays for itself.
+func TestAPIEstimateSunnyCity(t *testing.T) {
+	loadTestData(t)
+	body := `{"latitude": 33.45, "longitude": -112.07, "house_size": 2000, "roof_size": 1500, "pitch": 25, "azimuth": 180, "export_policy": "net-metering"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/estimate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	APIEstimate(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var estimate Estimate
+	if err := json.Unmarshal(w.Body.Bytes(), &estimate); err != nil {
+		t.Fatalf("decoding the estimate: %v", err)
+	}
+	if len(estimate.Panels) == 0 {
+		t.Fatal("no panels in the estimate")
+	}
+	for _, panel := range estimate.Panels {
+		finance := panel.Finance
+		if finance.SystemKW <= 0 {
+			t.Errorf("%s: a system of %v kw", panel.Name, finance.SystemKW)
+			continue
+		}
+		//PVWatts gives about 1,700 kwh per kw in Phoenix, less with the dataset's lower radiation
+		if yield := finance.Production / finance.SystemKW; yield < 1000 || yield > 2200 {
+			t.Errorf("%s: %.0f kwh a year for each kw, want 1000 to 2200", panel.Name, yield)
+		}
+		if finance.Payback == nil {
+			t.Errorf("%s: never pays back", panel.Name)
+		} else if *finance.Payback > float64(finance.Years) {
+			t.Errorf("%s: pays back in %v years, after the system's %d", panel.Name, *finance.Payback, finance.Years)
+		}
+	}
+}
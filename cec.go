@@ -0,0 +1,362 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file imports panels from the California Energy
+Commission's PV module list, the CSV export of the list (or of its XLSX
+file) with its notes above the header row and a row of units under it. Each
+module becomes a row of solar.csv named by its manufacturer and model, with
+its STC and PTC watts, size, technology, temperature coefficients, voltages
+and currents and bifaciality. A module listed more than once is imported
+once, from its latest update. Imports are versioned: every row records the
+import that last changed it, and every import is logged in
+panel_imports.csv with the file's checksum, so the same file isn't imported
+twice. Prices aren't in the module list; they go in prices.csv.*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//The source of rows imported from the CEC module list.
+const SourceCEC = "cec"
+
+//The bifaciality of modules the list marks as bifacial without giving one,
+//typical of bifacial PERC modules.
+const DefaultBifaciality = 0.7
+
+//Header names the CEC module list uses for each value read, by the solar.csv
+//column it fills (bifacial and updated are only used by the import). The
+//first name found in the header is used. Names are compared by
+//NormalizeHeader, so "Nameplate Pmax" matches "nameplatepmax" and "A_c"
+//matches "ac".
+var CECColumns = map[string][]string{
+	"manufacturer": {"manufacturer"},
+	"model":        {"modelnumber", "model"},
+	"technology":   {"technology"},
+	"watts":        {"nameplatepmax", "pmax", "stc"},
+	"ptc_watts":    {"ptc"},
+	"area":         {"ac", "area"},
+	"length":       {"longside"},
+	"width":        {"shortside"},
+	"noct":         {"averagenoct", "noct"},
+	"temp_coeff":   {"γpmax", "gammapmax", "γpmp", "gammapmp"},
+	"voc_coeff":    {"βvoc", "betavoc", "βoc", "betaoc"},
+	"isc_coeff":    {"αisc", "alphaisc", "αsc", "alphasc"},
+	"voc":          {"nameplatevoc", "voc"},
+	"vmp":          {"nameplatevpmax", "vpmax", "vmp"},
+	"isc":          {"nameplateisc", "isc"},
+	"imp":          {"nameplateipmax", "ipmax", "imp"},
+	"bifacial":     {"bifacial"},
+	"bifaciality":  {"bifaciality", "bifacialityfactor"},
+	"updated":      {"lastupdate", "ceclistingdate"},
+}
+
+//The date layouts the CEC module list has used.
+var CECDateLayouts = []string{"1/2/2006", "2006-01-02", "01/02/2006", "1/2/06"}
+
+//The columns of panel_imports.csv, the log of imports.
+var ImportLogSchema = Schema{
+	Name: "panel imports",
+	Key:  "version",
+	Columns: []Column{
+		{Name: "version", Kind: KindText, Required: true},
+		{Name: "imported_at", Unit: "RFC3339 time", Kind: KindText, Required: true},
+		{Name: "file", Kind: KindText, Required: true},
+		{Name: "sha256", Kind: KindText, Required: true},
+		{Name: "modules", Unit: "modules read, after duplicates", Kind: KindNumber, Min: 0, Max: 1e7, Required: true},
+		{Name: "duplicates", Kind: KindNumber, Min: 0, Max: 1e7, Required: true},
+		{Name: "skipped", Unit: "rows with a problem", Kind: KindNumber, Min: 0, Max: 1e7, Required: true},
+		{Name: "added", Kind: KindNumber, Min: 0, Max: 1e7, Required: true},
+		{Name: "updated", Kind: KindNumber, Min: 0, Max: 1e7, Required: true},
+		{Name: "unchanged", Kind: KindNumber, Min: 0, Max: 1e7, Required: true},
+	},
+}
+
+/*This is the struct storing what an import did.*/
+type ImportSummary struct {
+	Version    string    `json:"version"`
+	ImportedAt time.Time `json:"imported_at"`
+	File       string    `json:"file"`
+	SHA256     string    `json:"sha256"`
+	Modules    int       `json:"modules"`    //modules read, after duplicates
+	Duplicates int       `json:"duplicates"` //rows left out for a later listing of the same module
+	Skipped    int       `json:"skipped"`    //rows left out for a problem
+	Added      int       `json:"added"`
+	Updated    int       `json:"updated"`
+	Unchanged  int       `json:"unchanged"`
+}
+
+//Makes a header name comparable: lower case, with only letters and digits.
+func NormalizeHeader(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+//Gives the key modules are deduplicated by: the name in lower case with
+//single spaces.
+func ModuleKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+//Reads the CEC module list into solar.csv records, one for each module. Rows
+//with a problem are left out, described in the diagnostics and counted as
+//skipped, and rows for a module listed again later are left out and counted
+//as duplicates. The error is only set if the file couldn't be read at all.
+func ParseCEC(in io.Reader, filename string, summary *ImportSummary) ([]Record, Diagnostics, error) {
+	var diagnostics Diagnostics
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading %s: %v", filename, err)
+	}
+
+	headerIdx := -1
+	columnIdx := make(map[string]int)
+	for i, row := range rows {
+		names := make(map[string]int)
+		for idx, name := range row {
+			if _, ok := names[NormalizeHeader(name)]; !ok {
+				names[NormalizeHeader(name)] = idx
+			}
+		}
+		if _, ok := names["manufacturer"]; !ok {
+			continue
+		}
+		headerIdx = i
+		for key, aliases := range CECColumns {
+			for _, alias := range aliases {
+				if idx, ok := names[alias]; ok {
+					columnIdx[key] = idx
+					break
+				}
+			}
+		}
+		break
+	}
+	if headerIdx < 0 {
+		diagnostics.Add(filename, 1, "", "no header row with a Manufacturer column")
+		return nil, diagnostics, nil
+	}
+	for _, key := range []string{"model", "watts"} {
+		if _, ok := columnIdx[key]; !ok {
+			diagnostics.Add(filename, headerIdx+1, CECColumns[key][0], "required column is missing from the header")
+		}
+	}
+	_, hasArea := columnIdx["area"]
+	_, hasLength := columnIdx["length"]
+	_, hasWidth := columnIdx["width"]
+	if !hasArea && !(hasLength && hasWidth) {
+		diagnostics.Add(filename, headerIdx+1, "ac", "the header needs A_c or both Long Side and Short Side")
+	}
+	if len(diagnostics) > 0 {
+		return nil, diagnostics, nil
+	}
+
+	panelIdx := make(map[string]int)
+	for i, column := range PanelSchema.Columns {
+		panelIdx[column.Name] = i
+	}
+	latest := make(map[string]Record)
+	dates := make(map[string]time.Time)
+	for i := headerIdx + 1; i < len(rows); i++ {
+		row, line := rows[i], i+1
+		value := func(key string) string {
+			if idx, ok := columnIdx[key]; ok && idx < len(row) {
+				return strings.TrimSpace(row[idx])
+			}
+			return ""
+		}
+		if IsBlankRow(row) {
+			continue
+		}
+		if _, err := strconv.ParseFloat(value("watts"), 64); err != nil && i == headerIdx+1 {
+			continue //the row of units under the header
+		}
+		fields, problem := CECFields(value)
+		if problem != "" {
+			diagnostics.Add(filename, line, "", problem)
+			summary.Skipped++
+			continue
+		}
+		record, ok := PanelSchema.ParseRow(fields, panelIdx, filename, line, &diagnostics)
+		if !ok {
+			summary.Skipped++
+			continue
+		}
+		var checks Diagnostics
+		CheckPanels([]Record{record}, filename, &checks)
+		if len(checks) > 0 {
+			diagnostics = append(diagnostics, checks...)
+			summary.Skipped++
+			continue
+		}
+		var updated time.Time
+		for _, layout := range CECDateLayouts {
+			if date, err := time.Parse(layout, value("updated")); err == nil {
+				updated = date
+				break
+			}
+		}
+		key := ModuleKey(record.Key(PanelSchema))
+		if _, ok := latest[key]; ok {
+			summary.Duplicates++
+			if updated.Before(dates[key]) {
+				continue
+			}
+		}
+		latest[key], dates[key] = record, updated
+	}
+	records := make([]Record, 0, len(latest))
+	for _, record := range latest {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key(PanelSchema) < records[j].Key(PanelSchema) })
+	summary.Modules = len(records)
+	return records, diagnostics, nil
+}
+
+//Makes the fields of a solar.csv row, in PanelSchema's order, from the values
+//of one row of the CEC module list. The area is A_c, or the long side times
+//the short side, and the efficiency is the STC watts over the area in
+//1000 W/m² of sunlight. It gives a problem if the row can't be made.
+func CECFields(value func(key string) string) ([]string, string) {
+	number := func(key string) float64 {
+		n, _ := strconv.ParseFloat(value(key), 64)
+		return n
+	}
+	manufacturer := strings.Join(strings.Fields(value("manufacturer")), " ")
+	model := strings.Join(strings.Fields(value("model")), " ")
+	if manufacturer == "" || model == "" {
+		return nil, "manufacturer and model number are needed"
+	}
+	length, width := math.Max(number("length"), number("width")), math.Min(number("length"), number("width"))
+	area := number("area")
+	if area <= 0 {
+		area = length * width
+	}
+	if area <= 0 {
+		return nil, "the area or the long and short sides are needed"
+	}
+	values := map[string]string{
+		"name":         manufacturer + " " + model,
+		"manufacturer": manufacturer,
+		"model":        model,
+		"technology":   value("technology"),
+		"source":       SourceCEC,
+		"area":         strconv.FormatFloat(math.Round(area*10000)/10000, 'f', -1, 64),
+	}
+	if watts := number("watts"); watts > 0 {
+		values["efficiency"] = strconv.FormatFloat(math.Round(watts/(area*1000)*10000)/100, 'f', -1, 64)
+	}
+	if length > 0 && width > 0 {
+		values["length"] = strconv.FormatFloat(length, 'f', -1, 64)
+		values["width"] = strconv.FormatFloat(width, 'f', -1, 64)
+	}
+	for _, key := range []string{"watts", "ptc_watts", "noct", "temp_coeff", "voc_coeff", "isc_coeff", "voc", "vmp", "isc", "imp", "bifaciality"} {
+		values[key] = value(key)
+	}
+	switch strings.ToLower(value("bifacial")) {
+	case "y", "yes", "true", "1":
+		if values["bifaciality"] == "" {
+			values["bifaciality"] = strconv.FormatFloat(DefaultBifaciality, 'f', -1, 64)
+		}
+	}
+	fields := make([]string, len(PanelSchema.Columns))
+	for i, column := range PanelSchema.Columns {
+		fields[i] = values[column.Name]
+	}
+	return fields, ""
+}
+
+//Merges imported records into the panel records under a version. New
+//modules are added at the end in name order and changed ones are replaced
+//in place; both get the version. Unchanged modules keep the version that
+//last changed them, and rows that didn't come from the CEC list are never
+//replaced.
+func MergePanels(existing, imported []Record, version string, summary *ImportSummary) []Record {
+	merged := make([]Record, len(existing))
+	copy(merged, existing)
+	position := make(map[string]int)
+	for i, record := range merged {
+		position[ModuleKey(record.Key(PanelSchema))] = i
+	}
+	for _, record := range imported {
+		record.Text["version"] = version
+		i, ok := position[ModuleKey(record.Key(PanelSchema))]
+		if !ok {
+			merged = append(merged, record)
+			summary.Added++
+			continue
+		}
+		old := merged[i]
+		if old.Text["source"] != SourceCEC {
+			summary.Unchanged++
+			continue
+		}
+		record.Text["version"] = old.Text["version"]
+		if strings.Join(PanelSchema.Fields(old), ",") == strings.Join(PanelSchema.Fields(record), ",") {
+			summary.Unchanged++
+			continue
+		}
+		record.Text["version"] = version
+		merged[i] = record
+		summary.Updated++
+	}
+	return merged
+}
+
+//Gives the checksum of a file's contents, in hex.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+//Makes a version name for an import on a day that isn't already in the
+//import log, such as cec-2024-05-01, then cec-2024-05-01.2.
+func NextVersion(log []Record, day time.Time) string {
+	taken := make(map[string]bool)
+	for _, record := range log {
+		taken[record.Key(ImportLogSchema)] = true
+	}
+	version := SourceCEC + "-" + day.Format("2006-01-02")
+	for n := 2; taken[version]; n++ {
+		version = fmt.Sprintf("%s-%s.%d", SourceCEC, day.Format("2006-01-02"), n)
+	}
+	return version
+}
+
+//Makes the import log's record of an import.
+func (summary ImportSummary) Record() Record {
+	return Record{
+		Text: map[string]string{
+			"version":     summary.Version,
+			"imported_at": summary.ImportedAt.Format(time.RFC3339),
+			"file":        summary.File,
+			"sha256":      summary.SHA256,
+		},
+		Numbers: map[string]float64{
+			"modules":    float64(summary.Modules),
+			"duplicates": float64(summary.Duplicates),
+			"skipped":    float64(summary.Skipped),
+			"added":      float64(summary.Added),
+			"updated":    float64(summary.Updated),
+			"unchanged":  float64(summary.Unchanged),
+		},
+	}
+}
@@ -0,0 +1,448 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file holds the commands that can be run from the command
+line instead of starting the web server, for example
+go run . validate-data*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Commands by name. Each one gets the arguments after its name and returns
+//the exit code.
+var Commands = map[string]func(args []string) int{
+	"validate-data": ValidateDataCommand,
+	"import-cec":    ImportCECCommand,
+	"fetch-monthly": FetchMonthlyCommand,
+	"simulate":      SimulateCommand,
+	"sun":           SunCommand,
+}
+
+//Runs the command named by the first argument. It returns false if there is
+//no such command, in which case the server should be started.
+func RunCommand(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		names := make([]string, 0, len(Commands))
+		for name := range Commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("Run with no arguments to start the web server, or with one of these commands:")
+		for _, name := range names {
+			fmt.Println("  " + name)
+		}
+		return 0, true
+	}
+	command, ok := Commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q, run with help to list the commands\n", args[0])
+		return 2, true
+	}
+	return command(args[1:]), true
+}
+
+//Checks energy.csv, solar.csv, prices.csv, inverters.csv, monthly.csv, export.csv and incentives.csv against their schemas, and the weather and tariff files, and prints every problem.
+//Exits with 1 if there were any.
+func ValidateDataCommand(args []string) int {
+	flags := flag.NewFlagSet("validate-data", flag.ContinueOnError)
+	files := DefaultDataFiles
+	flags.StringVar(&files.Cities, "cities", files.Cities, "city data file")
+	flags.StringVar(&files.Panels, "panels", files.Panels, "solar panel data file")
+	flags.StringVar(&files.Prices, "prices", files.Prices, "panel price sheet")
+	flags.StringVar(&files.Inverters, "inverters", files.Inverters, "inverter data file")
+	flags.StringVar(&files.Monthly, "monthly", files.Monthly, "monthly data file (optional)")
+	flags.StringVar(&files.Export, "export", files.Export, "export policy file (optional)")
+	flags.StringVar(&files.Incentive, "incentives", files.Incentive, "incentive file (optional)")
+	flags.StringVar(&files.Weather, "weather", files.Weather, "directory of TMY3/EPW weather files (optional)")
+	flags.StringVar(&files.Tariffs, "tariffs", files.Tariffs, "directory of URDB tariff files (optional)")
+	asJSON := flags.Bool("json", false, "print the problems as JSON")
+	showSchema := flags.Bool("schema", false, "print the expected columns instead of checking the files")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *showSchema {
+		for _, schema := range []Schema{CitySchema, PanelSchema, PriceSchema, InverterSchema, MonthlySchema, ExportSchema, IncentiveSchema} {
+			fmt.Printf("%s (unique key: %s)\n", schema.Name, schema.Key)
+			for _, column := range schema.Columns {
+				line := fmt.Sprintf("  %-15s %-7s", column.Name, column.Kind)
+				if column.Kind == KindNumber {
+					line += fmt.Sprintf(" %v to %v %s", column.Min, column.Max, column.Unit)
+				}
+				if !column.Required {
+					line += " (optional)"
+				}
+				fmt.Println(strings.TrimRight(line, " "))
+			}
+		}
+		return 0
+	}
+
+	_, diagnostics, err := LoadDataset(files)
+	if *asJSON {
+		if diagnostics == nil {
+			diagnostics = Diagnostics{}
+		}
+		out, _ := json.MarshalIndent(diagnostics, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, diagnostic := range diagnostics {
+			fmt.Println(diagnostic)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	cityRecords, _, _ := ReadTable(files.Cities, CitySchema)
+	monthlyRecords, _, _ := ReadMonthly(files.Monthly)
+	for _, warning := range MissingMonthly(cityRecords, monthlyRecords, files.Cities, files.Monthly) {
+		fmt.Fprintln(os.Stderr, "Warning:", warning)
+	}
+	fmt.Fprintf(os.Stderr, "%s are valid.\n", strings.Join(files.Names(), ", "))
+	return 0
+}
+
+//Fills monthly.csv with each city's monthly radiation from NREL's PVWatts
+//API, on a flat surface and at the city's opt_angle. With -weather the
+//radiation comes from the closest weather file in a directory instead, which
+//needs no API key or network, and cities without one within WEATHER_MAX_KM
+//are skipped. The usage columns of rows already in the file are kept. Cities
+//that fail are printed and keep their old row. Exits with 1 if any failed or
+//the file couldn't be written.
+func FetchMonthlyCommand(args []string) int {
+	flags := flag.NewFlagSet("fetch-monthly", flag.ContinueOnError)
+	key := flags.String("key", os.Getenv("NREL_API_KEY"), "NREL developer API key (default $NREL_API_KEY, or DEMO_KEY)")
+	citiesFile := flags.String("cities", DefaultDataFiles.Cities, "city data file")
+	monthlyFile := flags.String("monthly", DefaultDataFiles.Monthly, "monthly data file to fill")
+	only := flags.String("city", "", "fetch only this city")
+	weatherDir := flags.String("weather", "", "directory of TMY3/EPW weather files to use instead of PVWatts")
+	delay := flags.Duration("delay", 2*time.Second, "wait between requests, for the API's rate limit")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *key == "" {
+		*key = "DEMO_KEY"
+	}
+
+	cityRecords, diagnostics, err := ReadTable(*citiesFile, CitySchema)
+	if err == nil && len(diagnostics) > 0 {
+		err = fmt.Errorf("%d problems found in %s, first: %v", len(diagnostics), *citiesFile, diagnostics[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	monthlyRecords, _, err := ReadMonthly(*monthlyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	rows := make(map[string]Record)
+	for _, record := range monthlyRecords {
+		rows[record.Key(MonthlySchema)] = record
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var problems Diagnostics
+	weather := LoadWeatherIndex(*weatherDir, &problems)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, "Skipping", problem)
+	}
+	code, requests := 0, 0
+	for _, city := range cityRecords {
+		name := city.Key(CitySchema)
+		if *only != "" && name != *only {
+			continue
+		}
+		lat, lon := city.Numbers["lat"], city.Numbers["lon"]
+		var file *WeatherFile
+		if *weatherDir != "" {
+			station, km, ok := weather.Nearest(lat, lon, getWeatherMaxKm())
+			if !ok {
+				fmt.Printf("%s: skipped, no weather file within %v km\n", name, getWeatherMaxKm())
+				continue
+			}
+			var err error
+			if file, err = weather.Load(station); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+				code = 1
+				continue
+			}
+			fmt.Printf("%s: using %s, %.1f km away\n", name, filepath.Base(station.File), km)
+		}
+		var flat, tilted [12]float64
+		for i, tilt := range []float64{0, city.Numbers["opt_angle"]} {
+			var radiation [12]float64
+			var err error
+			if file != nil {
+				radiation, err = WeatherMonthlyRadiation(file, tilt)
+			} else {
+				if requests > 0 {
+					time.Sleep(*delay)
+				}
+				requests++
+				radiation, err = FetchMonthlyRadiation(client, *key, lat, lon, tilt)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+				code = 1
+				break
+			}
+			if i == 0 {
+				flat = radiation
+			} else {
+				tilted = radiation
+			}
+		}
+		if tilted == ([12]float64{}) {
+			continue
+		}
+		row, ok := rows[name]
+		if !ok {
+			row = Record{Text: map[string]string{"city": name}, Numbers: make(map[string]float64)}
+		}
+		for m, month := range MonthNames {
+			month = strings.ToLower(month)
+			row.Numbers["ghi_"+month], row.Numbers["tilt_"+month] = flat[m], tilted[m]
+		}
+		rows[name] = row
+		fmt.Printf("%s: ghi %v, tilt %v\n", name, flat, tilted)
+	}
+
+	records := make([]Record, 0, len(rows))
+	for _, city := range cityRecords {
+		if row, ok := rows[city.Key(CitySchema)]; ok {
+			records = append(records, row)
+		}
+	}
+	if err := WriteTableFile(*monthlyFile, MonthlySchema, records); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return code
+}
+
+//Imports the CEC module list into solar.csv under a new version and logs the
+//import. Rows with a problem are printed and left out. Exits with 1 if the
+//files couldn't be read or written, or if the file was already imported.
+func ImportCECCommand(args []string) int {
+	flags := flag.NewFlagSet("import-cec", flag.ContinueOnError)
+	filename := flags.String("file", "", "CSV export of the CEC PV module list (required)")
+	panelFile := flags.String("panels", DefaultDataFiles.Panels, "solar panel data file to merge into")
+	logFile := flags.String("log", "panel_imports.csv", "import log")
+	version := flags.String("version", "", "name of this import (default: cec- and today's date)")
+	dryRun := flags.Bool("dry-run", false, "print what would change without writing anything")
+	force := flags.Bool("force", false, "import a file even if it was already imported")
+	asJSON := flags.Bool("json", false, "print the summary as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *filename == "" {
+		fmt.Fprintln(os.Stderr, "Error: -file is required")
+		return 2
+	}
+
+	data, err := os.ReadFile(*filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	summary := ImportSummary{ImportedAt: time.Now().UTC().Truncate(time.Second), File: filepath.Base(*filename), SHA256: Checksum(data)}
+	var log []Record
+	if _, err := os.Stat(*logFile); err == nil {
+		var diagnostics Diagnostics
+		log, diagnostics, err = ReadTable(*logFile, ImportLogSchema)
+		if err == nil && len(diagnostics) > 0 {
+			err = errors.New(diagnostics[0].String())
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	}
+	for _, record := range log {
+		if record.Text["sha256"] == summary.SHA256 && !*force {
+			fmt.Fprintf(os.Stderr, "Error: %s was already imported as %s (use -force to import it again)\n", *filename, record.Key(ImportLogSchema))
+			return 1
+		}
+		if record.Key(ImportLogSchema) == *version {
+			fmt.Fprintf(os.Stderr, "Error: %s is already in %s\n", *version, *logFile)
+			return 1
+		}
+	}
+	summary.Version = *version
+	if summary.Version == "" {
+		summary.Version = NextVersion(log, summary.ImportedAt)
+	}
+
+	imported, diagnostics, err := ParseCEC(bytes.NewReader(data), *filename, &summary)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintln(os.Stderr, diagnostic)
+	}
+	existing, panelDiagnostics, err := ReadTable(*panelFile, PanelSchema)
+	if err == nil && len(panelDiagnostics) > 0 {
+		err = errors.New(panelDiagnostics[0].String())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	merged := MergePanels(existing, imported, summary.Version, &summary)
+
+	if !*dryRun && summary.Modules > 0 {
+		if err := WriteTableFile(*panelFile, PanelSchema, merged); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if err := WriteTableFile(*logFile, ImportLogSchema, append(log, summary.Record())); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(summary, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("%s: %d modules (%d duplicates, %d skipped): %d added, %d updated, %d unchanged\n",
+			summary.Version, summary.Modules, summary.Duplicates, summary.Skipped, summary.Added, summary.Updated, summary.Unchanged)
+	}
+	if summary.Modules == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no modules were read from", *filename)
+		return 1
+	}
+	return 0
+}
+
+//Simulates panels hour by hour from a weather file and prints the kwh made in
+//each of the 8760 hours as CSV, or the whole simulation as JSON.
+func SimulateCommand(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	filename := flags.String("weather", "", "TMY3 or EPW weather file (required)")
+	tilt := flags.String("tilt", "0", "tilt of the panels, degrees from horizontal or rise/run such as 4/12")
+	azimuth := flags.String("azimuth", "", "direction the panels face, degrees clockwise from north or a compass point (default: facing the equator)")
+	model := flags.String("model", DefaultTransposition, "transposition model: isotropic, hay-davies or perez")
+	area := flags.Float64("area", 1, "area of the panels in m²")
+	dcacRatio := flags.Float64("dc-ac-ratio", DefaultDCACRatio, "panel watts over inverter AC watts")
+	inverterName := flags.String("inverter", "", "inverter model whose efficiency curve is used (default: the loss chain's inverter efficiency)")
+	inverterFile := flags.String("inverters", DefaultDataFiles.Inverters, "inverter data file")
+	asJSON := flags.Bool("json", false, "print the simulation as JSON")
+	lossForm := url.Values{}
+	flags.Func("loss", "change a loss from its default, as key=percent such as soiling=5 (can be repeated)", func(value string) error {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return errors.New("a loss is key=percent, such as soiling=5")
+		}
+		lossForm.Set("loss_"+strings.TrimSpace(parts[0]), parts[1])
+		return nil
+	})
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *filename == "" || *area <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -weather is required and -area must be more than 0")
+		return 2
+	}
+
+	weather, err := ReadWeather(*filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	var errs ValidationErrors
+	plane := ParseRoof(*tilt, *azimuth, weather.Station.Latitude, "tilt", "azimuth", &errs)
+	CheckTransposition(*model, "model", &errs)
+	system := DefaultSystem()
+	system.Losses = ParseLossForm(lossForm, &errs)
+	system.DCACRatio = *dcacRatio
+	CheckRange(*dcacRatio, 0.5, 2, "dc-ac-ratio", "DC/AC ratio", &errs)
+	if *inverterName != "" {
+		records, diagnostics, err := ReadTable(*inverterFile, InverterSchema)
+		if err == nil && len(diagnostics) > 0 {
+			err = errors.New(diagnostics[0].String())
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		inverter, ok := MakeInverterMap(records)[*inverterName]
+		if !ok {
+			errs.Add("inverter", CodeUnknown, *inverterName+" is not in "+*inverterFile+".")
+		}
+		system.InverterName, system.Inverter = *inverterName, &inverter
+	}
+	for field := range lossForm {
+		if _, ok := FindLoss(strings.TrimPrefix(field, "loss_")); !ok {
+			errs.Add("loss", CodeUnknown, strings.TrimPrefix(field, "loss_")+" is not a loss.")
+		}
+	}
+	if len(errs) > 0 {
+		for _, fieldErr := range errs {
+			fmt.Fprintf(os.Stderr, "Error: -%s: %s\n", fieldErr.Field, fieldErr.Message)
+		}
+		return 2
+	}
+	plane.Model = *model
+	sim, err := Simulate(weather, *plane, system, *area)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(sim, "", "  ")
+		fmt.Println(string(out))
+		return 0
+	}
+	fmt.Println("month,day,hour,kwh")
+	for i, hour := range weather.Hours {
+		fmt.Printf("%d,%d,%d,%.4f\n", hour.Month, hour.Day, hour.Hour, sim.Hourly[i])
+	}
+	fmt.Fprintf(os.Stderr, "%s: %.1f kwh a year\n", weather.Station.Name, sim.Annual)
+	return 0
+}
+
+//Prints where the sun is at a time and its rise, noon and set that day.
+func SunCommand(args []string) int {
+	flags := flag.NewFlagSet("sun", flag.ContinueOnError)
+	form := url.Values{}
+	for _, name := range []string{"location", "latitude", "longitude", "time", "tz", "elevation", "pressure", "temperature", "delta_t"} {
+		name := name
+		flags.Func(name, "same as the "+name+" value of /api/v1/sun", func(value string) error {
+			form.Set(name, value)
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	t, obs, errs := ParseSunForm(form)
+	if len(errs) > 0 {
+		for _, fieldErr := range errs {
+			fmt.Fprintf(os.Stderr, "Error: -%s: %s\n", fieldErr.Field, fieldErr.Message)
+		}
+		return 2
+	}
+	out, _ := json.MarshalIndent(ComputeSun(t, obs), "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
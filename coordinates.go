@@ -0,0 +1,161 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file reads coordinates typed by the user. Everything in the
+program uses signed WGS84 degrees: latitude is positive north of the equator
+and longitude is positive east of Greenwich, so the U.S. has negative
+longitudes. The user can type signed decimal degrees, degrees with a
+hemisphere letter (37.77N 122.42W) or degrees, minutes and seconds
+(37°46'12"N 122°25'12"W).*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//Letters marking each hemisphere for a latitude and a longitude.
+var (
+	LatitudeLetters  = [2]rune{'N', 'S'}
+	LongitudeLetters = [2]rune{'E', 'W'}
+)
+
+//Parses one angle in decimal degrees or degrees, minutes and seconds, with
+//an optional hemisphere letter before or after it. letters holds the letter
+//for the positive and the negative hemisphere.
+func ParseAngle(text string, letters [2]rune) (float64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, errors.New("no value given")
+	}
+	//a hemisphere is one letter at either end, so check there is a number first
+	alpha := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			alpha++
+		}
+	}
+	if alpha > 2 || !strings.ContainsAny(text, "0123456789") {
+		return 0, fmt.Errorf("%q is not a number of degrees", text)
+	}
+	text = strings.ToUpper(text)
+	sign := 1.0
+	hemisphere := false
+	runes := []rune(text)
+	for _, idx := range []int{len(runes) - 1, 0} {
+		if !hemisphere && len(runes) > 0 && unicode.IsLetter(runes[idx]) {
+			switch runes[idx] {
+			case letters[0]:
+			case letters[1]:
+				sign = -1
+			default:
+				return 0, fmt.Errorf("%q is not a hemisphere, use %c or %c", string(runes[idx]), letters[0], letters[1])
+			}
+			hemisphere = true
+			runes = append(runes[:idx:idx], runes[idx+1:]...)
+		}
+	}
+	text = strings.TrimSpace(string(runes))
+	if strings.HasPrefix(text, "-") || strings.HasPrefix(text, "+") {
+		if hemisphere {
+			return 0, errors.New("use either a sign or a hemisphere letter, not both")
+		}
+		if text[0] == '-' {
+			sign = -1
+		}
+		text = strings.TrimSpace(text[1:])
+	}
+
+	//degrees, minutes and seconds can be split by symbols or spaces
+	parts := strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("°º'\"′″:", r)
+	})
+	if len(parts) == 0 || len(parts) > 3 {
+		return 0, fmt.Errorf("%q is not an angle", text)
+	}
+	var value float64
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil || number < 0 || number != number {
+			return 0, fmt.Errorf("%q is not a number of degrees, minutes or seconds", part)
+		}
+		if i > 0 && number >= 60 {
+			return 0, fmt.Errorf("%v is too many minutes or seconds", number)
+		}
+		if i < len(parts)-1 && number != float64(int(number)) {
+			return 0, errors.New("only the last of degrees, minutes and seconds can have decimals")
+		}
+		value += number / []float64{1, 60, 3600}[i]
+	}
+	return sign * value, nil
+}
+
+//Parses a latitude such as 37.77, 37.77N or 37°46'12"N.
+func ParseLatitude(text string) (float64, error) {
+	lat, err := ParseAngle(text, LatitudeLetters)
+	if err == nil && (lat < MinLatitude || lat > MaxLatitude) {
+		err = fmt.Errorf("latitude %v is not between %v and %v", lat, MinLatitude, MaxLatitude)
+	}
+	return lat, err
+}
+
+//Parses a longitude such as -122.42, 122.42W or 122°25'12"W.
+func ParseLongitude(text string) (float64, error) {
+	lon, err := ParseAngle(text, LongitudeLetters)
+	if err == nil && (lon < MinLongitude || lon > MaxLongitude) {
+		err = fmt.Errorf("longitude %v is not between %v and %v", lon, MinLongitude, MaxLongitude)
+	}
+	return lon, err
+}
+
+//Parses a latitude and longitude typed together, such as "37.77N 122.42W",
+//"37.77, -122.42" or "37 46 12 N 122 25 12 W". If both have hemisphere
+//letters they can be in either order.
+func ParseLocation(text string) (float64, float64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, 0, errors.New("no location given")
+	}
+	var splits [][2]string
+	if idx := strings.IndexAny(text, ",;"); idx >= 0 {
+		splits = append(splits, [2]string{text[:idx], text[idx+1:]})
+	} else {
+		words := strings.Fields(text)
+		for i := 1; i < len(words); i++ {
+			splits = append(splits, [2]string{strings.Join(words[:i], " "), strings.Join(words[i:], " ")})
+		}
+	}
+	for _, split := range splits {
+		lat, errLat := ParseLatitude(split[0])
+		lon, errLon := ParseLongitude(split[1])
+		if errLat == nil && errLon == nil {
+			return lat, lon, nil
+		}
+		//longitude first, only when the hemisphere letters say so
+		lon, errLon = ParseLongitude(split[0])
+		lat, errLat = ParseLatitude(split[1])
+		if errLat == nil && errLon == nil && HasLetter(split[0]) && HasLetter(split[1]) {
+			return lat, lon, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("%q is not a latitude and longitude, try 37.77, -122.42 or 37.77N 122.42W", text)
+}
+
+//Tells whether the text has a letter in it.
+func HasLetter(text string) bool {
+	return strings.IndexFunc(text, unicode.IsLetter) >= 0
+}
+
+//Formats signed coordinates with hemisphere letters, such as 37.77°N 122.42°W.
+func FormatLocation(lat, lon float64) string {
+	latLetter, lonLetter := LatitudeLetters[0], LongitudeLetters[0]
+	if lat < 0 {
+		latLetter, lat = LatitudeLetters[1], -lat
+	}
+	if lon < 0 {
+		lonLetter, lon = LongitudeLetters[1], -lon
+	}
+	return fmt.Sprintf("%.4f°%c %.4f°%c", lat, latLetter, lon, lonLetter)
+}
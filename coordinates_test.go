@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseAngle(t *testing.T) {
+	accepted := []struct {
+		text string
+		want float64
+	}{
+		{"37.77", 37.77},
+		{"-122.42", -122.42},
+		{"+45", 45},
+		{"37.77N", 37.77},
+		{"37.77 s", -37.77},
+		{"S37.77", -37.77},
+		{"37°46'12\"N", 37.77},
+		{"37 46 12", 37.77},
+		{"37:46.2", 37.77},
+		{"122°25′12″W", -122.42},
+	}
+	for _, c := range accepted {
+		letters := LatitudeLetters
+		if strings.ContainsAny(c.text, "EWew") {
+			letters = LongitudeLetters
+		}
+		got, err := ParseAngle(c.text, letters)
+		if err != nil {
+			t.Errorf("%q: %v", c.text, err)
+		} else if math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%q = %v, want %v", c.text, got, c.want)
+		}
+	}
+
+	rejected := []struct {
+		text, reason string
+	}{
+		{"", "no value"},
+		{"abc", "not a number"},
+		{"north", "not a number"},
+		{"NaN", "not a number"},
+		{"37.77X", "not a hemisphere"},
+		{"-37.77S", "not both"},
+		{"37 61", "too many minutes"},
+		{"37.5 30", "only the last"},
+		{"37 46 12 5", "not an angle"},
+		{"37..77", "not a number of degrees, minutes or seconds"},
+	}
+	for _, c := range rejected {
+		_, err := ParseAngle(c.text, LatitudeLetters)
+		if err == nil {
+			t.Errorf("%q was accepted", c.text)
+		} else if !strings.Contains(err.Error(), c.reason) {
+			t.Errorf("%q: %v, want it to say %q", c.text, err, c.reason)
+		}
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	accepted := []struct {
+		text     string
+		lat, lon float64
+	}{
+		{"37.77, -122.42", 37.77, -122.42},
+		{"37.77;-122.42", 37.77, -122.42},
+		{"37.77 -122.42", 37.77, -122.42},
+		{"37.77N 122.42W", 37.77, -122.42},
+		{"122.42W 37.77N", 37.77, -122.42},
+		{"37°46'12\"N 122°25'12\"W", 37.77, -122.42},
+		{"33 51 S, 151 12 E", -33.85, 151.2},
+	}
+	for _, c := range accepted {
+		lat, lon, err := ParseLocation(c.text)
+		if err != nil {
+			t.Errorf("%q: %v", c.text, err)
+		} else if math.Abs(lat-c.lat) > 1e-9 || math.Abs(lon-c.lon) > 1e-9 {
+			t.Errorf("%q = %v, %v, want %v, %v", c.text, lat, lon, c.lat, c.lon)
+		}
+	}
+
+	for _, text := range []string{"", "37.77", "abc, def", "95, -122.42", "37.77, 190", "-122.42, 37.77", "37.77N 40.1S"} {
+		if lat, lon, err := ParseLocation(text); err == nil {
+			t.Errorf("%q was accepted as %v, %v", text, lat, lon)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file loads energy.csv, solar.csv and the other data files
+once and shares them with every request. The files are loaded again on SIGHUP or when they change,
+and a bad file never replaces the data that is already being served.*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/*This is a dataset struct which holds one loaded copy of the city and panel
+data. It is never changed after it is loaded, so every request can read it
+at the same time without locking.*/
+type Dataset struct {
+	Cities     map[string]City         //City data by city name
+	Panels     map[string]Panel        //Solar panel data by brand name
+	Inverters  map[string]Inverter     //Inverter data by model name
+	CityNames  []string                //City names in file order (alphabetical), used for the map colors
+	PanelNames []string                //Names of the panels with a price in alphabetical order, the panels offered
+	Index      *CityIndex              //Spatial index of the cities for nearest-city lookups
+	Weather    *WeatherIndex           //Weather files for the hourly simulation
+	Tariffs    map[string]Tariff       //Utility rates by key
+	Export     map[string]ExportPolicy //Export policies by key
+	Incentives []Incentive             //Incentives in the order they are applied
+	LoadedAt   time.Time               //When the files were read
+}
+
+/*This is the set of data files a dataset is loaded from. Monthly, Weather
+(a directory of weather files), Tariffs (a directory of utility rates),
+Export (the export policies) and Incentives are optional, a dataset with no
+Inverters file has no inverters to recommend, and only the panels in the
+Prices sheet are offered.*/
+type DataFiles struct {
+	Cities    string `json:"city_file"`
+	Panels    string `json:"panel_file"`
+	Prices    string `json:"price_file"`
+	Inverters string `json:"inverter_file,omitempty"`
+	Monthly   string `json:"monthly_file,omitempty"`
+	Weather   string `json:"weather_dir,omitempty"`
+	Tariffs   string `json:"tariff_dir,omitempty"`
+	Export    string `json:"export_file,omitempty"`
+	Incentive string `json:"incentive_file,omitempty"`
+}
+
+//The data files the server loads.
+var DefaultDataFiles = DataFiles{Cities: "energy.csv", Panels: "solar.csv", Prices: "prices.csv", Inverters: "inverters.csv", Monthly: "monthly.csv", Weather: "weather", Tariffs: "tariffs", Export: "export.csv", Incentive: "incentives.csv"}
+
+//Gives the names of all the files that are set.
+func (files DataFiles) Names() []string {
+	names := make([]string, 0, 9)
+	for _, name := range []string{files.Cities, files.Panels, files.Prices, files.Inverters, files.Monthly, files.Weather, files.Tariffs, files.Export, files.Incentive} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+/*This is the store holding the dataset currently being served. Get() is safe
+to call from any goroutine, and Reload() swaps in a new dataset all at once.*/
+type DataStore struct {
+	files       DataFiles
+	current     atomic.Value //*Dataset
+	reloadMu    sync.Mutex   //only one reload at a time
+	statusMu    sync.Mutex   //guards the fields below
+	modTimes    map[string]time.Time
+	lastError   error
+	lastTry     time.Time
+	diagnostics Diagnostics
+}
+
+/*This is the reload status reported over the API.*/
+type DataStatus struct {
+	DataFiles
+	Cities      int         `json:"cities"`
+	Panels      int         `json:"panels"`
+	Inverters   int         `json:"inverters"`
+	Weather     int         `json:"weather_files"`
+	Tariffs     int         `json:"tariffs"`
+	Export      int         `json:"export_policies"`
+	Incentives  int         `json:"incentives"`
+	LoadedAt    time.Time   `json:"loaded_at"`
+	LastAttempt time.Time   `json:"last_attempt"`
+	LastError   string      `json:"last_error,omitempty"`
+	Diagnostics Diagnostics `json:"diagnostics,omitempty"` //Problems found by the last reload
+}
+
+//The store used by the http handlers. It is set up in main().
+var Data *DataStore
+
+//Makes a store and loads the files. The first load has to work, since there
+//is no older data to fall back to.
+func NewDataStore(files DataFiles) (*DataStore, error) {
+	store := &DataStore{files: files}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+//Reads the files into a new dataset. Any problem in any file is returned in
+//the diagnostics, and then the dataset is not made at all.
+func LoadDataset(files DataFiles) (*Dataset, Diagnostics, error) {
+	cityRecords, diagnostics, err := ReadTable(files.Cities, CitySchema)
+	if err != nil {
+		return nil, nil, err
+	}
+	panelRecords, panelDiagnostics, err := ReadTable(files.Panels, PanelSchema)
+	if err != nil {
+		return nil, diagnostics, err
+	}
+	diagnostics = append(diagnostics, panelDiagnostics...)
+	CheckPanels(panelRecords, files.Panels, &diagnostics)
+	var inverterRecords []Record
+	if files.Inverters != "" {
+		var inverterDiagnostics Diagnostics
+		inverterRecords, inverterDiagnostics, err = ReadTable(files.Inverters, InverterSchema)
+		if err != nil {
+			return nil, diagnostics, err
+		}
+		diagnostics = append(diagnostics, inverterDiagnostics...)
+		CheckInverters(inverterRecords, files.Inverters, &diagnostics)
+	}
+	monthlyRecords, monthlyDiagnostics, err := ReadMonthly(files.Monthly)
+	if err != nil {
+		return nil, diagnostics, err
+	}
+	diagnostics = append(diagnostics, monthlyDiagnostics...)
+	priceRecords, priceDiagnostics, err := ReadPrices(files.Prices)
+	if err != nil {
+		return nil, diagnostics, err
+	}
+	diagnostics = append(diagnostics, priceDiagnostics...)
+	exportRecords, exportDiagnostics, err := ReadExportPolicies(files.Export)
+	if err != nil {
+		return nil, diagnostics, err
+	}
+	diagnostics = append(diagnostics, exportDiagnostics...)
+	policies := MakeExportPolicies(exportRecords, files.Export, &diagnostics)
+	CheckCityPolicies(cityRecords, policies, files.Cities, &diagnostics)
+	CheckCityStates(cityRecords, files.Cities, &diagnostics)
+	incentiveRecords, incentiveDiagnostics, err := ReadIncentives(files.Incentive)
+	if err != nil {
+		return nil, diagnostics, err
+	}
+	diagnostics = append(diagnostics, incentiveDiagnostics...)
+	incentives := MakeIncentives(incentiveRecords, files.Incentive, &diagnostics)
+	cityData := MakeCityMap(cityRecords)
+	solarPanels := MakeSolarMap(panelRecords)
+	ApplyMonthly(cityData, monthlyRecords, files.Monthly, &diagnostics)
+	ApplyPrices(solarPanels, priceRecords, files.Prices, &diagnostics)
+	weather := LoadWeatherIndex(files.Weather, &diagnostics)
+	tariffs := LoadTariffs(files.Tariffs, &diagnostics)
+	if len(diagnostics) > 0 {
+		return nil, diagnostics, fmt.Errorf("%d problems found in the data files, first: %v", len(diagnostics), diagnostics[0])
+	}
+	if len(cityRecords) == 0 {
+		return nil, nil, errors.New(files.Cities + " has no cities")
+	}
+	if len(panelRecords) == 0 {
+		return nil, nil, errors.New(files.Panels + " has no panels")
+	}
+	if len(MakePanelArray(solarPanels)) == 0 {
+		return nil, nil, errors.New("no panel in " + files.Panels + " has a price in " + files.Prices)
+	}
+	dataset := &Dataset{
+		Cities:     cityData,
+		Panels:     solarPanels,
+		Inverters:  MakeInverterMap(inverterRecords),
+		CityNames:  MakeCityArray(cityRecords),
+		PanelNames: MakePanelArray(solarPanels),
+		Index:      NewCityIndex(cityData),
+		Weather:    weather,
+		Tariffs:    tariffs,
+		Export:     policies,
+		Incentives: incentives,
+		LoadedAt:   time.Now(),
+	}
+	return dataset, nil, nil
+}
+
+//Gives the dataset currently being served.
+func (s *DataStore) Get() *Dataset {
+	return s.current.Load().(*Dataset)
+}
+
+//Loads the files again. If they can't be read the error is logged and
+//returned, and the last good dataset stays in use. The files' times are only
+//kept after a good load, so a failed one is tried again at the next check.
+func (s *DataStore) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	modTimes := s.fileModTimes()
+	dataset, diagnostics, err := LoadDataset(s.files)
+
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.lastTry = time.Now()
+	s.lastError = err
+	s.diagnostics = diagnostics
+	if err != nil {
+		for _, diagnostic := range diagnostics {
+			log.Print(diagnostic)
+		}
+		log.Print("dataset reload failed, keeping last good data: ", err)
+		return err
+	}
+	s.current.Store(dataset)
+	s.modTimes = modTimes
+	log.Printf("dataset loaded: %d cities, %d panels, %d inverters", len(dataset.Cities), len(dataset.Panels), len(dataset.Inverters))
+	return nil
+}
+
+//Gives the reload status of the store.
+func (s *DataStore) Status() DataStatus {
+	dataset := s.Get()
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	status := DataStatus{
+		DataFiles:   s.files,
+		Cities:      len(dataset.Cities),
+		Panels:      len(dataset.Panels),
+		Inverters:   len(dataset.Inverters),
+		Weather:     len(dataset.Weather.Stations),
+		Tariffs:     len(dataset.Tariffs),
+		Export:      len(dataset.Export),
+		Incentives:  len(dataset.Incentives),
+		LoadedAt:    dataset.LoadedAt,
+		LastAttempt: s.lastTry,
+		Diagnostics: s.diagnostics,
+	}
+	if s.lastError != nil {
+		status.LastError = s.lastError.Error()
+	}
+	return status
+}
+
+//Reloads the files every time the process gets a SIGHUP.
+func (s *DataStore) ReloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Print("SIGHUP received, reloading dataset")
+			s.Reload()
+		}
+	}()
+}
+
+//Checks the files every interval and reloads them when any of them changes.
+func (s *DataStore) ReloadOnChange(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if s.filesChanged() {
+				s.Reload()
+			}
+		}
+	}()
+}
+
+//Gets the modification time of each file, and of every file in the
+//directories (weather and tariffs), since changing a file in a directory
+//doesn't change the directory's own time. Files that can't be read are left
+//out.
+func (s *DataStore) fileModTimes() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, filename := range s.files.Names() {
+		filepath.Walk(filename, func(path string, info os.FileInfo, err error) error {
+			if err == nil {
+				modTimes[path] = info.ModTime()
+			}
+			return nil
+		})
+	}
+	return modTimes
+}
+
+//Tells whether any file has changed, appeared or gone away since it was last loaded.
+func (s *DataStore) filesChanged() bool {
+	modTimes := s.fileModTimes()
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if len(modTimes) != len(s.modTimes) {
+		return true
+	}
+	for filename, modTime := range modTimes {
+		if !modTime.Equal(s.modTimes[filename]) {
+			return true
+		}
+	}
+	return false
+}
+
+/*This function gets how often to check the data files for changes, from the
+RELOAD_INTERVAL environment variable (for example "30s"). It is 10 seconds
+if not set, and 0 turns the checks off.*/
+func getReloadInterval() time.Duration {
+	p := os.Getenv("RELOAD_INTERVAL")
+	if p == "" {
+		return 10 * time.Second
+	}
+	interval, err := time.ParseDuration(p)
+	if err != nil {
+		log.Print("invalid RELOAD_INTERVAL, using 10s: ", err)
+		return 10 * time.Second
+	}
+	return interval
+}
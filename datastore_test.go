@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilesChangedInDirectory(t *testing.T) {
+	dir := t.TempDir()
+	tariff := filepath.Join(dir, "rate.json")
+	if err := os.WriteFile(tariff, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &DataStore{files: DataFiles{Tariffs: dir}}
+	s.modTimes = s.fileModTimes()
+	if s.filesChanged() {
+		t.Fatal("changed before anything was touched")
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(tariff, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !s.filesChanged() {
+		t.Error("a file changed in the directory wasn't noticed")
+	}
+
+	s.modTimes = s.fileModTimes()
+	if err := os.WriteFile(filepath.Join(dir, "new.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !s.filesChanged() {
+		t.Error("a file added to the directory wasn't noticed")
+	}
+}
+
+//A reload that fails keeps the last good data, and the broken file still
+//counts as changed until a reload of it works.
+func TestFailedReloadIsRetried(t *testing.T) {
+	good, err := os.ReadFile(DefaultDataFiles.Cities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := DefaultDataFiles
+	files.Cities = filepath.Join(t.TempDir(), "energy.csv")
+	if err := os.WriteFile(files.Cities, good, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewDataStore(files)
+	if err != nil {
+		t.Fatalf("loading the data files: %v", err)
+	}
+	loaded := s.Get()
+
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(files.Cities, []byte("city,lat\r\nNowhere,north\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(files.Cities, later, later)
+	if err := s.Reload(); err == nil {
+		t.Fatal("reloading a broken city file worked")
+	}
+	if s.Get() != loaded {
+		t.Error("the last good data was replaced")
+	}
+	if !s.filesChanged() {
+		t.Error("the broken file isn't retried after the failed reload")
+	}
+
+	if err := os.WriteFile(files.Cities, good, 0644); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Hour)
+	os.Chtimes(files.Cities, later, later)
+	if err := s.Reload(); err != nil {
+		t.Fatalf("reloading the fixed file: %v", err)
+	}
+	if s.filesChanged() {
+		t.Error("the files still count as changed after a good reload")
+	}
+}
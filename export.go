@@ -0,0 +1,256 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file decides what solar sent to the grid is worth. The
+export policies are read from export.csv and each city in energy.csv names
+the one its utilities usually use. Under net metering what is sent to the
+grid is credited at the retail rate, under net billing at the utility's
+avoided cost, under an hourly policy (like California's NEM 3.0) at a value
+for each hour of each month, and some policies credit nothing. Credits pay
+the month's energy and demand charges and what is left carries over to the
+next month until the yearly true-up.*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//Kinds of export policy.
+const (
+	ExportNetMetering = "net_metering" //credited at the retail rate of the period it is sent in
+	ExportNetBilling  = "net_billing"  //credited at export_rate
+	ExportHourly      = "hourly"       //credited at hourly_rates, a value for each hour
+	ExportNone        = "none"         //not credited
+)
+
+//The policy of cities that don't name one.
+const DefaultExportPolicy = "net-metering"
+
+//The columns of export.csv.
+var ExportSchema = Schema{
+	Name: "export policies",
+	Key:  "policy",
+	Columns: []Column{
+		{Name: "policy", Unit: "key cities and requests choose the policy by", Kind: KindText, Required: true},
+		{Name: "name", Kind: KindText, Required: true},
+		{Name: "kind", Unit: "net_metering, net_billing, hourly or none", Kind: KindText, Required: true},
+		{Name: "export_rate", Unit: "$/kwh sent to the grid, for net_billing", Kind: KindNumber, Min: 0, Max: 5},
+		{Name: "surplus_rate", Unit: "$/kwh the year's net surplus is paid at the true-up", Kind: KindNumber, Min: 0, Max: 5},
+		{Name: "hourly_rates", Unit: "$/kwh for each hour from midnight, 24 values or 24 for each month, for hourly", Kind: KindList},
+		{Name: "source", Kind: KindText},
+	},
+}
+
+/*This is the struct storing an export policy: how solar sent to the grid is
+credited, and what the year's net surplus is paid at the true-up.*/
+type ExportPolicy struct {
+	Key         string           `json:"policy"`
+	Name        string           `json:"name"`
+	Kind        string           `json:"kind"`
+	ExportRate  float64          `json:"export_rate,omitempty"`  //$ per kwh sent to the grid, for net billing
+	SurplusRate float64          `json:"surplus_rate,omitempty"` //$ per kwh of the year's net surplus
+	Hourly      *[12][24]float64 `json:"hourly_rates,omitempty"` //$ per kwh sent in each hour of each month, for hourly policies
+	Source      string           `json:"source,omitempty"`
+}
+
+//The policy used when there is no export.csv: net metering without anything
+//paid for the surplus.
+var FallbackExportPolicy = ExportPolicy{Key: DefaultExportPolicy, Name: "Net metering at the retail rate", Kind: ExportNetMetering}
+
+//Reads export.csv. The file is optional, so a file that doesn't exist gives
+//no records and no error.
+func ReadExportPolicies(filename string) ([]Record, Diagnostics, error) {
+	if filename == "" {
+		return nil, nil, nil
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	return ReadTable(filename, ExportSchema)
+}
+
+//Makes the export policies by key. Unknown kinds, net billing without an
+//export rate and hourly rates that aren't 24 or 288 numbers are reported in
+//the diagnostics.
+func MakeExportPolicies(records []Record, filename string, diagnostics *Diagnostics) map[string]ExportPolicy {
+	policies := make(map[string]ExportPolicy)
+	for _, record := range records {
+		policy := ExportPolicy{
+			Key:         record.Key(ExportSchema),
+			Name:        record.Text["name"],
+			Kind:        record.Text["kind"],
+			ExportRate:  record.Numbers["export_rate"],
+			SurplusRate: record.Numbers["surplus_rate"],
+			Source:      record.Text["source"],
+		}
+		switch policy.Kind {
+		case ExportNetMetering, ExportNone:
+		case ExportNetBilling:
+			if _, ok := record.Numbers["export_rate"]; !ok {
+				diagnostics.Add(filename, record.Line, "export_rate", "net_billing needs an export rate")
+				continue
+			}
+		case ExportHourly:
+			rates, err := HourlyRates(record.Lists["hourly_rates"])
+			if err != nil {
+				diagnostics.Add(filename, record.Line, "hourly_rates", err.Error())
+				continue
+			}
+			policy.Hourly = &rates
+		default:
+			diagnostics.Add(filename, record.Line, "kind", fmt.Sprintf("%q is not %s, %s, %s or %s", policy.Kind, ExportNetMetering, ExportNetBilling, ExportHourly, ExportNone))
+			continue
+		}
+		policies[policy.Key] = policy
+	}
+	return policies
+}
+
+//Reads an hourly policy's rates: 24 values used in every month, or 24 for
+//each month from January.
+func HourlyRates(values []string) ([12][24]float64, error) {
+	var rates [12][24]float64
+	if len(values) != 24 && len(values) != 12*24 {
+		return rates, fmt.Errorf("has %d values, expected 24 or %d", len(values), 12*24)
+	}
+	for i, value := range values {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 5 {
+			return rates, fmt.Errorf("value %d, %q, is not a rate between 0 and 5", i+1, value)
+		}
+		if len(values) == 24 {
+			for m := range rates {
+				rates[m][i] = rate
+			}
+		} else {
+			rates[i/24][i%24] = rate
+		}
+	}
+	return rates, nil
+}
+
+//Checks that every city's export policy is in the export policies. Cities
+//without one use DefaultExportPolicy.
+func CheckCityPolicies(records []Record, policies map[string]ExportPolicy, filename string, diagnostics *Diagnostics) {
+	for _, record := range records {
+		if key := record.Text["export_policy"]; key != "" {
+			if _, ok := policies[key]; !ok {
+				diagnostics.Add(filename, record.Line, "export_policy", fmt.Sprintf("%q is not in the export policies", key))
+			}
+		}
+	}
+}
+
+//Gives the export policy with a key, and FallbackExportPolicy for the
+//default policy if export.csv doesn't have it.
+func (dataset *Dataset) ExportPolicy(key string) ExportPolicy {
+	if key == "" {
+		key = DefaultExportPolicy
+	}
+	if policy, ok := dataset.Export[key]; ok {
+		return policy
+	}
+	return FallbackExportPolicy
+}
+
+//Gives the keys of the export policies in alphabetical order.
+func ExportPolicyKeys(policies map[string]ExportPolicy) []string {
+	keys := make([]string, 0, len(policies))
+	for key := range policies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Gives the month (0 for January) and hour of an hour of the year, counted
+//from midnight on January 1st of SimYear, and whether it is on a weekend.
+func HourOfYear(i int) (int, int, bool) {
+	t := time.Date(SimYear, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Hour)
+	return int(t.Month()) - 1, t.Hour(), t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+//Bills a year of a house's hourly usage with its hourly solar output on a
+//tariff under the policy. Net metering nets what is sent to the grid against
+//what is bought in the same period of the same month and credits what is
+//left at the period's first tier; the other policies bill everything bought
+//and credit what is sent hour by hour. It also gives what the true-up pays
+//for the year's net surplus and the credit it leaves unused.
+func (policy ExportPolicy) Bill(tariff Tariff, usage, solar []float64) ([12]MonthBill, float64, float64) {
+	bought := make([]float64, len(usage))
+	net := make([]float64, len(usage))
+	var credits, exported [12]float64
+	var totalBought, totalSent float64
+	for i := range usage {
+		net[i] = usage[i]
+		if i < len(solar) {
+			net[i] -= solar[i]
+		}
+		bought[i] = math.Max(0, net[i])
+		sent := math.Max(0, -net[i])
+		m, h, _ := HourOfYear(i)
+		exported[m] += sent
+		totalBought += bought[i]
+		totalSent += sent
+		switch policy.Kind {
+		case ExportNetBilling:
+			credits[m] += sent * policy.ExportRate
+		case ExportHourly:
+			credits[m] += sent * policy.Hourly[m][h]
+		}
+	}
+	var bills [12]MonthBill
+	if policy.Kind == ExportNetMetering {
+		bills = tariff.Bill(net)
+		credits = tariff.NetCredits(net)
+	} else {
+		bills = tariff.Bill(bought)
+	}
+	var carried float64
+	for m := range bills {
+		bill := &bills[m]
+		bill.Exported = math.Round(exported[m]*10) / 10
+		bill.Credit = math.Round(credits[m]*100) / 100
+		carried += credits[m]
+		bill.CreditUsed = math.Min(carried, bill.EnergyCharge+bill.DemandCharge)
+		carried -= bill.CreditUsed
+		bill.Total = math.Max(bill.EnergyCharge+bill.DemandCharge-bill.CreditUsed+bill.FixedCharge, PerMonth(tariff.MinCharge, tariff.MinChargeUnits, MonthDays[m]))
+		bill.CreditUsed = math.Round(bill.CreditUsed*100) / 100
+		bill.Total = math.Round(bill.Total*100) / 100
+	}
+	trueUp := math.Max(0, totalSent-totalBought) * policy.SurplusRate
+	return bills, math.Round(trueUp*100) / 100, math.Round(carried*100) / 100
+}
+
+//Gives the retail credit of each month under net metering: for each period
+//where more was sent to the grid than bought, the difference at the period's
+//first tier. On a tiered rate this is less than crediting at the tier the
+//imports reached.
+func (tariff Tariff) NetCredits(load []float64) [12]float64 {
+	var credits [12]float64
+	energy := make([][]float64, 12)
+	for m := range energy {
+		energy[m] = make([]float64, len(tariff.EnergyRateStructure))
+	}
+	for i, kwh := range load {
+		m, h, weekend := HourOfYear(i)
+		schedule := tariff.EnergyWeekdaySchedule
+		if weekend {
+			schedule = tariff.EnergyWeekendSchedule
+		}
+		energy[m][schedule[m][h]] += kwh
+	}
+	for m := range energy {
+		for p, kwh := range energy[m] {
+			if kwh < 0 {
+				tier := tariff.EnergyRateStructure[p][0]
+				credits[m] += -kwh * (tier.Rate + tier.Adj)
+			}
+		}
+	}
+	return credits
+}
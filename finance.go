@@ -0,0 +1,236 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file works out whether a solar system pays for itself. It
+projects the system's cash flows year by year: the electricity it makes,
+which falls as the panels degrade, times the electricity price, which rises
+each year, plus the yearly incentives, less the cost of operating and
+maintaining it. From them it gives
+the simple and discounted payback, the net present value, the internal rate
+of return and the levelized cost of energy of every panel brand offered.*/
+
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+/*This is one setting of the financial analysis: its key in requests, its
+label, its unit, and its default and the range it can be set to.*/
+type FinanceSetting struct {
+	Key     string
+	Label   string
+	Unit    string
+	Default float64
+	Min     float64
+	Max     float64
+}
+
+//The settings of the financial analysis. The electricity price is about the
+//US average for homes, and the degradation and operating cost are NREL's
+//typical values for home systems.
+var FinanceSettings = []FinanceSetting{
+	{"electricity_price", "Electricity price", "$ per kwh", 0.16, 0.01, 2},
+	{"escalation", "Electricity price increase", "% a year", 2.5, -5, 15},
+	{"degradation", "Panel degradation", "% a year", 0.5, 0, 5},
+	{"om_cost", "Operation and maintenance", "$ per kW a year", 20, 0, 200},
+	{"discount_rate", "Discount rate", "%", 5, 0, 20},
+	{"years", "Years analyzed", "years", 25, 1, 40},
+}
+
+/*This is the value set for each finance setting in a request, by key.
+Settings that aren't set use their default.*/
+type FinanceInputs map[string]float64
+
+//Gives the value of a finance setting.
+func (inputs FinanceInputs) Value(key string) float64 {
+	if value, ok := inputs[key]; ok {
+		return value
+	}
+	setting, _ := FindFinanceSetting(key)
+	return setting.Default
+}
+
+//Gives the finance settings and then the financing settings, every setting a
+//request's finance can have.
+func AllFinanceSettings() []FinanceSetting {
+	return append(append([]FinanceSetting{}, FinanceSettings...), FinancingSettings...)
+}
+
+//Finds a finance or financing setting by its key.
+func FindFinanceSetting(key string) (FinanceSetting, bool) {
+	for _, setting := range AllFinanceSettings() {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+	return FinanceSetting{}, false
+}
+
+//Gives the name of a finance setting for messages, such as "electricity price".
+func (setting FinanceSetting) Name() string {
+	return strings.ToLower(setting.Label)
+}
+
+/*This is one year of a system's cash flows. Year 0 is the day it is bought,
+when the cost is paid.*/
+type CashFlow struct {
+	Year       int     `json:"year"`
+	Production float64 `json:"production_kwh"`   //electricity made in the year
+	Price      float64 `json:"price_per_kwh"`    //what each kwh made saves on the bills in the year
+	Savings    float64 `json:"savings"`          //electricity bought from the utility that the system saves
+	Incentives float64 `json:"incentives"`       //property tax exemptions and performance payments
+	OMCost     float64 `json:"om_cost"`          //operation and maintenance
+	Net        float64 `json:"net"`              //savings and incentives less operation and maintenance, and less the cost in year 0
+	Cumulative float64 `json:"cumulative"`       //net of this year and every year before it
+	Discounted float64 `json:"discounted_total"` //cumulative with each year's net discounted to year 0
+}
+
+/*This is the struct storing the financial analysis of a system: what it
+costs, what it makes and saves over the years, and the measures of whether
+it pays for itself. The paybacks and internal rate of return are nil if it
+never does, and the levelized cost of energy is nil if it makes nothing.*/
+type Finance struct {
+	Cost              float64    `json:"cost"`                     //net cost, after the rebates and tax credits
+	SystemKW          float64    `json:"system_kw"`                //rated watts of the panels, in kW
+	Production        float64    `json:"production_kwh_year"`      //electricity made in the first year
+	Years             int        `json:"years"`                    //years the cash flows are projected
+	Payback           *float64   `json:"payback_years"`            //years until the savings add up to the cost
+	DiscountedPayback *float64   `json:"discounted_payback_years"` //the same with the savings discounted
+	NPV               float64    `json:"npv"`                      //net present value: the discounted savings less the cost
+	IRR               *float64   `json:"irr_percent"`              //internal rate of return: the discount rate with an NPV of 0
+	LCOE              *float64   `json:"lcoe_per_kwh"`             //levelized cost of energy: the discounted costs over the discounted electricity made
+	LifetimeSavings   float64    `json:"lifetime_savings"`         //savings less every cost, not discounted
+	CashFlows         []CashFlow `json:"cash_flows"`
+}
+
+//Projects the cash flows of a system that costs cost, with panels rated
+//systemKW, making production kwh in its first year that each save value on
+//the bills, and works out its payback, NPV, IRR and LCOE. The value rises
+//with the electricity price and operation and maintenance stays the same
+//each year. The yearly incentives are paid on top of the savings, and what
+//each pays over the years is added to its Total.
+func AnalyzeFinance(inputs FinanceInputs, cost, systemKW, production, value float64, incentives []AppliedIncentive) Finance {
+	years := int(math.Round(inputs.Value("years")))
+	rate := inputs.Value("discount_rate") / 100
+	finance := Finance{Cost: cost, SystemKW: round3(systemKW), Production: math.Round(production*10) / 10, Years: years}
+	finance.CashFlows = make([]CashFlow, years+1)
+	finance.CashFlows[0] = CashFlow{Net: -cost, Cumulative: -cost, Discounted: -cost}
+	flows := make([]float64, years+1)
+	flows[0] = -cost
+	discountedCost, discountedEnergy := cost, 0.0
+	for year := 1; year <= years; year++ {
+		previous := finance.CashFlows[year-1]
+		flow := CashFlow{Year: year}
+		flow.Production = production * math.Pow(1-inputs.Value("degradation")/100, float64(year-1))
+		flow.Price = value * math.Pow(1+inputs.Value("escalation")/100, float64(year-1))
+		flow.Savings = flow.Production * flow.Price
+		flow.OMCost = inputs.Value("om_cost") * systemKW
+		for i := range incentives {
+			payment := incentives[i].Payment(year, flow.Production)
+			flow.Incentives += payment
+			incentives[i].Total += payment
+		}
+		flow.Net = flow.Savings + flow.Incentives - flow.OMCost
+		discount := math.Pow(1+rate, float64(year))
+		flow.Cumulative = previous.Cumulative + flow.Net
+		flow.Discounted = previous.Discounted + flow.Net/discount
+		discountedCost += (flow.OMCost - flow.Incentives) / discount
+		discountedEnergy += flow.Production / discount
+		flows[year] = flow.Net
+		finance.CashFlows[year] = flow
+	}
+	last := finance.CashFlows[years]
+	finance.NPV = math.Round(last.Discounted*100) / 100
+	finance.LifetimeSavings = math.Round(last.Cumulative*100) / 100
+	if discountedEnergy > 0 {
+		lcoe := math.Round(discountedCost/discountedEnergy*1000) / 1000
+		finance.LCOE = &lcoe
+	}
+	finance.Payback = Payback(finance.CashFlows, false)
+	finance.DiscountedPayback = Payback(finance.CashFlows, true)
+	if irr, ok := IRR(flows); ok {
+		irr = math.Round(irr*10000) / 100
+		finance.IRR = &irr
+	}
+	for i := range finance.CashFlows {
+		finance.CashFlows[i].RoundCents()
+	}
+	for i := range incentives {
+		incentives[i].Total = math.Round(incentives[i].Total*100) / 100
+	}
+	return finance
+}
+
+//Rounds a year's cash flows to cents, its production to a tenth of a kwh
+//and its price to a tenth of a cent.
+func (flow *CashFlow) RoundCents() {
+	flow.Production = math.Round(flow.Production*10) / 10
+	flow.Price = math.Round(flow.Price*1000) / 1000
+	for _, value := range []*float64{&flow.Savings, &flow.Incentives, &flow.OMCost, &flow.Net, &flow.Cumulative, &flow.Discounted} {
+		*value = math.Round(*value*100) / 100
+	}
+}
+
+//Gives the years until the cumulative cash flow, or the discounted one,
+//turns positive, counting part of the year it turns in. It gives nil if it
+//never does.
+func Payback(flows []CashFlow, discounted bool) *float64 {
+	total := func(flow CashFlow) float64 {
+		if discounted {
+			return flow.Discounted
+		}
+		return flow.Cumulative
+	}
+	for year := 1; year < len(flows); year++ {
+		before, after := total(flows[year-1]), total(flows[year])
+		if before < 0 && after >= 0 {
+			years := float64(year-1) + -before/(after-before)
+			years = math.Round(years*10) / 10
+			return &years
+		}
+	}
+	return nil
+}
+
+//Gives the net present value of yearly cash flows, the first on day 0, at a
+//discount rate.
+func NPV(flows []float64, rate float64) float64 {
+	var npv float64
+	for year, flow := range flows {
+		npv += flow / math.Pow(1+rate, float64(year))
+	}
+	return npv
+}
+
+//Gives the internal rate of return of yearly cash flows, the discount rate
+//their net present value is 0 at, by bisection between -99% and 100%. It
+//returns false if there isn't one in that range.
+func IRR(flows []float64) (float64, bool) {
+	low, high := -0.99, 1.0
+	npvLow, npvHigh := NPV(flows, low), NPV(flows, high)
+	if npvLow*npvHigh > 0 {
+		return 0, false
+	}
+	for i := 0; i < 100 && high-low > 1e-9; i++ {
+		middle := (low + high) / 2
+		npv := NPV(flows, middle)
+		if (npv > 0) == (npvLow > 0) {
+			low, npvLow = middle, npv
+		} else {
+			high = middle
+		}
+	}
+	return (low + high) / 2, true
+}
+
+//Gives the electricity a brand's panels make in a year, from where they go on
+//each face of the roof and each face's output.
+func OptionProduction(faces []RoofFace, results []FaceResult, layouts []RoofLayout, panel Panel) float64 {
+	var production float64
+	for i, layout := range layouts {
+		if i < len(faces) {
+			production += float64(layout.Used) * PanelOutput(faces[i], results[i], panel)
+		}
+	}
+	return production
+}
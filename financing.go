@@ -0,0 +1,284 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file compares the ways of paying for a solar system. Next
+to paying cash it models a secured loan (such as a home equity loan) and an
+unsecured solar loan, each with its term, APR and the dealer fee added to
+what is borrowed, a lease with a monthly payment that rises each year, and a
+power purchase agreement (PPA) that sells the system's electricity at a
+price that rises each year. Each option's cash flows are worked out month by
+month, and the options are compared on what they save over the years
+analyzed.*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+//Ways of paying for a system.
+const (
+	FinancingCash      = "cash"
+	FinancingSecured   = "secured_loan"
+	FinancingUnsecured = "unsecured_loan"
+	FinancingLease     = "lease"
+	FinancingPPA       = "ppa"
+)
+
+//The settings of the financing options. They go in requests with the
+//finance settings. The loans are typical of home equity loans and of solar
+//loans sold by installers, whose low APR comes with a dealer fee.
+var FinancingSettings = []FinanceSetting{
+	{"secured_apr", "Secured loan interest rate", "% APR", 7.5, 0, 36},
+	{"secured_term", "Secured loan term", "years", 15, 1, 30},
+	{"secured_fee", "Secured loan dealer fee", "% of the loan", 0, 0, 50},
+	{"unsecured_apr", "Unsecured loan interest rate", "% APR", 5.99, 0, 36},
+	{"unsecured_term", "Unsecured loan term", "years", 25, 1, 30},
+	{"unsecured_fee", "Unsecured loan dealer fee", "% of the loan", 20, 0, 50},
+	{"lease_payment", "Lease payment", "$ per kW a month", 18, 0, 200},
+	{"lease_escalator", "Lease payment increase", "% a year", 2.9, 0, 10},
+	{"lease_term", "Lease term", "years", 25, 1, 30},
+	{"ppa_price", "Power purchase agreement price", "$ per kwh", 0.14, 0, 2},
+	{"ppa_escalator", "Power purchase agreement price increase", "% a year", 2.9, 0, 10},
+	{"ppa_term", "Power purchase agreement term", "years", 25, 1, 30},
+}
+
+/*This is one month of a financing option's cash flows. Month 0 is the day
+the system is installed.*/
+type MonthFlow struct {
+	Month      int     `json:"month"`
+	Savings    float64 `json:"savings"`    //electricity bill savings
+	Incentives float64 `json:"incentives"` //rebates and tax credits, and the yearly incentives spread over the months
+	Payment    float64 `json:"payment"`    //cash paid, loan payment, lease payment or electricity bought from the PPA
+	OMCost     float64 `json:"om_cost"`    //operation and maintenance, paid by the owner of the system
+	Net        float64 `json:"net"`        //savings and incentives less payment and maintenance
+	Cumulative float64 `json:"cumulative"` //net of this month and every month before it
+}
+
+/*This is the struct storing one way of paying for a system and what it
+saves. The payback is nil if it never pays for itself.*/
+type FinancingOption struct {
+	Kind            string      `json:"kind"`
+	Name            string      `json:"name"`
+	Upfront         float64     `json:"upfront"`              //paid on the day the system is installed
+	Financed        float64     `json:"financed,omitempty"`   //borrowed, with the dealer fee
+	DealerFee       float64     `json:"dealer_fee,omitempty"` //part of what is borrowed that is the dealer fee
+	Payment         float64     `json:"monthly_payment"`      //first month's loan, lease or PPA payment
+	Term            int         `json:"term_years,omitempty"` //years of loan, lease or PPA payments
+	TotalPaid       float64     `json:"total_paid"`           //upfront and every payment
+	Savings         float64     `json:"savings"`              //bill savings over the years analyzed
+	Incentives      float64     `json:"incentives"`           //incentives the customer gets
+	OMCost          float64     `json:"om_cost"`              //operation and maintenance the customer pays
+	LifetimeSavings float64     `json:"lifetime_savings"`     //savings and incentives less everything paid
+	Payback         *float64    `json:"payback_years"`        //years until the cumulative net turns positive, 0 if it never goes below 0
+	Note            string      `json:"note,omitempty"`       //how a term longer than the years analyzed is counted
+	Yearly          []float64   `json:"yearly_net"`           //net of the day the system is installed, then of each year after it
+	Months          []MonthFlow `json:"monthly_cash_flows,omitempty"`
+}
+
+/*This is the struct storing every financing option of a system, side by
+side, and the one that saves the most.*/
+type Financing struct {
+	Options []FinancingOption `json:"options"`
+	Best    string            `json:"best"` //kind of the option with the largest lifetime savings
+}
+
+//Works out each financing option of a system that costs gross before its
+//incentives, with panels rated systemKW making production kwh in its first
+//year that each save value on the bills. The production of each month is its
+//share of the year (shares add up to 1). Cash and loan customers own the
+//system: they get the incentives, with the tax credits in month 12, and pay
+//for maintenance. A loan that runs past the years analyzed is paid off in
+//the last month. Lease and PPA customers get only the bill savings while
+//the lease or PPA lasts, and the owner keeps the incentives; one that runs
+//past the years analyzed is counted only until then, its payments and
+//savings alike. Nothing pays back if the system makes nothing.
+func AnalyzeFinancing(inputs FinanceInputs, breakdown CostBreakdown, systemKW, production, value float64, shares [12]float64) Financing {
+	months := 12 * int(math.Round(inputs.Value("years")))
+	var rebates, credits float64
+	for _, applied := range breakdown.Applied {
+		switch applied.Kind {
+		case IncentiveRebate:
+			rebates += applied.Upfront
+		case IncentiveTaxCredit:
+			credits += applied.Upfront
+		}
+	}
+
+	//the bill savings, production and yearly incentives of each month
+	savings := make([]float64, months+1)
+	yearly := make([]float64, months+1)
+	made := make([]float64, months+1)
+	for month := 1; month <= months; month++ {
+		year := (month-1)/12 + 1
+		annual := production * math.Pow(1-inputs.Value("degradation")/100, float64(year-1))
+		made[month] = annual * shares[(month-1)%12]
+		savings[month] = made[month] * value * math.Pow(1+inputs.Value("escalation")/100, float64(year-1))
+		for _, applied := range breakdown.Applied {
+			yearly[month] += applied.Payment(year, annual) / 12
+		}
+	}
+	omCost := inputs.Value("om_cost") * systemKW / 12
+
+	//cash and loans: the customer owns the system
+	owned := func(kind, name string, payment float64, term int) FinancingOption {
+		option := FinancingOption{Kind: kind, Name: name, Payment: payment, Term: term}
+		flows := make([]MonthFlow, months+1)
+		for month := 1; month <= months; month++ {
+			flow := MonthFlow{Month: month, Savings: savings[month], Incentives: yearly[month], OMCost: omCost}
+			if month == 12 {
+				flow.Incentives += credits
+			}
+			if month <= 12*term {
+				flow.Payment = payment
+			}
+			flows[month] = flow
+		}
+		option.Months = flows
+		return option
+	}
+	cash := owned(FinancingCash, "Cash", 0, 0)
+	cash.Months[0] = MonthFlow{Incentives: rebates, Payment: breakdown.Gross}
+	cash.Upfront = breakdown.Gross - rebates
+	options := []FinancingOption{cash}
+	for _, loan := range []struct{ kind, name, key string }{{FinancingSecured, "Secured loan", "secured"}, {FinancingUnsecured, "Unsecured loan", "unsecured"}} {
+		term := int(math.Round(inputs.Value(loan.key + "_term")))
+		fee := inputs.Value(loan.key+"_fee") / 100
+		financed := (breakdown.Gross - rebates) / (1 - fee)
+		apr := inputs.Value(loan.key + "_apr")
+		option := owned(loan.kind, loan.name, LoanPayment(financed, apr, 12*term), term)
+		option.Financed, option.DealerFee = math.Round(financed*100)/100, math.Round(financed*fee*100)/100
+		if 12*term > months {
+			payoff := LoanBalance(financed, apr, 12*term, months)
+			option.Months[months].Payment += payoff
+			option.Note = fmt.Sprintf("The loan runs %d years past the %d analyzed; its last month pays off the $%.2f left.", term-months/12, months/12, payoff)
+		}
+		options = append(options, option)
+	}
+
+	//leases and PPAs: the payment rises each year and stops at the end of the term
+	for _, contract := range []struct{ kind, name, key string }{{FinancingLease, "Lease", "lease"}, {FinancingPPA, "Power purchase agreement", "ppa"}} {
+		term := int(math.Round(inputs.Value(contract.key + "_term")))
+		escalator := inputs.Value(contract.key+"_escalator") / 100
+		option := FinancingOption{Kind: contract.kind, Name: contract.name, Term: term}
+		flows := make([]MonthFlow, months+1)
+		for month := 1; month <= months; month++ {
+			flow := MonthFlow{Month: month}
+			if month <= 12*term {
+				rise := math.Pow(1+escalator, float64((month-1)/12))
+				flow.Savings = savings[month]
+				if contract.kind == FinancingLease {
+					flow.Payment = inputs.Value("lease_payment") * systemKW * rise
+				} else {
+					flow.Payment = inputs.Value("ppa_price") * made[month] * rise
+				}
+			}
+			flows[month] = flow
+		}
+		if len(flows) > 1 {
+			option.Payment = flows[1].Payment
+		}
+		if 12*term > months {
+			option.Note = fmt.Sprintf("The %s runs %d years past the %d analyzed; only its first %d are counted.", strings.ToLower(contract.name), term-months/12, months/12, months/12)
+		}
+		option.Months = flows
+		options = append(options, option)
+	}
+
+	comparison := Financing{Options: options}
+	var best float64
+	for i := range comparison.Options {
+		option := &comparison.Options[i]
+		option.Total()
+		if production <= 0 {
+			option.Payback = nil
+		}
+		if i == 0 || option.LifetimeSavings > best {
+			comparison.Best, best = option.Kind, option.LifetimeSavings
+		}
+	}
+	return comparison
+}
+
+//Gives the monthly payment of a loan of amount at an APR (%), paid off in
+//months equal payments.
+func LoanPayment(amount, apr float64, months int) float64 {
+	if months <= 0 {
+		return 0
+	}
+	rate := apr / 100 / 12
+	if rate == 0 {
+		return amount / float64(months)
+	}
+	return amount * rate / (1 - math.Pow(1+rate, -float64(months)))
+}
+
+//Gives what is left to pay on a loan of amount at an APR (%), paid off in
+//months equal payments, after paid of them.
+func LoanBalance(amount, apr float64, months, paid int) float64 {
+	if paid >= months {
+		return 0
+	}
+	rate := apr / 100 / 12
+	if rate == 0 {
+		return amount * float64(months-paid) / float64(months)
+	}
+	grown := math.Pow(1+rate, float64(paid))
+	return amount*grown - LoanPayment(amount, apr, months)*(grown-1)/rate
+}
+
+//Adds up an option's monthly net cash flows into years. The first is the day
+//the system is installed and the rest are the years after it.
+func (option *FinancingOption) YearlyNet() []float64 {
+	if len(option.Months) == 0 {
+		return nil
+	}
+	years := make([]float64, (len(option.Months)+10)/12+1)
+	for i, flow := range option.Months {
+		year := (i + 11) / 12
+		years[year] += flow.Net
+	}
+	for i := range years {
+		years[i] = math.Round(years[i]*100) / 100
+	}
+	return years
+}
+
+//Adds up an option's monthly cash flows into its totals, cumulative net and
+//payback, and rounds them to cents.
+func (option *FinancingOption) Total() {
+	var cumulative float64
+	negative := false
+	for i := range option.Months {
+		flow := &option.Months[i]
+		flow.Net = flow.Savings + flow.Incentives - flow.Payment - flow.OMCost
+		previous := cumulative
+		cumulative += flow.Net
+		flow.Cumulative = cumulative
+		option.TotalPaid += flow.Payment
+		option.Savings += flow.Savings
+		option.Incentives += flow.Incentives
+		option.OMCost += flow.OMCost
+		if cumulative < 0 {
+			negative = true
+		} else if previous < 0 && option.Payback == nil {
+			years := (float64(flow.Month-1) + -previous/(cumulative-previous)) / 12
+			years = math.Round(years*10) / 10
+			option.Payback = &years
+		}
+		for _, value := range []*float64{&flow.Savings, &flow.Incentives, &flow.Payment, &flow.OMCost, &flow.Net, &flow.Cumulative} {
+			*value = math.Round(*value*100) / 100
+		}
+	}
+	if !negative {
+		zero := 0.0
+		option.Payback = &zero
+	} else if cumulative < 0 {
+		option.Payback = nil
+	}
+	option.LifetimeSavings = cumulative
+	option.Yearly = option.YearlyNet()
+	for _, value := range []*float64{&option.Upfront, &option.Payment, &option.TotalPaid, &option.Savings, &option.Incentives, &option.OMCost, &option.LifetimeSavings} {
+		*value = math.Round(*value*100) / 100
+	}
+}
@@ -0,0 +1,3 @@
+module webtest
+
+go 1.21
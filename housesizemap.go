@@ -8,15 +8,18 @@ each city.*/
 package main
 
 import (
-	"html/template"
-	"log"
 	"net/http"
-	"strconv"
-	"strings"
+	"net/url"
 )
 
 //This section asks the user for their house and roof size.
 func DisplayHouseSize(w http.ResponseWriter, r *http.Request) {
+	RenderPage(w, http.StatusOK, "housesizemap.html", HouseSizePage(nil, nil))
+}
+
+//Makes the variables for the page asking for the house and roof size, with
+//any errors from a previous submission.
+func HouseSizePage(form url.Values, errs ValidationErrors) PageVariables {
 	PageTitle := "Heat Map"
 
 	var MyRoof float64
@@ -25,22 +28,13 @@ func DisplayHouseSize(w http.ResponseWriter, r *http.Request) {
 		House{"housesizeinput", 0, "Size"},
 	}
 
-	PageVars := PageVariables{
+	return PageVariables{
 		PageTitle:     PageTitle,
 		PageHouseSize: MyHouse,
 		PageRoofSize:  MyRoof,
+		FieldErrors:   errs.ByField(),
+		FormValues:    FormValues(form, "housesizeinput", "roofsize"),
 	}
-
-	t, err := template.ParseFiles("housesizemap.html") //Parse the html file housesizemap.html
-	if err != nil {
-		log.Print("template parsing error:", err)
-	}
-
-	err = t.Execute(w, PageVars) //execute the template and pass it the PageVars
-	if err != nil {
-		log.Print("template executing error:", err)
-	}
-
 }
 
 //This section is where the user can look at their results based on their input.
@@ -49,44 +43,22 @@ func DisplayHouseSize(w http.ResponseWriter, r *http.Request) {
 //category.
 func UserInteracts(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm() //Parse the page for the variables needed
-	red := "red"
-	yellow := "yellow"
-	green := "green"
-	cityData := MakeCityMap("energy.csv")
-	houseSize, err1 := strconv.ParseFloat(r.Form.Get("housesizeinput"), 64)
-	ErrorMessage(err1, "house size", houseSize)
-	roofSize, err2 := strconv.ParseFloat(r.Form.Get("roofsize"), 64)
-	ErrorMessage(err2, "roof size", roofSize)
-	heatMap := MakeColorMarkers(cityData, houseSize, roofSize)
-	mapColors := MakeColors("energy.csv", cityData, houseSize, roofSize)
-	redList := MakeList(heatMap, red)
-	yellowList := MakeList(heatMap, yellow)
-	greenList := MakeList(heatMap, green)
-	redPercentage := ColorPercent(heatMap, "red")
-	yellowPercentage := ColorPercent(heatMap, "yellow")
-	greenPercentage := ColorPercent(heatMap, "green")
-	Title := "House Size Map"
-
-	PageVars := PageVariables{
-		PageTitle:     Title,
-		Map:           mapColors,
-		RedList:       redList,
-		YellowList:    yellowList,
-		GreenList:     greenList,
-		RedPercent:    redPercentage,
-		YellowPercent: yellowPercentage,
-		GreenPercent:  greenPercentage,
-	}
-
-	t, err := template.ParseFiles("housesizemap.html")
-	if err != nil {
-		log.Print("template parsing error: ", err)
+	houseSize, roofSize, errs := ParseHeatmapForm(r.Form, "housesizeinput")
+	if len(errs) > 0 {
+		RenderPage(w, http.StatusBadRequest, "housesizemap.html", HouseSizePage(r.Form, errs))
+		return
 	}
+	PageVars := ComputeHeatmap(Data.Get(), houseSize, roofSize).PageVariables()
+	RenderPage(w, http.StatusOK, "housesizemap.html", PageVars)
+}
 
-	err = t.Execute(w, PageVars)
-	if err != nil {
-		log.Print("template executing error: ", err)
-	}
+//Gives the output of panels lying flat on a roof in a city, at the standard
+//15% efficiency, for the heat map. (in kwh per month)
+func HeatmapOutput(cityName string, cityData map[string]City, roofSize float64) float64 {
+	plane := Plane{Azimuth: EquatorAzimuth(cityData[cityName].lat), Model: DefaultTransposition}
+	system := DefaultSystem()
+	system.Efficiency = 15
+	return PlaneOutput(cityName, cityData, plane, system, roofSize)
 }
 
 //Makes a map of color markers for each city based on chose house size and difference in output
@@ -95,7 +67,7 @@ func MakeColorMarkers(cityData map[string]City, houseSize, roofSize float64) map
 	var mapColor string
 	colors := make(map[string]string)
 	for cityName, _ := range cityData {
-		output = SolarOutput(cityName, cityData, "horizontal", 15, roofSize)
+		output = HeatmapOutput(cityName, cityData, roofSize)
 		output = float64(int(output*100)) / 100
 		avgEnergy = AverageEnergy(cityData, cityName) * houseSize
 		avgEnergy = float64(int(avgEnergy*100)) / 100
@@ -142,25 +114,22 @@ func ColorPercent(colors map[string]string, color string) float64 {
 	//since there are 98 cities
 }
 
-//Make an array of the city names.
-func MakeCityArray(filename string) []string {
-	lines := ReadFile(filename)
+//Make an array of the city names, in the same order as the file.
+func MakeCityArray(records []Record) []string {
 	cityArray := make([]string, 0)
-	for i := 0; i < len(lines); i++ {
-		var items []string = strings.Split(lines[i], ",")
-		cityArray = append(cityArray, items[0])
+	for _, record := range records {
+		cityArray = append(cityArray, record.Key(CitySchema))
 	}
 	return cityArray
 }
 
 //Make an array of colors based alphabetically.
-func MakeColors(filename string, cityData map[string]City, houseSize, roofSize float64) []string {
+func MakeColors(cityNames []string, cityData map[string]City, houseSize, roofSize float64) []string {
 	var output, avgEnergy float64
 	var mapColor string
-	cityNames := MakeCityArray(filename)
 	colors := make([]string, 0)
 	for _, cityName := range cityNames {
-		output = SolarOutput(cityName, cityData, "horizontal", 15, roofSize)
+		output = HeatmapOutput(cityName, cityData, roofSize)
 		avgEnergy = AverageEnergy(cityData, cityName) * houseSize
 		mapColor = MapColor(avgEnergy, output)
 		if mapColor == "red" {
@@ -0,0 +1,274 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file takes the incentives a house can get off the cost of
+its solar system. The incentives are read from incentives.csv, each for a
+state, a utility or everyone, with the sizes of system it is for and the day
+it ends. They are applied in order: rebates first, which lower the cost the
+tax credits after them are a percentage of, then the tax credits. Property
+tax exemptions and performance payments (such as solar renewable energy
+certificates) are paid each year instead, and go into the cash flows.*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+//Kinds of incentive.
+const (
+	IncentiveRebate       = "rebate"        //paid when the system is installed, lowers the cost later percentages are of
+	IncentiveTaxCredit    = "tax_credit"    //taken off taxes for the year the system is installed
+	IncentiveTaxExemption = "tax_exemption" //property tax not paid on the value the system adds, each year
+	IncentivePerformance  = "performance"   //paid for each kwh made, each year
+)
+
+//The columns of incentives.csv.
+var IncentiveSchema = Schema{
+	Name: "incentives",
+	Key:  "incentive",
+	Columns: []Column{
+		{Name: "incentive", Unit: "key of the incentive", Kind: KindText, Required: true},
+		{Name: "name", Kind: KindText, Required: true},
+		{Name: "kind", Unit: "rebate, tax_credit, tax_exemption or performance", Kind: KindText, Required: true},
+		{Name: "state", Unit: "two letters, everywhere if empty", Kind: KindText},
+		{Name: "utility", Unit: "the tariff's utility, every utility if empty", Kind: KindText},
+		{Name: "order", Unit: "incentives are applied from the lowest", Kind: KindNumber, Min: 0, Max: 1000, Required: true},
+		{Name: "percent", Unit: "% of the cost, or for tax_exemption the property tax rate", Kind: KindNumber, Min: 0, Max: 100},
+		{Name: "per_watt", Unit: "$/W of panels at STC", Kind: KindNumber, Min: 0, Max: 10},
+		{Name: "amount", Unit: "$, each year for tax_exemption and performance", Kind: KindNumber, Min: 0, Max: 100000},
+		{Name: "per_kwh", Unit: "$/kwh made, for performance", Kind: KindNumber, Min: 0, Max: 5},
+		{Name: "years", Unit: "years tax_exemption and performance pay for (every year if empty)", Kind: KindNumber, Min: 1, Max: 40},
+		{Name: "cap", Unit: "$ most it pays, each year for tax_exemption and performance", Kind: KindNumber, Min: 0, Max: 1000000},
+		{Name: "min_kw", Unit: "kW of panels, smallest system it is for", Kind: KindNumber, Min: 0, Max: 10000},
+		{Name: "max_kw", Unit: "kW of panels, largest system it is for", Kind: KindNumber, Min: 0, Max: 10000},
+		{Name: "expires", Unit: "date, YYYY-MM-DD, last day a system can be installed", Kind: KindText},
+		{Name: "source", Kind: KindText},
+	},
+}
+
+/*This is the struct storing an incentive: who can get it, what it pays and
+where it comes in the order.*/
+type Incentive struct {
+	Key     string  `json:"incentive"`
+	Name    string  `json:"name"`
+	Kind    string  `json:"kind"`
+	State   string  `json:"state,omitempty"`
+	Utility string  `json:"utility,omitempty"`
+	Order   float64 `json:"order"`
+	Percent float64 `json:"percent,omitempty"`
+	PerWatt float64 `json:"per_watt,omitempty"`
+	Amount  float64 `json:"amount,omitempty"`
+	PerKwh  float64 `json:"per_kwh,omitempty"`
+	Years   int     `json:"years,omitempty"` //0 for every year
+	Cap     float64 `json:"cap,omitempty"`   //0 for no cap
+	MinKW   float64 `json:"min_kw,omitempty"`
+	MaxKW   float64 `json:"max_kw,omitempty"` //0 for any size
+	Expires string  `json:"expires,omitempty"`
+	Source  string  `json:"source,omitempty"`
+}
+
+//Reads incentives.csv. The file is optional, so a file that doesn't exist
+//gives no records and no error.
+func ReadIncentives(filename string) ([]Record, Diagnostics, error) {
+	if filename == "" {
+		return nil, nil, nil
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	return ReadTable(filename, IncentiveSchema)
+}
+
+//Makes the incentives in the order they are applied. Unknown kinds, states
+//that aren't two letters, dates that can't be read and incentives that pay
+//nothing are reported in the diagnostics.
+func MakeIncentives(records []Record, filename string, diagnostics *Diagnostics) []Incentive {
+	incentives := make([]Incentive, 0, len(records))
+	for _, record := range records {
+		incentive := Incentive{
+			Key:     record.Key(IncentiveSchema),
+			Name:    record.Text["name"],
+			Kind:    record.Text["kind"],
+			State:   record.Text["state"],
+			Utility: record.Text["utility"],
+			Order:   record.Numbers["order"],
+			Percent: record.Numbers["percent"],
+			PerWatt: record.Numbers["per_watt"],
+			Amount:  record.Numbers["amount"],
+			PerKwh:  record.Numbers["per_kwh"],
+			Years:   int(record.Numbers["years"]),
+			Cap:     record.Numbers["cap"],
+			MinKW:   record.Numbers["min_kw"],
+			MaxKW:   record.Numbers["max_kw"],
+			Expires: record.Text["expires"],
+			Source:  record.Text["source"],
+		}
+		switch incentive.Kind {
+		case IncentiveRebate, IncentiveTaxCredit, IncentiveTaxExemption, IncentivePerformance:
+		default:
+			diagnostics.Add(filename, record.Line, "kind", fmt.Sprintf("%q is not %s, %s, %s or %s", incentive.Kind, IncentiveRebate, IncentiveTaxCredit, IncentiveTaxExemption, IncentivePerformance))
+			continue
+		}
+		if incentive.State != "" && !IsStateCode(incentive.State) {
+			diagnostics.Add(filename, record.Line, "state", fmt.Sprintf("%q is not two capital letters like NY", incentive.State))
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", incentive.Expires); incentive.Expires != "" && err != nil {
+			diagnostics.Add(filename, record.Line, "expires", fmt.Sprintf("%q is not a date like 2025-12-31", incentive.Expires))
+			continue
+		}
+		if incentive.Percent == 0 && incentive.PerWatt == 0 && incentive.Amount == 0 && incentive.PerKwh == 0 {
+			diagnostics.Add(filename, record.Line, "", "percent, per_watt, amount or per_kwh is needed")
+			continue
+		}
+		if incentive.PerKwh > 0 && incentive.Kind != IncentivePerformance {
+			diagnostics.Add(filename, record.Line, "per_kwh", "only performance incentives are paid per kwh")
+			continue
+		}
+		incentives = append(incentives, incentive)
+	}
+	sort.SliceStable(incentives, func(i, j int) bool {
+		if incentives[i].Order != incentives[j].Order {
+			return incentives[i].Order < incentives[j].Order
+		}
+		return incentives[i].Key < incentives[j].Key
+	})
+	return incentives
+}
+
+//Says whether a state is written as two capital letters, like NY.
+func IsStateCode(state string) bool {
+	if len(state) != 2 {
+		return false
+	}
+	for _, letter := range state {
+		if letter < 'A' || letter > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+//Checks that every city's state is two capital letters.
+func CheckCityStates(records []Record, filename string, diagnostics *Diagnostics) {
+	for _, record := range records {
+		if state := record.Text["state"]; state != "" && !IsStateCode(state) {
+			diagnostics.Add(filename, record.Line, "state", fmt.Sprintf("%q is not two capital letters like NY", state))
+		}
+	}
+}
+
+/*This is one incentive applied to a system: what it takes off the cost when
+the system is installed, or what it pays in the first year and how many
+years it pays. Total is everything it pays over the years analyzed.*/
+type AppliedIncentive struct {
+	Key     string  `json:"incentive"`
+	Name    string  `json:"name"`
+	Kind    string  `json:"kind"`
+	Upfront float64 `json:"upfront"`              //taken off the cost
+	Yearly  float64 `json:"first_year,omitempty"` //paid in the first year
+	Years   int     `json:"years,omitempty"`      //years it pays (every year if 0)
+	Total   float64 `json:"total"`
+
+	perKwh float64 //$ per kwh made each year
+	fixed  float64 //$ each year
+	cap    float64 //$ most each year, 0 for no cap
+}
+
+/*This is an incentive that is for the house's state or utility but not for
+this system, and why.*/
+type SkippedIncentive struct {
+	Key    string `json:"incentive"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+/*This is the struct storing a system's cost before and after the incentives
+taken off it when it is installed, with every incentive it gets.*/
+type CostBreakdown struct {
+	Gross      float64            `json:"gross_cost"`         //panels and installation
+	Applied    []AppliedIncentive `json:"applied"`            //in the order they were applied
+	Upfront    float64            `json:"upfront_incentives"` //rebates and tax credits
+	Net        float64            `json:"net_cost"`           //gross less the rebates and tax credits
+	Yearly     float64            `json:"yearly_incentives"`  //paid in the first year
+	Ineligible []SkippedIncentive `json:"ineligible,omitempty"`
+}
+
+//Applies the incentives for a state and utility to a system installed on a
+//day, costing gross, with panels rated systemKW making production kwh in its
+//first year. Incentives for other states and utilities are left out, and
+//ones that have ended or are for other sizes of system are listed as
+//ineligible.
+func ApplyIncentives(incentives []Incentive, state, utility string, installed time.Time, gross, systemKW, production float64) CostBreakdown {
+	breakdown := CostBreakdown{Gross: gross, Applied: []AppliedIncentive{}}
+	basis := gross //the cost percentages are of, after the rebates so far
+	for _, incentive := range incentives {
+		if (incentive.State != "" && incentive.State != state) || (incentive.Utility != "" && incentive.Utility != utility) {
+			continue
+		}
+		if reason := incentive.Ineligible(installed, systemKW); reason != "" {
+			breakdown.Ineligible = append(breakdown.Ineligible, SkippedIncentive{incentive.Key, incentive.Name, reason})
+			continue
+		}
+		applied := AppliedIncentive{Key: incentive.Key, Name: incentive.Name, Kind: incentive.Kind, Years: incentive.Years, cap: incentive.Cap}
+		switch incentive.Kind {
+		case IncentiveRebate, IncentiveTaxCredit:
+			amount := incentive.Amount + incentive.PerWatt*systemKW*1000 + incentive.Percent/100*basis
+			if incentive.Cap > 0 {
+				amount = math.Min(amount, incentive.Cap)
+			}
+			amount = math.Min(amount, gross-breakdown.Upfront)
+			applied.Upfront = math.Round(amount*100) / 100
+			applied.Total = applied.Upfront
+			breakdown.Upfront += amount
+			if incentive.Kind == IncentiveRebate {
+				basis -= amount
+			}
+		case IncentiveTaxExemption:
+			applied.fixed = incentive.Amount + incentive.Percent/100*gross
+		case IncentivePerformance:
+			applied.fixed, applied.perKwh = incentive.Amount, incentive.PerKwh
+		}
+		applied.Yearly = math.Round(applied.Payment(1, production)*100) / 100
+		breakdown.Yearly += applied.Yearly
+		breakdown.Applied = append(breakdown.Applied, applied)
+	}
+	breakdown.Upfront = math.Round(breakdown.Upfront*100) / 100
+	breakdown.Net = math.Round((gross-breakdown.Upfront)*100) / 100
+	breakdown.Yearly = math.Round(breakdown.Yearly*100) / 100
+	return breakdown
+}
+
+//Gives why a system installed on a day with panels rated systemKW can't get
+//the incentive, or "" if it can.
+func (incentive Incentive) Ineligible(installed time.Time, systemKW float64) string {
+	if incentive.Expires != "" {
+		if expires, err := time.Parse("2006-01-02", incentive.Expires); err == nil && installed.After(expires.Add(24*time.Hour-time.Nanosecond)) {
+			return "it ended on " + incentive.Expires
+		}
+	}
+	if incentive.MaxKW > 0 && systemKW > incentive.MaxKW {
+		return fmt.Sprintf("it is for systems up to %s kW", FormatNumber(incentive.MaxKW))
+	}
+	if systemKW < incentive.MinKW {
+		return fmt.Sprintf("it is for systems of %s kW or more", FormatNumber(incentive.MinKW))
+	}
+	return ""
+}
+
+//Gives what a yearly incentive pays in a year, counted from 1, when the
+//system makes production kwh in it. Incentives paid when the system is
+//installed pay nothing in later years.
+func (applied AppliedIncentive) Payment(year int, production float64) float64 {
+	if applied.Years > 0 && year > applied.Years {
+		return 0
+	}
+	payment := applied.fixed + applied.perKwh*production
+	if applied.cap > 0 {
+		payment = math.Min(payment, applied.cap)
+	}
+	return payment
+}
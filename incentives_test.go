@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Incentives like New York's, in order: a rebate per watt, then tax credits of
+//a percent of the cost after it, one of them capped, then yearly payments.
+var testIncentives = []Incentive{
+	{Key: "rebate", Kind: IncentiveRebate, State: "NY", Order: 10, PerWatt: 0.2, MaxKW: 25},
+	{Key: "federal", Kind: IncentiveTaxCredit, Order: 20, Percent: 30, Expires: "2025-12-31"},
+	{Key: "state", Kind: IncentiveTaxCredit, State: "NY", Order: 21, Percent: 25, Cap: 5000},
+	{Key: "property", Kind: IncentiveTaxExemption, State: "NY", Order: 30, Percent: 1.8, Years: 15},
+	{Key: "srec", Kind: IncentivePerformance, State: "NY", Order: 40, PerKwh: 0.05, Years: 10, Cap: 300},
+}
+
+func TestApplyIncentives(t *testing.T) {
+	installed := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	breakdown := ApplyIncentives(testIncentives, "NY", "", installed, 30000, 10, 12000)
+	want := []struct {
+		key             string
+		upfront, yearly float64
+	}{
+		{"rebate", 2000, 0},  //$0.20 a watt of 10 kW
+		{"federal", 8400, 0}, //30% of the $28,000 left after the rebate
+		{"state", 5000, 0},   //25% is $7,000, capped
+		{"property", 0, 540}, //1.8% of the $30,000 the system adds
+		{"srec", 0, 300},     //$0.05 a kwh of 12,000 is $600, capped
+	}
+	if len(breakdown.Applied) != len(want) {
+		t.Fatalf("applied %v, want %d incentives", breakdown.Applied, len(want))
+	}
+	for i, c := range want {
+		applied := breakdown.Applied[i]
+		if applied.Key != c.key || applied.Upfront != c.upfront || applied.Yearly != c.yearly {
+			t.Errorf("incentive %d is %s, $%v upfront and $%v a year, want %s, $%v and $%v", i, applied.Key, applied.Upfront, applied.Yearly, c.key, c.upfront, c.yearly)
+		}
+	}
+	if breakdown.Upfront != 15400 || breakdown.Net != 14600 || breakdown.Yearly != 840 || len(breakdown.Ineligible) != 0 {
+		t.Errorf("$%v upfront, $%v net, $%v a year and %v ineligible, want $15400, $14600, $840 and none", breakdown.Upfront, breakdown.Net, breakdown.Yearly, breakdown.Ineligible)
+	}
+
+	//the yearly payments stop after their years
+	payments := []struct {
+		applied AppliedIncentive
+		year    int
+		want    float64
+	}{
+		{breakdown.Applied[4], 10, 300},
+		{breakdown.Applied[4], 11, 0},
+		{breakdown.Applied[3], 15, 540},
+		{breakdown.Applied[3], 16, 0},
+	}
+	for _, c := range payments {
+		if got := c.applied.Payment(c.year, 12000); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s pays %v in year %d, want %v", c.applied.Key, got, c.year, c.want)
+		}
+	}
+}
+
+func TestApplyIncentivesEligibility(t *testing.T) {
+	cases := []struct {
+		name      string
+		state     string
+		installed time.Time
+		systemKW  float64
+		upfront   float64
+		skipped   string
+	}{
+		{"on the day the credit ends", "NY", time.Date(2025, 12, 31, 18, 0, 0, 0, time.UTC), 10, 15400, ""},
+		{"after the credit ends", "NY", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), 10, 7000, "it ended on 2025-12-31"},          //$2,000 and $5,000
+		{"too big for the rebate", "NY", time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), 30, 14000, "it is for systems up to 25 kW"}, //$9,000 and $5,000
+		{"another state", "CA", time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), 10, 9000, ""},
+	}
+	for _, c := range cases {
+		breakdown := ApplyIncentives(testIncentives, c.state, "", c.installed, 30000, c.systemKW, 12000)
+		if breakdown.Upfront != c.upfront {
+			t.Errorf("%s: $%v upfront, want $%v", c.name, breakdown.Upfront, c.upfront)
+		}
+		switch {
+		case c.skipped == "" && len(breakdown.Ineligible) != 0:
+			t.Errorf("%s: %v ineligible, want none", c.name, breakdown.Ineligible)
+		case c.skipped != "" && (len(breakdown.Ineligible) != 1 || !strings.Contains(breakdown.Ineligible[0].Reason, c.skipped)):
+			t.Errorf("%s: %v ineligible, want one because %s", c.name, breakdown.Ineligible, c.skipped)
+		}
+	}
+
+	//incentives never take more than the system costs
+	rebate := []Incentive{{Key: "big", Kind: IncentiveRebate, Amount: 8000}, {Key: "credit", Kind: IncentiveTaxCredit, Percent: 30}}
+	if breakdown := ApplyIncentives(rebate, "NY", "", time.Now(), 5000, 2, 0); breakdown.Upfront != 5000 || breakdown.Net != 0 {
+		t.Errorf("$%v upfront and $%v net on a $5000 system, want $5000 and $0", breakdown.Upfront, breakdown.Net)
+	}
+}
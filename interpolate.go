@@ -0,0 +1,175 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file blends the climate data of the cities nearest to the
+user into one record for their site, using inverse-distance weighting. The
+blended record is a City, so every calculation that works for a city in
+energy.csv works for it too.*/
+
+package main
+
+import (
+	"math"
+)
+
+//Ways of picking the climate data for the user's site.
+const (
+	ModeNearest     = "nearest"      //use the closest city as-is
+	ModeIDW         = "idw"          //inverse-distance weighted blend of the closest cities
+	ModeIDWLatitude = "idw-latitude" //same, after correcting each city's data to the user's latitude
+)
+
+//Defaults for the blend.
+const (
+	DefaultNeighbors = 4
+	DefaultIDWPower  = 2.0
+	MaxNeighbors     = 10
+	SiteName         = "Your site" //name of the blended record
+)
+
+//How much the optimal angle changes per degree of latitude (Jacobson and Jadhav, 2018).
+const OptAngleSlope = 0.764
+
+//Solar constant in W/m².
+const SolarConstant = 1367.0
+
+/*This is the struct storing how much one city contributed to the blend.*/
+type StationWeight struct {
+	City       string  `json:"city"`
+	DistanceKm float64 `json:"distance_km"`
+	Weight     float64 `json:"weight"` //share of the blend, all weights add up to 1
+}
+
+/*This is the struct describing how the site's climate data was made.*/
+type Interpolation struct {
+	Method   string          `json:"method"`
+	Power    float64         `json:"power"`
+	Stations []StationWeight `json:"stations"`
+}
+
+//Works out the inverse-distance weight of each neighbor (nearest first). A
+//neighbor at the user's exact location gets all of the weight.
+func IDWWeights(neighbors []Neighbor, power float64) []StationWeight {
+	weights := make([]StationWeight, len(neighbors))
+	for i, neighbor := range neighbors {
+		weights[i] = StationWeight{City: neighbor.City, DistanceKm: neighbor.DistanceKm}
+	}
+	if len(neighbors) > 0 && neighbors[0].DistanceKm < 0.001 {
+		weights[0].Weight = 1
+		return weights
+	}
+	var total float64
+	for i, neighbor := range neighbors {
+		weights[i].Weight = 1 / math.Pow(neighbor.DistanceKm, power)
+		total += weights[i].Weight
+	}
+	for i := range weights {
+		weights[i].Weight /= total
+	}
+	return weights
+}
+
+//Blends the neighbors' data into one City at the user's coordinates. With
+//latitudeAware, each city's radiation is first scaled by how much sunlight
+//reaches the top of the atmosphere at the user's latitude compared to the
+//city's, and its optimal angle is shifted by the difference in latitude. The
+//monthly values are scaled month by month the same way. Companies come from
+//the closest city.
+func BlendCities(cityData map[string]City, weights []StationWeight, lat, lon float64, latitudeAware bool) City {
+	site := City{lat: lat, lon: lon, monthlySource: MonthlyMeasured}
+	siteH0 := AnnualExtraterrestrial(lat)
+	for _, station := range weights {
+		city := cityData[station.City]
+		radScale := 1.0
+		var monthScale [12]float64
+		optAng := city.optAng
+		for m := range monthScale {
+			monthScale[m] = 1
+		}
+		if latitudeAware {
+			if cityH0 := AnnualExtraterrestrial(city.lat); cityH0 > 0 {
+				radScale = siteH0 / cityH0
+			}
+			for m, day := range MidMonthDay {
+				if cityH0 := ExtraterrestrialDaily(city.lat, day); cityH0 > 0 {
+					monthScale[m] = ExtraterrestrialDaily(lat, day) / cityH0
+				}
+			}
+			optAng += OptAngleSlope * (math.Abs(lat) - math.Abs(city.lat))
+		}
+		for m := range monthScale {
+			site.monthlyRad[m] += station.Weight * city.monthlyRad[m] * monthScale[m]
+			site.monthlyOptRad[m] += station.Weight * city.monthlyOptRad[m] * monthScale[m]
+			site.monthlyUse[m] += station.Weight * city.monthlyUse[m]
+		}
+		if city.monthlySource != MonthlyMeasured {
+			site.monthlySource = MonthlyEstimated
+		}
+		site.temp += station.Weight * city.temp
+		site.recordLow += station.Weight * city.recordLow
+		site.recordLowEstimated = site.recordLowEstimated || city.recordLowEstimated
+		site.solarRad += station.Weight * city.solarRad * radScale
+		site.optAng += station.Weight * optAng
+		site.optRad += station.Weight * city.optRad * radScale
+		site.avgEnergy += station.Weight * city.avgEnergy
+		site.instCost += station.Weight * city.instCost
+	}
+	site.optAng = math.Max(0, math.Min(90, site.optAng))
+	if len(weights) > 0 {
+		site.companies = cityData[weights[0].City].companies
+		site.export = cityData[weights[0].City].export
+		site.state = cityData[weights[0].City].state
+	}
+	return site
+}
+
+//Gives the city data and city name that the calculations should use for the
+//user's site. In ModeNearest this is the closest city itself; otherwise it is
+//a blended record under SiteName, along with how it was made.
+func SiteClimate(dataset *Dataset, req EstimateRequest, nearest []Neighbor) (map[string]City, string, *Interpolation) {
+	if req.Mode == "" || req.Mode == ModeNearest {
+		return dataset.Cities, nearest[0].City, nil
+	}
+	neighbors := req.Neighbors
+	if neighbors <= 0 {
+		neighbors = DefaultNeighbors
+	}
+	power := req.Power
+	if power <= 0 {
+		power = DefaultIDWPower
+	}
+	if neighbors > len(nearest) {
+		nearest = dataset.Index.Nearest(req.Latitude, req.Longitude, neighbors)
+	}
+	if neighbors > len(nearest) {
+		neighbors = len(nearest)
+	}
+	weights := IDWWeights(nearest[:neighbors], power)
+	site := BlendCities(dataset.Cities, weights, req.Latitude, req.Longitude, req.Mode == ModeIDWLatitude)
+	return map[string]City{SiteName: site}, SiteName, &Interpolation{req.Mode, power, weights}
+}
+
+//Gives the daily solar energy reaching a flat surface at the top of the
+//atmosphere at a latitude on a day of the year, in kWh/m² (Duffie and Beckman).
+func ExtraterrestrialDaily(latitude float64, day int) float64 {
+	lat := latitude * math.Pi / 180
+	decl := Declination(day)
+	cosSunset := math.Max(-1, math.Min(1, -math.Tan(lat)*math.Tan(decl)))
+	sunset := math.Acos(cosSunset)
+	eccentricity := 1 + 0.033*math.Cos(2*math.Pi*float64(day)/365)
+	h0 := 24 * 3600 * SolarConstant / math.Pi * eccentricity *
+		(math.Cos(lat)*math.Cos(decl)*math.Sin(sunset) + sunset*math.Sin(lat)*math.Sin(decl))
+	return h0 / 3.6e6
+}
+
+//Gives the angle of the sun north of the equator at noon on a day of the year, in radians (Cooper).
+func Declination(day int) float64 {
+	return 23.45 * math.Pi / 180 * math.Sin(2*math.Pi*float64(284+day)/365)
+}
+
+//Gives the yearly average of ExtraterrestrialDaily at a latitude.
+func AnnualExtraterrestrial(latitude float64) float64 {
+	var total float64
+	for day := 1; day <= 365; day++ {
+		total += ExtraterrestrialDaily(latitude, day)
+	}
+	return total / 365
+}
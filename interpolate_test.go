@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIDWWeights(t *testing.T) {
+	cases := []struct {
+		name      string
+		distances []float64
+		power     float64
+		want      []float64
+	}{
+		{"inverse square", []float64{10, 20}, 2, []float64{0.8, 0.2}},
+		{"inverse distance", []float64{10, 20, 40}, 1, []float64{4.0 / 7, 2.0 / 7, 1.0 / 7}},
+		{"equal distances", []float64{30, 30, 30}, 2, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}},
+		{"at a city", []float64{0, 20}, 2, []float64{1, 0}},
+	}
+	for _, c := range cases {
+		neighbors := make([]Neighbor, len(c.distances))
+		for i, km := range c.distances {
+			neighbors[i] = Neighbor{City: string(rune('A' + i)), DistanceKm: km}
+		}
+		weights := IDWWeights(neighbors, c.power)
+		for i, weight := range weights {
+			if math.Abs(weight.Weight-c.want[i]) > 1e-9 {
+				t.Errorf("%s: weight %d is %v, want %v", c.name, i, weight.Weight, c.want[i])
+			}
+		}
+	}
+}
+
+//A blend is the weighted average of the cities, and the latitude correction
+//gives a site closer to the equator more sunlight and a flatter optimal
+//angle.
+func TestBlendCities(t *testing.T) {
+	cityData := map[string]City{
+		"North": {lat: 40, lon: -100, solarRad: 4, optRad: 5, optAng: 35, avgEnergy: 800, temp: 50, instCost: 3, companies: []string{"North Solar"}},
+		"South": {lat: 30, lon: -100, solarRad: 5, optRad: 6, optAng: 28, avgEnergy: 1200, temp: 70, instCost: 4, companies: []string{"South Solar"}},
+	}
+	for name, city := range cityData {
+		EstimateMonthly(&city)
+		cityData[name] = city
+	}
+	weights := []StationWeight{{City: "North", Weight: 0.25}, {City: "South", Weight: 0.75}}
+	site := BlendCities(cityData, weights, 35, -100, false)
+	checks := []struct {
+		name      string
+		got, want float64
+	}{
+		{"solar_rad", site.solarRad, 4.75},
+		{"opt_rad", site.optRad, 5.75},
+		{"opt_angle", site.optAng, 29.75},
+		{"avg_energy", site.avgEnergy, 1100},
+		{"temp", site.temp, 65},
+		{"inst_cost", site.instCost, 3.75},
+	}
+	for _, c := range checks {
+		if math.Abs(c.got-c.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if len(site.companies) != 1 || site.companies[0] != "North Solar" {
+		t.Errorf("got companies %v, want the first city's", site.companies)
+	}
+
+	//the same city moved south gets more sunlight at the top of the atmosphere
+	alone := []StationWeight{{City: "North", Weight: 1}}
+	same := BlendCities(cityData, alone, 40, -100, true)
+	south := BlendCities(cityData, alone, 30, -100, true)
+	if math.Abs(same.solarRad-4) > 1e-9 || math.Abs(same.optAng-35) > 1e-9 {
+		t.Errorf("at the city's own latitude: solar_rad %v and opt_angle %v, want 4 and 35", same.solarRad, same.optAng)
+	}
+	if south.solarRad <= same.solarRad || south.optAng >= same.optAng {
+		t.Errorf("10° further south: solar_rad %v and opt_angle %v, want more than %v and less than %v", south.solarRad, south.optAng, same.solarRad, same.optAng)
+	}
+}
@@ -0,0 +1,423 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file holds the inverter catalog from inverters.csv and
+follows the panels' power through the inverter to the house. Every inverter
+has a CEC-style efficiency curve (its efficiency at six loads from 10% to
+100% of its rated AC power) and can't give more than its rated AC power.
+Systems usually have more panel watts than inverter watts (a DC/AC ratio
+above 1), so on the sunniest hours the extra power is clipped. It also
+recommends an inverter model and count for each panel brand.*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+//Kinds of inverter.
+const (
+	InverterString = "string" //one inverter for strings of panels wired in series
+	InverterMicro  = "micro"  //a small inverter behind every panel (or every few panels)
+	InverterHybrid = "hybrid" //a string inverter that can also charge and run from a battery
+)
+
+//The DC/AC ratio when a request doesn't give one: the panels' rated watts
+//over the inverter's rated AC watts.
+const DefaultDCACRatio = 1.2
+
+//The DC/AC ratios an inverter can be recommended for. Below the minimum most
+//of the inverter is never used, and above the maximum too much is clipped.
+const (
+	MinDCACRatio = 0.8
+	MaxDCACRatio = 1.5
+)
+
+//The loads an efficiency curve gives the efficiency at, as a share of the
+//rated AC power, and the weight of each in the CEC weighted efficiency.
+var (
+	InverterLoads = [6]float64{0.1, 0.2, 0.3, 0.5, 0.75, 1}
+	CECWeights    = [6]float64{0.04, 0.05, 0.12, 0.21, 0.53, 0.05}
+)
+
+//The columns of inverters.csv.
+var InverterSchema = Schema{
+	Name: "inverters",
+	Key:  "name",
+	Columns: append([]Column{
+		{Name: "name", Kind: KindText, Required: true},
+		{Name: "type", Unit: "string, micro or hybrid", Kind: KindText, Required: true},
+		{Name: "ac_watts", Unit: "W rated AC output", Kind: KindNumber, Min: 50, Max: 100000, Required: true},
+		{Name: "max_dc_volts", Unit: "V highest DC input", Kind: KindNumber, Min: 10, Max: 1500, Required: true},
+		{Name: "mppt_min_volts", Unit: "V lowest MPPT voltage", Kind: KindNumber, Min: 5, Max: 1500, Required: true},
+		{Name: "mppt_max_volts", Unit: "V highest MPPT voltage", Kind: KindNumber, Min: 5, Max: 1500, Required: true},
+		{Name: "mppt_count", Unit: "MPPT inputs", Kind: KindNumber, Min: 1, Max: 12, Required: true},
+		{Name: "max_input_amps", Unit: "A per MPPT input", Kind: KindNumber, Min: 1, Max: 100, Required: true},
+		{Name: "max_isc_amps", Unit: "A short-circuit per MPPT input (1.25 × max_input_amps if empty)", Kind: KindNumber, Min: 1, Max: 150},
+		{Name: "modules", Unit: "panels per inverter, micro only (1 if empty)", Kind: KindNumber, Min: 1, Max: 4},
+	}, append(CurveColumns(), Column{Name: "price", Unit: "$ per inverter", Kind: KindNumber, Min: 0, Max: 100000, Required: true})...),
+}
+
+//Makes one column per load of the efficiency curve, named like eff_10.
+func CurveColumns() []Column {
+	columns := make([]Column, len(InverterLoads))
+	for i, load := range InverterLoads {
+		columns[i] = Column{Name: CurveColumn(i), Unit: "% efficiency at " + strconv.Itoa(int(load*100)) + "% load", Kind: KindNumber, Min: 50, Max: 100, Required: true}
+	}
+	return columns
+}
+
+//Gives the name of the column for a load of the efficiency curve.
+func CurveColumn(i int) string {
+	return "eff_" + strconv.Itoa(int(InverterLoads[i]*100))
+}
+
+/*This is an inverter struct which stores one model from the inverter
+catalog: its type, rated AC watts, the DC voltages and currents its MPPT
+inputs take (in use and short-circuited), how many panels a micro inverter takes, its price and its
+efficiency curve.*/
+type Inverter struct {
+	kind         string
+	acWatts      float64
+	maxDCVolts   float64
+	mpptMinVolts float64
+	mpptMaxVolts float64
+	mpptCount    int
+	maxInputAmps float64 //per MPPT input
+	maxIscAmps   float64 //short-circuit current per MPPT input
+	modules      int     //panels per inverter, micro inverters only
+	curve        [6]float64
+	price        float64
+}
+
+//Make the map data structure of all of the inverter models.
+func MakeInverterMap(records []Record) map[string]Inverter {
+	inverters := make(map[string]Inverter)
+	for _, record := range records {
+		inverters[record.Key(InverterSchema)] = MakeInverter(record)
+	}
+	return inverters
+}
+
+//Make an Inverter object using Inverter struct.
+func MakeInverter(record Record) Inverter {
+	var inverter Inverter
+	inverter.kind = record.Text["type"]
+	inverter.acWatts = record.Numbers["ac_watts"]
+	inverter.maxDCVolts = record.Numbers["max_dc_volts"]
+	inverter.mpptMinVolts = record.Numbers["mppt_min_volts"]
+	inverter.mpptMaxVolts = record.Numbers["mppt_max_volts"]
+	inverter.mpptCount = int(record.Numbers["mppt_count"])
+	inverter.maxInputAmps = record.Numbers["max_input_amps"]
+	inverter.maxIscAmps = StringCurrentFactor * inverter.maxInputAmps
+	if maxIscAmps, ok := record.Numbers["max_isc_amps"]; ok {
+		inverter.maxIscAmps = maxIscAmps
+	}
+	inverter.modules = 1
+	if modules, ok := record.Numbers["modules"]; ok {
+		inverter.modules = int(modules)
+	}
+	for i := range inverter.curve {
+		inverter.curve[i] = record.Numbers[CurveColumn(i)]
+	}
+	inverter.price = record.Numbers["price"]
+	return inverter
+}
+
+//Checks what the schema can't: that every inverter has a known type, an
+//MPPT range that fits under its highest DC voltage and a short-circuit
+//current no lower than its input current.
+func CheckInverters(records []Record, filename string, diagnostics *Diagnostics) {
+	for _, record := range records {
+		switch record.Text["type"] {
+		case InverterString, InverterMicro, InverterHybrid:
+		default:
+			diagnostics.Add(filename, record.Line, "type", fmt.Sprintf("%q is not %s, %s or %s", record.Text["type"], InverterString, InverterMicro, InverterHybrid))
+		}
+		low, high := record.Numbers["mppt_min_volts"], record.Numbers["mppt_max_volts"]
+		if low >= high {
+			diagnostics.Add(filename, record.Line, "mppt_min_volts", "must be below mppt_max_volts")
+		}
+		if high > record.Numbers["max_dc_volts"] {
+			diagnostics.Add(filename, record.Line, "mppt_max_volts", "must not be above max_dc_volts")
+		}
+		if isc, ok := record.Numbers["max_isc_amps"]; ok && isc < record.Numbers["max_input_amps"] {
+			diagnostics.Add(filename, record.Line, "max_isc_amps", "must not be below max_input_amps")
+		}
+	}
+}
+
+//Gives the inverter's efficiency as a share at a load, a share of its rated
+//AC power, read off its efficiency curve. Loads below 10% and above 100%
+//get the efficiency at the ends of the curve.
+func (inverter Inverter) Efficiency(load float64) float64 {
+	curve := inverter.curve
+	if load <= InverterLoads[0] {
+		return curve[0] / 100
+	}
+	for i := 1; i < len(InverterLoads); i++ {
+		if load <= InverterLoads[i] {
+			t := (load - InverterLoads[i-1]) / (InverterLoads[i] - InverterLoads[i-1])
+			return (curve[i-1] + t*(curve[i]-curve[i-1])) / 100
+		}
+	}
+	return curve[len(curve)-1] / 100
+}
+
+//Gives the CEC weighted efficiency of the inverter in %.
+func (inverter Inverter) CECEfficiency() float64 {
+	var efficiency float64
+	for i, weight := range CECWeights {
+		efficiency += weight * inverter.curve[i]
+	}
+	return efficiency
+}
+
+/*This is a system struct which stores everything between the sunlight on the
+panels and the energy the house gets: the panels' efficiency and how they
+heat up, the loss chain, the inverter and the DC/AC ratio. With no Inverter
+the loss chain's inverter efficiency is used at every load.*/
+type System struct {
+	Efficiency   float64 //panel efficiency in %
+	Thermal      Thermal
+	Losses       Losses
+	InverterName string
+	Inverter     *Inverter
+	DCACRatio    float64
+}
+
+//Gives the system the quick estimate uses: 15% efficient panels with the
+//default thermal values, losses and DC/AC ratio.
+func DefaultSystem() System {
+	return System{Efficiency: 15, Thermal: DefaultThermal, DCACRatio: DefaultDCACRatio}
+}
+
+/*This is the power at each step between the sunlight and the house at one
+moment, for panels rated at 1 kW: the panels' rated output in the sunlight,
+what is left after heat, after the loss chain's DC losses, out of the
+inverter, and after clipping.*/
+type PowerFlow struct {
+	Sunlight  float64
+	AfterHeat float64
+	DC        float64
+	Inverted  float64
+	AC        float64
+	Cell      float64 //cell temperature in °C
+}
+
+//Follows sunlight on the panels in kW/m² through the system at an air
+//temperature in °C. The inverter's load is its DC input over its rated AC
+//power, and it clips anything above its rated AC power.
+func (system System) Flow(sunlight, ambient float64) PowerFlow {
+	flow := PowerFlow{Sunlight: sunlight}
+	flow.Cell = CellTemperature(ambient, sunlight*1000, system.Thermal.NOCT)
+	flow.AfterHeat = sunlight * system.Thermal.Derate(flow.Cell)
+	flow.DC = flow.AfterHeat * system.Losses.DCRatio()
+	efficiency := system.Losses.InverterRatio()
+	if system.Inverter != nil {
+		efficiency = system.Inverter.Efficiency(flow.DC * system.DCACRatio)
+	}
+	flow.Inverted = flow.DC * efficiency
+	flow.AC = math.Min(flow.Inverted, 1/system.DCACRatio)
+	return flow
+}
+
+/*This is the energy at each step of PowerFlow added up over a time, with the
+air and cell temperatures added up weighted by the sunlight.*/
+type FlowTotals struct {
+	Sunlight  float64
+	AfterHeat float64
+	DC        float64
+	Inverted  float64
+	AC        float64
+	ambient   float64
+	cell      float64
+}
+
+//Adds a flow lasting a number of hours for panels of a size in kW.
+func (totals *FlowTotals) Add(flow PowerFlow, ambient, hours, kw float64) {
+	energy := hours * kw
+	totals.Sunlight += flow.Sunlight * energy
+	totals.AfterHeat += flow.AfterHeat * energy
+	totals.DC += flow.DC * energy
+	totals.Inverted += flow.Inverted * energy
+	totals.AC += flow.AC * energy
+	totals.ambient += ambient * flow.Sunlight * energy
+	totals.cell += flow.Cell * flow.Sunlight * energy
+}
+
+//Adds up the totals of every month.
+func SumFlows(months [12]FlowTotals) FlowTotals {
+	var sum FlowTotals
+	for _, month := range months {
+		sum.Sunlight += month.Sunlight
+		sum.AfterHeat += month.AfterHeat
+		sum.DC += month.DC
+		sum.Inverted += month.Inverted
+		sum.AC += month.AC
+		sum.ambient += month.ambient
+		sum.cell += month.cell
+	}
+	return sum
+}
+
+//Scales the totals so the sunlight is a given energy, keeping every share.
+func (totals FlowTotals) ScaleTo(sunlight float64) FlowTotals {
+	if totals.Sunlight <= 0 {
+		return FlowTotals{}
+	}
+	factor := sunlight / totals.Sunlight
+	return FlowTotals{totals.Sunlight * factor, totals.AfterHeat * factor, totals.DC * factor,
+		totals.Inverted * factor, totals.AC * factor, totals.ambient * factor, totals.cell * factor}
+}
+
+//Gives the share of the panels' rated output that reaches the house.
+func (totals FlowTotals) Share() float64 {
+	if totals.Sunlight <= 0 {
+		return 0
+	}
+	return totals.AC / totals.Sunlight
+}
+
+//Gives the air and cell temperatures in °C, averaged weighted by the sunlight.
+func (totals FlowTotals) Temperatures() (float64, float64) {
+	if totals.Sunlight <= 0 {
+		return 0, 0
+	}
+	return totals.ambient / totals.Sunlight, totals.cell / totals.Sunlight
+}
+
+//Gives the energy flow through a 1 kW system facing a plane in a city on an
+//average day of each month. The month's sunlight is split into clear and
+//cloudy days with DailyClearness, and each day's sunlight on the plane is
+//spread through the day. The air is the city's mean temperature plus
+//DaytimeWarming.
+func MonthlyFlows(city City, plane Plane, system System) [12]FlowTotals {
+	var months [12]FlowTotals
+	ambient := FahrenheitToCelsius(city.temp) + DaytimeWarming
+	for m, day := range MidMonthDay {
+		top := ExtraterrestrialDaily(city.lat, day)
+		if top <= 0 {
+			continue
+		}
+		for _, clearness := range DailyClearness(city.monthlyRad[m] / top) {
+			steps, hours := PlaneDay(city.lat, day, clearness*top, plane)
+			for _, poa := range steps {
+				months[m].Add(system.Flow(poa, ambient), ambient, hours/ClearnessDays, 1)
+			}
+		}
+	}
+	return months
+}
+
+//Gives the share of a year's rated output that reaches the house, from each
+//month's radiation and energy flow.
+func YearlyFactor(radiation [12]float64, months [12]FlowTotals) float64 {
+	var before, after float64
+	for m := range radiation {
+		before += radiation[m] * float64(MonthDays[m])
+		after += radiation[m] * float64(MonthDays[m]) * months[m].Share()
+	}
+	if before <= 0 {
+		return 0
+	}
+	return after / before
+}
+
+/*This is the struct storing what the inverter did to the output: its
+efficiency averaged over the energy it turned into AC, and how much it clipped.*/
+type InverterLoss struct {
+	Inverter   string      `json:"inverter,omitempty"` //catalog model, empty for the loss chain's inverter efficiency
+	DCACRatio  float64     `json:"dc_ac_ratio"`
+	Efficiency float64     `json:"efficiency_percent"`
+	Clipping   float64     `json:"clipping_percent"`    //share of the inverter's output clipped
+	ClippedKwh float64     `json:"clipped_kwh_month"`   //energy clipped, kwh per month
+	Monthly    [12]float64 `json:"monthly_clipped_kwh"` //energy clipped in each month, kwh
+}
+
+//Makes the breakdown of the inverter's losses from each month's energy flow.
+func NewInverterLoss(system System, months [12]FlowTotals) InverterLoss {
+	loss := InverterLoss{Inverter: system.InverterName, DCACRatio: system.DCACRatio, Efficiency: system.Losses.InverterRatio() * 100}
+	for m, month := range months {
+		loss.Monthly[m] = math.Round((month.Inverted-month.AC)*100) / 100
+	}
+	year := SumFlows(months)
+	if year.DC > 0 {
+		loss.Efficiency = year.Inverted / year.DC * 100
+	}
+	if year.Inverted > 0 {
+		loss.Clipping = (year.Inverted - year.AC) / year.Inverted * 100
+	}
+	loss.Efficiency = math.Round(loss.Efficiency*100) / 100
+	loss.Clipping = math.Round(loss.Clipping*1000) / 1000
+	loss.ClippedKwh = math.Round((year.Inverted-year.AC)/12*100) / 100
+	return loss
+}
+
+/*This is the inverter recommended for one panel brand: the model, how many
+of them, the DC/AC ratio they give, what they cost and how the panels are
+wired to them.*/
+type InverterChoice struct {
+	Name      string        `json:"name"`
+	Type      string        `json:"type"`
+	Count     int           `json:"count"`
+	DCACRatio float64       `json:"dc_ac_ratio"`
+	Cost      int           `json:"cost"`
+	Strings   *StringLayout `json:"strings,omitempty"` //nil if the panel's voltages and currents aren't known
+}
+
+//Recommends the lowest cost inverters for a number of panels at a DC/AC
+//ratio. String and hybrid inverters are sized so the ratio is no more than
+//the one asked for, and micro inverters take their number of panels each.
+//Inverters that would give a ratio outside MinDCACRatio to MaxDCACRatio
+//aren't recommended. If the panel's voltages and currents are known, the
+//panels also have to be wired to the inverters without breaking a limit at
+//the design temperatures, with more string inverters if they need more
+//inputs. Ties go to the ratio closest to the one asked for. It gives an
+//empty choice if no inverter fits.
+func RecommendInverter(panel Panel, numPanels int, inverters map[string]Inverter, dcacRatio float64, temps DesignTemps) InverterChoice {
+	var best InverterChoice
+	if numPanels <= 0 {
+		return best
+	}
+	names := make([]string, 0, len(inverters))
+	for name := range inverters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dcWatts := panel.watts * float64(numPanels)
+	for _, name := range names {
+		inverter := inverters[name]
+		choice := InverterChoice{Name: name, Type: inverter.kind}
+		if inverter.kind == InverterMicro {
+			choice.Count = (numPanels + inverter.modules - 1) / inverter.modules
+			choice.DCACRatio = panel.watts * float64(inverter.modules) / inverter.acWatts
+		} else {
+			choice.Count = int(math.Max(1, math.Ceil(dcWatts/dcacRatio/inverter.acWatts)))
+			choice.DCACRatio = dcWatts / (float64(choice.Count) * inverter.acWatts)
+		}
+		if panel.HasElectrical() {
+			design := DesignStrings(panel, inverter, temps)
+			choice.Strings = design.Layout(numPanels, choice.Count)
+			for choice.Strings == nil && inverter.kind != InverterMicro && choice.DCACRatio >= MinDCACRatio {
+				choice.Count++
+				choice.DCACRatio = dcWatts / (float64(choice.Count) * inverter.acWatts)
+				choice.Strings = design.Layout(numPanels, choice.Count)
+			}
+			if choice.Strings == nil {
+				continue
+			}
+		}
+		if choice.DCACRatio < MinDCACRatio || choice.DCACRatio > MaxDCACRatio {
+			continue
+		}
+		choice.DCACRatio = math.Round(choice.DCACRatio*100) / 100
+		choice.Cost = int(inverter.price * float64(choice.Count))
+		if best.Name == "" || choice.Cost < best.Cost ||
+			(choice.Cost == best.Cost && math.Abs(choice.DCACRatio-dcacRatio) < math.Abs(best.DCACRatio-dcacRatio)) {
+			best = choice
+		}
+	}
+	return best
+}
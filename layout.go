@@ -0,0 +1,379 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file works out how many panels physically fit on a roof.
+A roof plane is drawn as a polygon (a rectangle is the usual case), with x
+along the eave and y up the slope from it. Fire codes keep panels back from
+the roof's edges and the ridge so firefighters have a path to walk and vent
+the roof, so the panels are packed in rows from the eave up, inside those
+setbacks, either standing up the slope (portrait) or lying along it
+(landscape). The layout gives the rectangle of every panel that fits and can
+be drawn as SVG.*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//Feet to meters, for roof dimensions typed in feet.
+const FeetToMeters = 0.3048
+
+//Fire-code setbacks of panels from the roof's edges and ridge, in meters:
+//a 36 inch path along the edges and 18 inches below the ridge (IRC R324.6).
+const (
+	DefaultEdgeSetback  = 0.9144
+	DefaultRidgeSetback = 0.4572
+)
+
+//The gap between panels for the clamps holding them down, in meters.
+const PanelGap = 0.02
+
+//The long side over the short side of a typical 60-cell panel (1.65 m by
+//0.99 m), used for panels in the catalog without their dimensions.
+const PanelAspect = 1.65 / 0.99
+
+//How far along a row, or up the roof, the packing moves to try again when a
+//panel doesn't fit, in meters.
+const LayoutStep = 0.05
+
+//Which way the panels are turned on the roof.
+const (
+	OrientationPortrait  = "portrait"  //long side up the slope
+	OrientationLandscape = "landscape" //long side along the eave
+	OrientationBest      = "best"      //whichever fits more panels
+)
+
+/*This is a point on a roof plane in meters, with x along the eave and y up
+the slope from the eave.*/
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+/*This is the struct storing the roof plane the panels are packed into and
+the fire-code setbacks they keep from its edges. The edges at the top of the
+polygon are the ridge.*/
+type RoofPlan struct {
+	Polygon      []Point `json:"polygon"`         //Corners of the roof plane in meters, in order
+	EdgeSetback  float64 `json:"edge_setback_m"`  //Distance the panels keep from the roof's edges
+	RidgeSetback float64 `json:"ridge_setback_m"` //Distance the panels keep from the ridge
+	Orientation  string  `json:"orientation"`     //portrait, landscape or best
+}
+
+/*This is the struct storing where one panel goes on the roof, in meters
+from the roof's lower left corner.*/
+type PanelPlacement struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`  //along the eave
+	Height float64 `json:"height"` //up the slope
+}
+
+/*This is the struct storing the panels that fit on a roof: every spot a
+panel fits, from the eave up, and how many of them are used.*/
+type RoofLayout struct {
+	Plane       string           `json:"plane,omitempty"` //Roof face the layout is on
+	Roof        RoofPlan         `json:"roof"`
+	Orientation string           `json:"orientation"`    //portrait or landscape, the one packed
+	PanelWidth  float64          `json:"panel_width_m"`  //Panel size along the eave
+	PanelHeight float64          `json:"panel_height_m"` //Panel size up the slope
+	Fits        int              `json:"fits"`           //Number of panels that fit
+	Used        int              `json:"used"`           //Number of the spots with a panel, the first ones
+	Placements  []PanelPlacement `json:"placements"`     //Every spot a panel fits
+}
+
+//Makes a rectangular roof plan from its width along the eave and its length
+//from the eave to the ridge, in meters.
+func RectanglePlan(width, length float64) RoofPlan {
+	return RoofPlan{
+		Polygon:      []Point{{0, 0}, {width, 0}, {width, length}, {0, length}},
+		EdgeSetback:  DefaultEdgeSetback,
+		RidgeSetback: DefaultRidgeSetback,
+		Orientation:  OrientationBest,
+	}
+}
+
+//Makes a square roof plan with an area in square feet, for a roof whose
+//dimensions weren't given.
+func SquarePlan(areaSqFt float64) RoofPlan {
+	side := math.Sqrt(areaSqFt * 0.092903) //square feet to square meters
+	return RectanglePlan(side, side)
+}
+
+//Reads a roof polygon typed as x,y pairs in feet, separated by spaces or
+//semicolons, such as "0,0 40,0 40,20 0,20".
+func ParsePolygon(text string) ([]Point, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == ';' || r == '\t' })
+	points := make([]Point, len(fields))
+	for i, field := range fields {
+		parts := strings.Split(field, ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q is not an x,y pair", field)
+		}
+		x, errX := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		y, errY := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if errX != nil || errY != nil || math.IsNaN(x+y) || math.IsInf(x+y, 0) {
+			return nil, fmt.Errorf("%q is not an x,y pair of numbers", field)
+		}
+		points[i] = Point{x, y}
+	}
+	return points, nil
+}
+
+//Checks that a polygon is a roof plane: at least 3 corners, edges that don't
+//cross and an area above zero.
+func CheckPolygon(polygon []Point) error {
+	if len(polygon) < 3 {
+		return errors.New("a roof needs at least 3 corners")
+	}
+	for i := range polygon {
+		a, b := polygon[i], polygon[(i+1)%len(polygon)]
+		for j := i + 2; j < len(polygon); j++ {
+			if i == 0 && j == len(polygon)-1 {
+				continue //the last edge meets the first at a corner
+			}
+			if SegmentsCross(a, b, polygon[j], polygon[(j+1)%len(polygon)]) {
+				return errors.New("the roof's edges cross each other")
+			}
+		}
+	}
+	if PolygonArea(polygon) <= 0 {
+		return errors.New("the roof has no area")
+	}
+	return nil
+}
+
+//Gives the area of a polygon, whichever way round its corners go.
+func PolygonArea(polygon []Point) float64 {
+	var area float64
+	for i, a := range polygon {
+		b := polygon[(i+1)%len(polygon)]
+		area += a.X*b.Y - b.X*a.Y
+	}
+	return math.Abs(area) / 2
+}
+
+//Gives the width and height of a panel on the roof: the short side along the
+//eave in portrait, the long side in landscape. Panels without dimensions in
+//the catalog are given the shape of a typical panel with their area.
+func (panel Panel) Footprint(orientation string) (float64, float64) {
+	long, short := panel.length, panel.width
+	if long <= 0 || short <= 0 {
+		short = math.Sqrt(panel.area / PanelAspect)
+		long = short * PanelAspect
+	}
+	if orientation == OrientationLandscape {
+		return long, short
+	}
+	return short, long
+}
+
+//Packs a panel onto the roof. With OrientationBest it packs both ways and
+//keeps the one that fits more, portrait if they tie. Every spot is used.
+func PackRoof(plan RoofPlan, panel Panel) RoofLayout {
+	if plan.Orientation != OrientationBest && plan.Orientation != "" {
+		width, height := panel.Footprint(plan.Orientation)
+		return plan.Pack(plan.Orientation, width, height)
+	}
+	width, height := panel.Footprint(OrientationPortrait)
+	portrait := plan.Pack(OrientationPortrait, width, height)
+	landscape := plan.Pack(OrientationLandscape, height, width)
+	if landscape.Fits > portrait.Fits {
+		return landscape
+	}
+	return portrait
+}
+
+//Packs panels of a width and height into rows from the eave up. Each row
+//puts a panel at the first spot along it that keeps the setbacks, then the
+//next one a gap after it; a row with no room moves up a step instead of a
+//panel's height.
+func (plan RoofPlan) Pack(orientation string, width, height float64) RoofLayout {
+	layout := RoofLayout{Roof: plan, Orientation: orientation, PanelWidth: round3(width), PanelHeight: round3(height), Placements: []PanelPlacement{}}
+	if len(plan.Polygon) < 3 || width <= 0 || height <= 0 {
+		return layout
+	}
+	minX, minY, maxX, maxY := plan.Bounds()
+	for y := minY + plan.EdgeSetback; y+height <= maxY+1e-9; {
+		placed := false
+		for x := minX + plan.EdgeSetback; x+width <= maxX+1e-9; {
+			spot := PanelPlacement{x, y, width, height}
+			if plan.Fits(spot) {
+				layout.Placements = append(layout.Placements, spot)
+				placed = true
+				x += width + PanelGap
+			} else {
+				x += LayoutStep
+			}
+		}
+		if placed {
+			y += height + PanelGap
+		} else {
+			y += LayoutStep
+		}
+	}
+	for i, spot := range layout.Placements {
+		layout.Placements[i] = PanelPlacement{round3(spot.X), round3(spot.Y), round3(spot.Width), round3(spot.Height)}
+	}
+	layout.Fits = len(layout.Placements)
+	layout.Used = layout.Fits
+	return layout
+}
+
+//Gives the smallest and largest x and y of the roof's corners.
+func (plan RoofPlan) Bounds() (float64, float64, float64, float64) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range plan.Polygon {
+		minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+	return minX, minY, maxX, maxY
+}
+
+//Tells whether a panel at a spot is on the roof and keeps the setbacks: the
+//ridge setback from the edges along the top of the roof and the edge
+//setback from the rest.
+func (plan RoofPlan) Fits(spot PanelPlacement) bool {
+	const eps = 1e-6
+	corners := spot.Corners()
+	center := Point{spot.X + spot.Width/2, spot.Y + spot.Height/2}
+	for _, corner := range corners {
+		//test just inside the corner so a panel flush with an edge counts as on the roof
+		inside := Point{corner.X + math.Copysign(eps, center.X-corner.X), corner.Y + math.Copysign(eps, center.Y-corner.Y)}
+		if !InPolygon(inside, plan.Polygon) {
+			return false
+		}
+	}
+	_, _, _, maxY := plan.Bounds()
+	for i, a := range plan.Polygon {
+		b := plan.Polygon[(i+1)%len(plan.Polygon)]
+		setback := plan.EdgeSetback
+		if math.Abs(a.Y-maxY) < eps && math.Abs(b.Y-maxY) < eps {
+			setback = plan.RidgeSetback
+		}
+		if RectDistance(a, b, corners) < setback-eps {
+			return false
+		}
+		//an edge may touch the panel's side but not cut through it
+		inner := PanelPlacement{spot.X + eps, spot.Y + eps, spot.Width - 2*eps, spot.Height - 2*eps}
+		if RectDistance(a, b, inner.Corners()) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//Gives the corners of a panel's rectangle, counterclockwise from the lower left.
+func (spot PanelPlacement) Corners() [4]Point {
+	return [4]Point{
+		{spot.X, spot.Y},
+		{spot.X + spot.Width, spot.Y},
+		{spot.X + spot.Width, spot.Y + spot.Height},
+		{spot.X, spot.Y + spot.Height},
+	}
+}
+
+//Tells whether a point is inside a polygon, by counting the edges a ray to
+//its right crosses.
+func InPolygon(p Point, polygon []Point) bool {
+	inside := false
+	for i, a := range polygon {
+		b := polygon[(i+1)%len(polygon)]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < a.X+(p.Y-a.Y)/(b.Y-a.Y)*(b.X-a.X) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+//Gives the distance from the segment a to b to a rectangle given by its
+//corners, 0 if they touch or the segment is inside it.
+func RectDistance(a, b Point, corners [4]Point) float64 {
+	minX, minY := corners[0].X, corners[0].Y
+	maxX, maxY := corners[2].X, corners[2].Y
+	if (a.X >= minX && a.X <= maxX && a.Y >= minY && a.Y <= maxY) || (b.X >= minX && b.X <= maxX && b.Y >= minY && b.Y <= maxY) {
+		return 0
+	}
+	distance := math.Inf(1)
+	for i, c := range corners {
+		d := corners[(i+1)%4]
+		if SegmentsCross(a, b, c, d) {
+			return 0
+		}
+		distance = math.Min(distance, SegmentDistance(c, a, b))
+	}
+	for _, p := range []Point{a, b} {
+		dx := math.Max(0, math.Max(minX-p.X, p.X-maxX))
+		dy := math.Max(0, math.Max(minY-p.Y, p.Y-maxY))
+		distance = math.Min(distance, math.Hypot(dx, dy))
+	}
+	return distance
+}
+
+//Gives the distance from a point to the segment a to b.
+func SegmentDistance(p, a, b Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/length))
+	}
+	return math.Hypot(p.X-a.X-t*dx, p.Y-a.Y-t*dy)
+}
+
+//Tells whether the segments a to b and c to d touch or cross.
+func SegmentsCross(a, b, c, d Point) bool {
+	side := func(p, q, r Point) float64 { return (q.X-p.X)*(r.Y-p.Y) - (q.Y-p.Y)*(r.X-p.X) }
+	between := func(p, q, r Point) bool {
+		return math.Min(p.X, q.X) <= r.X && r.X <= math.Max(p.X, q.X) && math.Min(p.Y, q.Y) <= r.Y && r.Y <= math.Max(p.Y, q.Y)
+	}
+	d1, d2 := side(c, d, a), side(c, d, b)
+	d3, d4 := side(a, b, c), side(a, b, d)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && between(c, d, a)) || (d2 == 0 && between(c, d, b)) ||
+		(d3 == 0 && between(a, b, c)) || (d4 == 0 && between(a, b, d))
+}
+
+//Rounds a length in meters to the millimeter.
+func round3(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
+
+//Draws the layout as an SVG image width pixels wide, with the ridge at the
+//top: the roof in gray, the panels used in blue and the spots left over
+//outlined.
+func (layout RoofLayout) SVG(width float64) string {
+	minX, minY, maxX, maxY := layout.Roof.Bounds()
+	if len(layout.Roof.Polygon) < 3 || maxX <= minX || maxY <= minY {
+		return ""
+	}
+	const margin = 5
+	scale := (width - 2*margin) / (maxX - minX)
+	height := math.Ceil((maxY-minY)*scale + 2*margin)
+	x := func(value float64) float64 { return math.Round((margin+(value-minX)*scale)*10) / 10 }
+	y := func(value float64) float64 { return math.Round((height-margin-(value-minY)*scale)*10) / 10 }
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g">`, width, height, width, height)
+	fmt.Fprintf(&svg, `<title>%d of %d panels that fit, %s</title>`, layout.Used, layout.Fits, layout.Orientation)
+	points := make([]string, len(layout.Roof.Polygon))
+	for i, p := range layout.Roof.Polygon {
+		points[i] = fmt.Sprintf("%g,%g", x(p.X), y(p.Y))
+	}
+	fmt.Fprintf(&svg, `<polygon points="%s" fill="lightgray" stroke="dimgray"/>`, strings.Join(points, " "))
+	for i, spot := range layout.Placements {
+		style := `fill="steelblue" stroke="white"`
+		if i >= layout.Used {
+			style = `fill="none" stroke="steelblue" stroke-dasharray="3 2"`
+		}
+		fmt.Fprintf(&svg, `<rect x="%g" y="%g" width="%g" height="%g" %s/>`,
+			x(spot.X), y(spot.Y+spot.Height), math.Round(spot.Width*scale*10)/10, math.Round(spot.Height*scale*10)/10, style)
+	}
+	svg.WriteString(`</svg>`)
+	return svg.String()
+}
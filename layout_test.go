@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+//Panel counts worked by hand for a 1 m by 1.65 m panel, 2 cm apart, with the
+//default setbacks: 0.9144 m from the eave and sides and 0.4572 m from the
+//ridge.
+func TestPackRoofCounts(t *testing.T) {
+	panel := Panel{length: 1.65, width: 1, area: 1.65}
+	cases := []struct {
+		name        string
+		plan        RoofPlan
+		orientation string
+		fits        int
+	}{
+		//8.17 m across fits 8 panels in portrait or 4 in landscape, 6.63 m up fits 3 or 6 rows
+		{"10 by 8, a tie", RectanglePlan(10, 8), OrientationPortrait, 24},
+		//10.17 m across fits 9 in portrait or 6 in landscape
+		{"12 by 8", RectanglePlan(12, 8), OrientationLandscape, 36},
+		{"roof smaller than the setbacks", RectanglePlan(2, 2), OrientationPortrait, 0},
+		{"no polygon", RoofPlan{}, OrientationPortrait, 0},
+	}
+	for _, c := range cases {
+		layout := PackRoof(c.plan, panel)
+		if layout.Fits != c.fits || len(layout.Placements) != c.fits || layout.Used != c.fits {
+			t.Errorf("%s: %d fit, %d placed and %d used, want %d", c.name, layout.Fits, len(layout.Placements), layout.Used, c.fits)
+		}
+		if c.fits > 0 && layout.Orientation != c.orientation {
+			t.Errorf("%s: packed %s, want %s", c.name, layout.Orientation, c.orientation)
+		}
+	}
+
+	//turned one way on purpose
+	plan := RectanglePlan(12, 8)
+	plan.Orientation = OrientationPortrait
+	if layout := PackRoof(plan, panel); layout.Fits != 27 || layout.Orientation != OrientationPortrait {
+		t.Errorf("12 by 8 in portrait: %d fit %s, want 27 portrait", layout.Fits, layout.Orientation)
+	}
+
+	//without setbacks, 1 m squares 2 cm apart fit 9 to a row of 10 m
+	square := RoofPlan{Polygon: []Point{{0, 0}, {10, 0}, {10, 10}, {0, 10}}, Orientation: OrientationPortrait}
+	if layout := PackRoof(square, Panel{length: 1, width: 1, area: 1}); layout.Fits != 81 {
+		t.Errorf("10 by 10 without setbacks: %d fit, want 81", layout.Fits)
+	}
+}
+
+//Every panel placed on a roof that isn't a rectangle is on it, keeps the
+//setbacks and doesn't overlap another.
+func TestPackRoofPlacements(t *testing.T) {
+	plan := RoofPlan{
+		Polygon:      []Point{{0, 0}, {12, 0}, {9, 6}, {3, 6}}, //a hip roof's trapezoid face
+		EdgeSetback:  DefaultEdgeSetback,
+		RidgeSetback: DefaultRidgeSetback,
+		Orientation:  OrientationBest,
+	}
+	layout := PackRoof(plan, Panel{length: 1.65, width: 1, area: 1.65})
+	if layout.Fits == 0 {
+		t.Fatal("nothing fits on the trapezoid")
+	}
+	for i, a := range layout.Placements {
+		//placements are rounded to the millimeter
+		inset := PanelPlacement{a.X + 0.001, a.Y + 0.001, a.Width - 0.002, a.Height - 0.002}
+		if !plan.Fits(inset) {
+			t.Errorf("panel %d at %v, %v is off the roof or in a setback", i, a.X, a.Y)
+		}
+		for _, b := range layout.Placements[i+1:] {
+			if a.X < b.X+b.Width-1e-6 && b.X < a.X+a.Width-1e-6 && a.Y < b.Y+b.Height-1e-6 && b.Y < a.Y+a.Height-1e-6 {
+				t.Errorf("panels at %v, %v and %v, %v overlap", a.X, a.Y, b.X, b.Y)
+			}
+		}
+	}
+}
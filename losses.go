@@ -0,0 +1,191 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file holds the chain of losses between the sunlight the
+panels turn into electricity and the energy the house gets: dirt, shade,
+snow, wiring, the inverter and so on. Each loss has a default that can be
+changed for a request, and the losses multiplied together give the
+performance ratio. The results show them as a waterfall, starting from the
+output with no losses and taking off heat, each loss in turn and then what
+the inverter clipped.*/
+
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+/*This is a loss struct which stores one step of the loss chain: its key in
+requests, its label, and its default percent and the range it can be set
+to. For the inverter the percent is an efficiency, and the loss is 100
+minus it.*/
+type Loss struct {
+	Key        string
+	Label      string
+	Default    float64
+	Min        float64
+	Max        float64
+	Efficiency bool
+}
+
+//The loss chain, in the order the losses are taken off. The defaults are
+//the ones NREL's PVWatts uses.
+var LossChain = []Loss{
+	{"soiling", "Soiling", 2, 0, 50, false},                 //dirt, dust and pollen on the panels
+	{"shading", "Shading", 3, 0, 80, false},                 //trees, chimneys and buildings
+	{"snow", "Snow", 0, 0, 50, false},                       //snow covering the panels
+	{"mismatch", "Mismatch", 2, 0, 20, false},               //panels in a string not being exactly alike
+	{"wiring", "Wiring", 2, 0, 20, false},                   //resistance in the DC and AC wires
+	{"connections", "Connections", 0.5, 0, 10, false},       //resistance in the connectors
+	{"lid", "Light-induced degradation", 1.5, 0, 10, false}, //new panels losing power in their first months
+	{"nameplate", "Nameplate rating", 1, 0, 10, false},      //panels making less than their rated watts
+	{"availability", "Availability", 3, 0, 50, false},       //time the system is switched off
+	{"inverter_efficiency", "Inverter", 96, 50, 100, true},  //share of the DC power the inverter turns into AC
+}
+
+/*This is the percent set for each loss in a request, by key. Losses that
+aren't set use their default.*/
+type Losses map[string]float64
+
+//Gives the percent lost at a step of the loss chain.
+func (losses Losses) Percent(loss Loss) float64 {
+	value, ok := losses[loss.Key]
+	if !ok {
+		value = loss.Default
+	}
+	if loss.Efficiency {
+		return 100 - value
+	}
+	return value
+}
+
+//Gives the share of the output left after every loss in the chain (the
+//performance ratio, without heat).
+func (losses Losses) Ratio() float64 {
+	return losses.DCRatio() * losses.InverterRatio()
+}
+
+//Gives the share of the output left after the losses before the inverter.
+func (losses Losses) DCRatio() float64 {
+	ratio := 1.0
+	for _, loss := range LossChain {
+		if !loss.Efficiency {
+			ratio *= 1 - losses.Percent(loss)/100
+		}
+	}
+	return ratio
+}
+
+//Gives the inverter efficiency of the loss chain as a share. A catalog
+//inverter's efficiency curve is used instead when one is chosen.
+func (losses Losses) InverterRatio() float64 {
+	ratio := 1.0
+	for _, loss := range LossChain {
+		if loss.Efficiency {
+			ratio *= 1 - losses.Percent(loss)/100
+		}
+	}
+	return ratio
+}
+
+//Gives the name of a loss for messages, such as "soiling loss" or "inverter efficiency".
+func (loss Loss) Name() string {
+	if loss.Efficiency {
+		return strings.ToLower(loss.Label) + " efficiency"
+	}
+	return strings.ToLower(loss.Label) + " loss"
+}
+
+//Finds a loss in the chain by its key.
+func FindLoss(key string) (Loss, bool) {
+	for _, loss := range LossChain {
+		if loss.Key == key {
+			return loss, true
+		}
+	}
+	return Loss{}, false
+}
+
+/*This is one step of the loss waterfall: how much of the output it took and
+how much was left after it.*/
+type LossStep struct {
+	Key     string  `json:"key"`
+	Label   string  `json:"label"`
+	Percent float64 `json:"loss_percent"`     //share of the output left before this step that it took
+	LostKwh float64 `json:"lost_kwh_month"`   //energy it took, kwh per month
+	LeftKwh float64 `json:"output_kwh_month"` //output left after it, kwh per month
+}
+
+/*This is the loss waterfall: the output with no losses, and each loss taken
+off in turn until the expected output is left.*/
+type LossWaterfall struct {
+	Start            float64    `json:"start_kwh_month"`   //sunlight on the panels times their efficiency, kwh per month
+	Steps            []LossStep `json:"steps"`             //heat, the loss chain, then clipping
+	PerformanceRatio float64    `json:"performance_ratio"` //share left after the loss chain and clipping, without heat
+	Output           float64    `json:"output_kwh_month"`  //output left after every step
+}
+
+//Makes the loss waterfall for an output after heat, losses and the
+//inverter, working back from it to the output with no losses. The inverter
+//step uses the inverter's average efficiency, from its efficiency curve if
+//it is a catalog model.
+func MakeWaterfall(output float64, heat HeatLoss, inverter InverterLoss, losses Losses) LossWaterfall {
+	ratio := losses.DCRatio() * inverter.Efficiency / 100 * (1 - inverter.Clipping/100)
+	waterfall := LossWaterfall{PerformanceRatio: math.Round(ratio*10000) / 10000, Output: output}
+	if heat.Factor <= 0 || ratio <= 0 {
+		return waterfall
+	}
+	left := output / heat.Factor / ratio
+	waterfall.Start = math.Round(left*100) / 100
+	steps := []LossStep{{Key: "heat", Label: "Heat", Percent: (1 - heat.Factor) * 100}}
+	for _, loss := range LossChain {
+		step := LossStep{Key: loss.Key, Label: loss.Label, Percent: losses.Percent(loss)}
+		if loss.Efficiency {
+			step.Percent = 100 - inverter.Efficiency
+			if inverter.Inverter != "" {
+				step.Label += " (" + inverter.Inverter + ")"
+			}
+		}
+		steps = append(steps, step)
+	}
+	steps = append(steps, LossStep{Key: "clipping", Label: "Clipping", Percent: inverter.Clipping})
+	for i := range steps {
+		lost := left * steps[i].Percent / 100
+		left -= lost
+		steps[i].Percent = math.Round(steps[i].Percent*100) / 100
+		steps[i].LostKwh = math.Round(lost*100) / 100
+		steps[i].LeftKwh = math.Round(left*100) / 100
+	}
+	waterfall.Steps = steps
+	return waterfall
+}
+
+/*This is one bar of the waterfall chart on the results page, in pixels
+from the top left of the chart: what is left after the step, and the part
+the step took.*/
+type WaterfallBar struct {
+	Label     string
+	Y         float64
+	LeftWidth float64
+	LostX     float64
+	LostWidth float64
+}
+
+//Lays out the waterfall chart: one row per step, with the output left after
+//it and the part it took, scaled so the output with no losses is width pixels.
+func WaterfallChart(waterfall LossWaterfall, width float64) []WaterfallBar {
+	if waterfall.Start <= 0 {
+		return nil
+	}
+	bars := make([]WaterfallBar, len(waterfall.Steps))
+	for i, step := range waterfall.Steps {
+		bar := WaterfallBar{Label: step.Label, Y: 5 + 18*float64(i)}
+		bar.LeftWidth = math.Round(step.LeftKwh / waterfall.Start * width)
+		bar.LostX = bar.LeftWidth
+		bar.LostWidth = math.Round(math.Abs(step.LostKwh) / waterfall.Start * width)
+		if step.LostKwh < 0 { //a gain, such as from cold panels, is drawn inside what is left
+			bar.LostX -= bar.LostWidth
+		}
+		bars[i] = bar
+	}
+	return bars
+}
@@ -0,0 +1,338 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file gives each city a radiation value and an energy usage
+value for every month, so the results can show which months solar covers
+and which it doesn't. The values come from monthly.csv. Cities that aren't in
+monthly.csv get a shape worked out from how much sunlight reaches the top of
+the atmosphere each month, scaled to their yearly averages in energy.csv.*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+//Short names of the months, and the number of days in each.
+var (
+	MonthNames = [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	MonthDays  = [12]int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+)
+
+//The day of the year whose sunlight is closest to each month's average (Klein, 1977).
+var MidMonthDay = [12]int{17, 47, 75, 105, 135, 162, 198, 228, 258, 288, 318, 344}
+
+//Where a city's monthly values came from.
+const (
+	MonthlyMeasured  = "measured"  //from monthly.csv
+	MonthlyEstimated = "estimated" //shaped from the yearly average
+)
+
+//The columns of monthly.csv: the city, then one column per month for the
+//radiation on a flat surface, the radiation at opt_angle and the energy usage.
+var MonthlySchema = Schema{
+	Name: "monthly",
+	Key:  "city",
+	Columns: append(append(append([]Column{{Name: "city", Kind: KindText, Required: true}},
+		MonthColumns("ghi", "kWh/m²/day on a flat surface", 12, true)...),
+		MonthColumns("tilt", "kWh/m²/day at opt_angle", 12, true)...),
+		MonthColumns("use", "kWh/month for a 2600 sq ft house", 10000, false)...),
+}
+
+//Makes one column per month, named like ghi_jan.
+func MonthColumns(prefix, unit string, max float64, required bool) []Column {
+	columns := make([]Column, 12)
+	for m, name := range MonthNames {
+		columns[m] = Column{Name: prefix + "_" + strings.ToLower(name), Unit: unit, Kind: KindNumber, Min: 0, Max: max, Required: required}
+	}
+	return columns
+}
+
+/*This is a month struct which stores one row of the month-by-month table.*/
+type MonthRow struct {
+	Month      string  `json:"month"`
+	Output     float64 `json:"output_kwh"`           //Expected solar energy output
+	OptOutput  float64 `json:"optimal_output_kwh"`   //Solar energy output at the optimal angle
+	Radiation  float64 `json:"radiation_kwh_m2_day"` //Average daily radiation on the roof (a flat surface unless its pitch was given)
+	Usage      float64 `json:"usage_kwh"`            //Energy usage for the house
+	Percentage int     `json:"percentage_covered"`   //Percentage of the usage covered by the output
+	HeatLoss   float64 `json:"heat_loss_kwh"`        //Energy lost to heat before the loss chain, included in Output
+}
+
+/*This is one month of the chart on the results page: the output bar and the
+usage bar next to it, in pixels from the top left of the chart.*/
+type ChartBar struct {
+	Month        string
+	Width        float64
+	X            float64
+	OutputY      float64
+	OutputHeight float64
+	UsageX       float64
+	UsageY       float64
+	UsageHeight  float64
+}
+
+//Reads monthly.csv. The file is optional, so a file that doesn't exist gives
+//no records and no error.
+func ReadMonthly(filename string) ([]Record, Diagnostics, error) {
+	if filename == "" {
+		return nil, nil, nil
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	return ReadTable(filename, MonthlySchema)
+}
+
+//Gives every city its monthly values, from the monthly records if it has one
+//and estimated otherwise. Records for cities that aren't in the city data are
+//reported in the diagnostics.
+func ApplyMonthly(cityData map[string]City, records []Record, filename string, diagnostics *Diagnostics) {
+	measured := make(map[string]Record)
+	for _, record := range records {
+		name := record.Key(MonthlySchema)
+		if _, ok := cityData[name]; !ok {
+			diagnostics.Add(filename, record.Line, "city", fmt.Sprintf("%q is not in the city data", name))
+			continue
+		}
+		measured[name] = record
+	}
+	for name, city := range cityData {
+		EstimateMonthly(&city)
+		if record, ok := measured[name]; ok {
+			city.monthlySource = MonthlyMeasured
+			for m, month := range MonthNames {
+				month = strings.ToLower(month)
+				city.monthlyRad[m] = record.Numbers["ghi_"+month]
+				city.monthlyOptRad[m] = record.Numbers["tilt_"+month]
+				if use, ok := record.Numbers["use_"+month]; ok {
+					city.monthlyUse[m] = use
+				}
+			}
+		}
+		cityData[name] = city
+	}
+}
+
+//Lists the cities that have no row in monthly.csv, whose monthly values are
+//estimated from their yearly averages. These are warnings, not problems.
+func MissingMonthly(cityRecords, monthlyRecords []Record, citiesFile, monthlyFile string) Diagnostics {
+	measured := make(map[string]bool)
+	for _, record := range monthlyRecords {
+		measured[record.Key(MonthlySchema)] = true
+	}
+	var missing Diagnostics
+	for _, record := range cityRecords {
+		if name := record.Key(CitySchema); !measured[name] {
+			missing.Add(citiesFile, record.Line, "city", fmt.Sprintf("%q has no row in %s, its monthly values are estimated", name, monthlyFile))
+		}
+	}
+	return missing
+}
+
+//NREL's PVWatts API, which gives the monthly radiation on a surface from the
+//NSRDB's typical year for a place.
+const PVWattsURL = "https://developer.nrel.gov/api/pvwatts/v8.json"
+
+//Gets the average daily radiation of each month, in kWh/m²/day, on a surface
+//at a tilt facing the equator, from PVWatts. A tilt of 0 gives the radiation
+//on a flat surface.
+func FetchMonthlyRadiation(client *http.Client, key string, lat, lon, tilt float64) ([12]float64, error) {
+	var radiation [12]float64
+	query := url.Values{
+		"api_key":         {key},
+		"lat":             {fmt.Sprint(lat)},
+		"lon":             {fmt.Sprint(lon)},
+		"tilt":            {fmt.Sprint(tilt)},
+		"azimuth":         {fmt.Sprint(EquatorAzimuth(lat))},
+		"system_capacity": {"1"},
+		"module_type":     {"0"},
+		"array_type":      {"0"},
+		"losses":          {"14"},
+		"dataset":         {"nsrdb"},
+	}
+	resp, err := client.Get(PVWattsURL + "?" + query.Encode())
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err //the URL has the API key in it
+		}
+		return radiation, fmt.Errorf("PVWatts: %v", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Errors  []string `json:"errors"`
+		Outputs struct {
+			SolradMonthly []float64 `json:"solrad_monthly"`
+		} `json:"outputs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return radiation, fmt.Errorf("PVWatts answered %s: %v", resp.Status, err)
+	}
+	if len(result.Errors) > 0 {
+		return radiation, fmt.Errorf("PVWatts: %s", strings.Join(result.Errors, "; "))
+	}
+	if len(result.Outputs.SolradMonthly) != 12 {
+		return radiation, fmt.Errorf("PVWatts gave %d months of radiation", len(result.Outputs.SolradMonthly))
+	}
+	for m, value := range result.Outputs.SolradMonthly {
+		radiation[m] = math.Round(value*100) / 100
+	}
+	return radiation, nil
+}
+
+//Gives the average daily radiation of each month, in kWh/m²/day, on a surface
+//at a tilt facing the equator, from the sunlight in a weather file. A tilt of
+//0 gives the radiation on a flat surface.
+func WeatherMonthlyRadiation(weather *WeatherFile, tilt float64) ([12]float64, error) {
+	plane := Plane{Tilt: tilt, Azimuth: EquatorAzimuth(weather.Station.Latitude), Model: DefaultTransposition}
+	sim, err := Simulate(weather, plane, DefaultSystem(), 1)
+	var radiation [12]float64
+	for m, value := range sim.MonthlyPOA {
+		radiation[m] = math.Round(value*100) / 100
+	}
+	return radiation, err
+}
+
+//Fills in a city's monthly values from its yearly averages. Radiation follows
+//the sunlight reaching the top of the atmosphere, on a flat surface for
+//solarRad and on a surface tilted to optAng for optRad, and usage is the same
+//every day of the year.
+func EstimateMonthly(city *City) {
+	var flat, tilted [12]float64
+	for m, day := range MidMonthDay {
+		flat[m] = ExtraterrestrialDaily(city.lat, day)
+		tilted[m] = flat[m] * TiltFactor(city.lat, city.optAng, day)
+	}
+	city.monthlyRad = ScaleMonths(flat, city.solarRad)
+	city.monthlyOptRad = ScaleMonths(tilted, city.optRad)
+	for m := range city.monthlyUse {
+		city.monthlyUse[m] = city.avgEnergy * float64(MonthDays[m]) * 12 / 365
+	}
+	city.monthlySource = MonthlyEstimated
+}
+
+//Scales monthly values so that their average over the days of the year is mean.
+func ScaleMonths(values [12]float64, mean float64) [12]float64 {
+	if average := MonthAverage(values); average > 0 {
+		for m := range values {
+			values[m] *= mean / average
+		}
+	}
+	return values
+}
+
+//Gives the average of monthly values over the days of the year.
+func MonthAverage(values [12]float64) float64 {
+	var total float64
+	for m, value := range values {
+		total += value * float64(MonthDays[m])
+	}
+	return total / 365
+}
+
+//Gives how much more sunlight reaches the top of the atmosphere on a surface
+//tilted towards the equator than on a flat one, on a day of the year (Liu and Jordan).
+func TiltFactor(latitude, tilt float64, day int) float64 {
+	lat := math.Abs(latitude) * math.Pi / 180
+	beta := tilt * math.Pi / 180
+	decl := Declination(day)
+	if latitude < 0 {
+		decl = -decl //the seasons are the other way around
+	}
+	sunset := math.Acos(math.Max(-1, math.Min(1, -math.Tan(lat)*math.Tan(decl))))
+	tiltSunset := math.Min(sunset, math.Acos(math.Max(-1, math.Min(1, -math.Tan(lat-beta)*math.Tan(decl)))))
+	flat := math.Cos(lat)*math.Cos(decl)*math.Sin(sunset) + sunset*math.Sin(lat)*math.Sin(decl)
+	tilted := math.Cos(lat-beta)*math.Cos(decl)*math.Sin(tiltSunset) + tiltSunset*math.Sin(lat-beta)*math.Sin(decl)
+	if flat <= 0 || tilted <= 0 {
+		return 0
+	}
+	return tilted / flat
+}
+
+//Gives the expected solar energy output for each month from each month's
+//radiation, in kwh. Each month is PanelEnergy with that month's radiation,
+//adjusted for the number of days, so over a year they add up to 12 times the
+//output for the yearly average radiation.
+func MonthlyOutput(radiation [12]float64, efficiency, houseSize float64) [12]float64 {
+	var output [12]float64
+	for m := range output {
+		output[m] = PanelEnergy(radiation[m], efficiency, houseSize) * float64(MonthDays[m]) * 12 / 365
+	}
+	return output
+}
+
+//Gives the energy usage of the house for each month, in kwh.
+func MonthlyUsage(cityName string, cityData map[string]City, houseSize float64) [12]float64 {
+	var usage [12]float64
+	for m, use := range cityData[cityName].monthlyUse {
+		usage[m] = use / 2600 * houseSize
+	}
+	return usage
+}
+
+//Makes the month-by-month table of output and usage, with the output of
+//panels lying on the roof and at the optimal angle after the system's heat
+//loss, loss chain and inverter. It also gives the breakdown of the heat loss
+//and the inverter's losses on the roof.
+func MonthlyTable(cityName string, cityData map[string]City, roof Plane, system System, roofSize, houseSize float64) ([]MonthRow, HeatLoss, InverterLoss) {
+	city := cityData[cityName]
+	radiation := PlaneRadiation(city, roof)
+	before := MonthlyOutput(radiation, system.Efficiency, roofSize)
+	flows := MonthlyFlows(city, roof, system)
+	optimal := Plane{Tilt: city.optAng, Azimuth: EquatorAzimuth(city.lat), Model: roof.Model}
+	optFlows := MonthlyFlows(city, optimal, system)
+	var output [12]float64
+	optOutput := MonthlyOutput(city.monthlyOptRad, system.Efficiency, roofSize)
+	for m := range output {
+		flows[m] = flows[m].ScaleTo(before[m])
+		output[m] = flows[m].AC
+		optOutput[m] *= optFlows[m].Share()
+	}
+	heat := NewHeatLoss(system.Thermal, flows)
+	usage := MonthlyUsage(cityName, cityData, houseSize)
+	rows := make([]MonthRow, 12)
+	for m := range rows {
+		rows[m] = MonthRow{
+			Month:     MonthNames[m],
+			Output:    float64(int(output[m]*100)) / 100,
+			OptOutput: float64(int(optOutput[m]*100)) / 100,
+			Usage:     float64(int(usage[m]*100)) / 100,
+			Radiation: math.Round(radiation[m]*100) / 100,
+			HeatLoss:  heat.Monthly[m],
+		}
+		if usage[m] > 0 {
+			rows[m].Percentage = int(output[m] / usage[m] * 100)
+		}
+	}
+	return rows, heat, NewInverterLoss(system, flows)
+}
+
+//Lays out the monthly chart: one pair of bars per month, output and usage,
+//scaled so the tallest bar is height pixels. The bars stand on y = height.
+func MonthlyChart(rows []MonthRow, width, height float64) []ChartBar {
+	if len(rows) == 0 {
+		return nil
+	}
+	var max float64
+	for _, row := range rows {
+		max = math.Max(max, math.Max(row.Output, row.Usage))
+	}
+	slot := width / float64(len(rows))
+	bars := make([]ChartBar, len(rows))
+	for i, row := range rows {
+		bar := ChartBar{Month: row.Month, Width: math.Floor(slot * 0.4), X: 10 + slot*float64(i)}
+		bar.UsageX = bar.X + bar.Width
+		if max > 0 {
+			bar.OutputHeight = math.Round(row.Output / max * height)
+			bar.UsageHeight = math.Round(row.Usage / max * height)
+		}
+		bar.OutputY = 10 + height - bar.OutputHeight
+		bar.UsageY = 10 + height - bar.UsageHeight
+		bars[i] = bar
+	}
+	return bars
+}
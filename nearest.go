@@ -0,0 +1,197 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file finds the cities nearest to the user using
+great-circle (haversine) distance. The cities are kept in a k-d tree so a
+lookup doesn't have to check every city.*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+//Mean radius of the earth, and the number of kilometers in a mile.
+const (
+	EarthRadiusKm = 6371.0088
+	KmPerMile     = 1.609344
+)
+
+/*This is a neighbor struct which stores one city found near the user and how
+far away it is.*/
+type Neighbor struct {
+	City          string  `json:"city"`
+	DistanceKm    float64 `json:"distance_km"`
+	DistanceMiles float64 `json:"distance_miles"`
+}
+
+/*This is one node of the k-d tree: a city as a point on the unit sphere, and
+the nodes on each side of it.*/
+type kdNode struct {
+	city        string
+	point       [3]float64
+	axis        int
+	left, right int //index of the child nodes, -1 if none
+}
+
+/*This is the spatial index of the cities. The cities are stored as 3D points
+on the unit sphere, so the straight-line distance between two points always
+orders cities the same way as the great-circle distance.*/
+type CityIndex struct {
+	nodes []kdNode
+	root  int
+}
+
+//Converts signed coordinates to a point on the unit sphere.
+func UnitVector(latitude, longitude float64) [3]float64 {
+	lat := latitude * math.Pi / 180
+	lon := longitude * math.Pi / 180
+	return [3]float64{math.Cos(lat) * math.Cos(lon), math.Cos(lat) * math.Sin(lon), math.Sin(lat)}
+}
+
+//Gives the great-circle distance in km between two coordinates, using the haversine formula.
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	lat1 *= math.Pi / 180
+	lat2 *= math.Pi / 180
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+//Builds the k-d tree of all the cities.
+func NewCityIndex(cityData map[string]City) *CityIndex {
+	names := make([]string, 0, len(cityData))
+	for name := range cityData {
+		names = append(names, name)
+	}
+	sort.Strings(names) //so the tree is the same every time
+	nodes := make([]kdNode, len(names))
+	for i, name := range names {
+		nodes[i] = kdNode{city: name, point: UnitVector(cityData[name].lat, cityData[name].lon), left: -1, right: -1}
+	}
+	index := &CityIndex{nodes: nodes}
+	order := make([]int, len(nodes))
+	for i := range order {
+		order[i] = i
+	}
+	index.root = index.build(order, 0)
+	return index
+}
+
+//Builds the part of the tree holding the given nodes and returns its root.
+func (index *CityIndex) build(order []int, depth int) int {
+	if len(order) == 0 {
+		return -1
+	}
+	axis := depth % 3
+	sort.SliceStable(order, func(i, j int) bool {
+		return index.nodes[order[i]].point[axis] < index.nodes[order[j]].point[axis]
+	})
+	mid := len(order) / 2
+	root := order[mid]
+	index.nodes[root].axis = axis
+	index.nodes[root].left = index.build(append([]int(nil), order[:mid]...), depth+1)
+	index.nodes[root].right = index.build(append([]int(nil), order[mid+1:]...), depth+1)
+	return root
+}
+
+/*This is a candidate city found during a search, with its squared straight-line distance.*/
+type candidate struct {
+	node   int
+	distSq float64
+}
+
+//Finds the k cities closest to the coordinates, nearest first.
+func (index *CityIndex) Nearest(lat, lon float64, k int) []Neighbor {
+	if k <= 0 || index.root < 0 {
+		return []Neighbor{}
+	}
+	target := UnitVector(lat, lon)
+	best := make([]candidate, 0, k+1)
+	index.search(index.root, target, k, &best)
+	neighbors := make([]Neighbor, len(best))
+	for i, found := range best {
+		// chord length between unit vectors to great-circle distance
+		km := 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(found.distSq)/2))
+		neighbors[i] = Neighbor{index.nodes[found.node].city, km, km / KmPerMile}
+	}
+	return neighbors
+}
+
+//Searches the tree below node, keeping the k best candidates sorted nearest first.
+func (index *CityIndex) search(node int, target [3]float64, k int, best *[]candidate) {
+	if node < 0 {
+		return
+	}
+	n := index.nodes[node]
+	var distSq float64
+	for i := 0; i < 3; i++ {
+		distSq += (n.point[i] - target[i]) * (n.point[i] - target[i])
+	}
+	if len(*best) < k || distSq < (*best)[len(*best)-1].distSq {
+		pos := sort.Search(len(*best), func(i int) bool { return (*best)[i].distSq > distSq })
+		*best = append(*best, candidate{})
+		copy((*best)[pos+1:], (*best)[pos:])
+		(*best)[pos] = candidate{node, distSq}
+		if len(*best) > k {
+			*best = (*best)[:k]
+		}
+	}
+	diff := target[n.axis] - n.point[n.axis]
+	near, far := n.left, n.right
+	if diff > 0 {
+		near, far = n.right, n.left
+	}
+	index.search(near, target, k, best)
+	if len(*best) < k || diff*diff < (*best)[len(*best)-1].distSq {
+		index.search(far, target, k, best)
+	}
+}
+
+/*This function gets the distance in km past which a location is outside the
+area the data covers, from the COVERAGE_KM environment variable. Locations
+further than this from every city are rejected. It is 500 km if not set.*/
+func getCoverageKm() float64 {
+	p := os.Getenv("COVERAGE_KM")
+	if p == "" {
+		return 500
+	}
+	limit, err := strconv.ParseFloat(p, 64)
+	if err != nil || limit <= 0 {
+		log.Print("invalid COVERAGE_KM, using 500: ", p)
+		return 500
+	}
+	return limit
+}
+
+//Records an error if the location is too far from every city for the data to apply.
+func CheckCoverage(dataset *Dataset, lat, lon float64, field string, errs *ValidationErrors) {
+	nearest := dataset.Index.Nearest(lat, lon, 1)
+	if limit := getCoverageKm(); len(nearest) == 0 || nearest[0].DistanceKm > limit {
+		message := fmt.Sprintf("%s is outside the area covered by the data (more than %.0f km from any city).", FormatLocation(lat, lon), limit)
+		if mirrored := dataset.Index.Nearest(lat, -lon, 1); lon > 0 && len(mirrored) > 0 && mirrored[0].DistanceKm <= limit {
+			message += " Longitudes west of Greenwich are negative, or add W."
+		}
+		errs.Add(field, CodeOutOfCoverage, message)
+	}
+}
+
+/*This function gets the distance in km past which the nearest city is too
+far away to stand in for the user's location, from the MAX_STATION_KM
+environment variable. It is 150 km if not set.*/
+func getMaxStationKm() float64 {
+	p := os.Getenv("MAX_STATION_KM")
+	if p == "" {
+		return 150
+	}
+	limit, err := strconv.ParseFloat(p, 64)
+	if err != nil || limit <= 0 {
+		log.Print("invalid MAX_STATION_KM, using 150: ", p)
+		return 150
+	}
+	return limit
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+//The k-d tree finds the same cities, at the same distances, as checking
+//every city, including across the date line and near the poles.
+func TestNearestMatchesBruteForce(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	cityData := make(map[string]City)
+	for i := 0; i < 300; i++ {
+		lat := math.Asin(2*random.Float64()-1) * 180 / math.Pi //even over the sphere
+		lon := random.Float64()*360 - 180
+		if i%3 == 0 { //a cluster around the date line
+			lat, lon = random.Float64()*10-5, 175+random.Float64()*10
+			if lon > 180 {
+				lon -= 360
+			}
+		}
+		cityData[fmt.Sprintf("city%d", i)] = City{lat: lat, lon: lon}
+	}
+	index := NewCityIndex(cityData)
+
+	for q := 0; q < 200; q++ {
+		lat, lon := random.Float64()*180-90, random.Float64()*360-180
+		if q%4 == 0 {
+			lat, lon = random.Float64()*4-2, 178+random.Float64()*4
+			if lon > 180 {
+				lon -= 360
+			}
+		}
+		want := make([]Neighbor, 0, len(cityData))
+		for name, city := range cityData {
+			want = append(want, Neighbor{City: name, DistanceKm: Haversine(lat, lon, city.lat, city.lon)})
+		}
+		sort.Slice(want, func(i, j int) bool { return want[i].DistanceKm < want[j].DistanceKm })
+		for _, k := range []int{1, 5} {
+			got := index.Nearest(lat, lon, k)
+			if len(got) != k {
+				t.Fatalf("%v, %v: %d neighbors, want %d", lat, lon, len(got), k)
+			}
+			for i := range got {
+				if got[i].City != want[i].City || math.Abs(got[i].DistanceKm-want[i].DistanceKm) > 1e-6 {
+					t.Errorf("%v, %v: neighbor %d of %d is %s at %.6f km, want %s at %.6f km", lat, lon, i+1, k, got[i].City, got[i].DistanceKm, want[i].City, want[i].DistanceKm)
+				}
+			}
+		}
+	}
+}
+
+func TestNearestEmptyAndLargeK(t *testing.T) {
+	if got := NewCityIndex(map[string]City{}).Nearest(40, -100, 3); len(got) != 0 {
+		t.Errorf("an empty index found %v", got)
+	}
+	cityData := map[string]City{"A": {lat: 40, lon: -100}, "B": {lat: 41, lon: -100}}
+	got := NewCityIndex(cityData).Nearest(40.1, -100, 5)
+	if len(got) != 2 || got[0].City != "A" || got[1].City != "B" {
+		t.Errorf("got %v, want A then B", got)
+	}
+}
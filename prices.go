@@ -0,0 +1,66 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file reads the panel price sheet, prices.csv. Prices change
+much more often than the panels themselves, so they are kept out of
+solar.csv and can be updated on their own. Each price is per panel or per
+watt and says when it was last updated. Only panels with a price are offered
+on the results page.*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+//The columns of prices.csv.
+var PriceSchema = Schema{
+	Name: "prices",
+	Key:  "name",
+	Columns: []Column{
+		{Name: "name", Unit: "a panel in solar.csv", Kind: KindText, Required: true},
+		{Name: "price", Unit: "$ per panel", Kind: KindNumber, Min: 0, Max: 10000},
+		{Name: "price_per_watt", Unit: "$/W at STC, if price is empty", Kind: KindNumber, Min: 0, Max: 20},
+		{Name: "updated", Unit: "date, YYYY-MM-DD", Kind: KindText, Required: true},
+		{Name: "source", Kind: KindText},
+	},
+}
+
+//Reads the price sheet. A price sheet that doesn't exist has no prices.
+func ReadPrices(filename string) ([]Record, Diagnostics, error) {
+	if filename == "" {
+		return nil, nil, nil
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	return ReadTable(filename, PriceSchema)
+}
+
+//Gives the panels their prices from the price sheet. Prices for panels that
+//aren't in the panel data, prices with neither a price nor a price per watt
+//and dates that can't be read are reported in the diagnostics.
+func ApplyPrices(solarPanels map[string]Panel, records []Record, filename string, diagnostics *Diagnostics) {
+	for _, record := range records {
+		name := record.Key(PriceSchema)
+		panel, ok := solarPanels[name]
+		if !ok {
+			diagnostics.Add(filename, record.Line, "name", fmt.Sprintf("%q is not in the panel data", name))
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", record.Text["updated"]); err != nil {
+			diagnostics.Add(filename, record.Line, "updated", fmt.Sprintf("%q is not a date like 2017-12-01", record.Text["updated"]))
+			continue
+		}
+		if price, ok := record.Numbers["price"]; ok {
+			panel.price = price
+		} else if perWatt, ok := record.Numbers["price_per_watt"]; ok {
+			panel.price = perWatt * panel.watts
+		} else {
+			diagnostics.Add(filename, record.Line, "", "price or price_per_watt is needed")
+			continue
+		}
+		panel.priced, panel.priceDate = true, record.Text["updated"]
+		solarPanels[name] = panel
+	}
+}
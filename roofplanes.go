@@ -0,0 +1,365 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file handles roofs with more than one plane. Most houses
+have two to six roof faces, each with its own size, pitch, direction and
+shade. The output of each face is worked out on its own and added up, and
+the panels of each brand go on the faces that make the most energy for each
+square meter first, filling a face before moving on to the next best one.*/
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+)
+
+//The most planes a roof can be given as.
+const MaxRoofFaces = 8
+
+//The number of planes the form on the solar energy page has rows for.
+const FormRoofFaces = 4
+
+//Gives the start of the field names of the nth row of planes on the form.
+func FaceFormPrefix(n int) string {
+	return fmt.Sprintf("plane%d_", n)
+}
+
+/*This is the struct storing one plane (face) of a roof: its size, which way
+it faces, how much of its sunlight is shaded and the shape the panels are
+packed into.*/
+type RoofFace struct {
+	Name    string   `json:"name"`
+	Area    float64  `json:"area_sq_ft"`                //Area of the face in square feet
+	Plane   Plane    `json:"plane"`                     //Which way the face points
+	Shading *float64 `json:"shading_percent,omitempty"` //Sunlight lost to shade on this face, instead of the loss chain's shading
+	Layout  RoofPlan `json:"layout"`                    //Shape and setbacks the panels are packed into
+	Shade   *Shade   `json:"shade,omitempty"`           //Horizon and obstructions, which set Shading if it isn't given
+}
+
+/*This is the struct storing the output of one roof face in the estimate.*/
+type FaceResult struct {
+	Name     string  `json:"name"`
+	AreaSqFt float64 `json:"area_sq_ft"`
+	Plane    Plane   `json:"plane"`
+	Shading  float64 `json:"shading_percent"`  //Sunlight lost to shade
+	Output   float64 `json:"output_kwh_month"` //Output of the whole face
+	Yield    float64 `json:"kwh_m2_month"`     //Output for each square meter, which ranks the faces
+	Rank     int     `json:"rank"`             //1 for the face panels go on first
+
+	Shade *ShadeReport `json:"shade,omitempty"` //Shading worked out from the horizon and obstructions
+}
+
+//Gives the planes of the roof in a request, or the whole roof as one plane
+//facing roof if no planes were given. Planes without their own shade get the
+//roof's.
+func (req EstimateRequest) RoofFaces(roof Plane) []RoofFace {
+	if len(req.Faces) == 0 {
+		return []RoofFace{{Name: "Roof", Area: req.RoofSize, Plane: roof, Layout: req.Layout, Shade: req.Shade}}
+	}
+	faces := make([]RoofFace, len(req.Faces))
+	copy(faces, req.Faces)
+	for i := range faces {
+		faces[i].Plane.Model = roof.Model
+		if faces[i].Shade == nil {
+			faces[i].Shade = req.Shade
+		}
+	}
+	return faces
+}
+
+//Gives the system on a face: the system with the face's shading in place of
+//the loss chain's shading, if the face has its own.
+func (face RoofFace) System(system System) System {
+	if face.Shading == nil {
+		return system
+	}
+	losses := Losses{}
+	for key, value := range system.Losses {
+		losses[key] = value
+	}
+	losses["shading"] = *face.Shading
+	system.Losses = losses
+	return system
+}
+
+//Checks one roof plane and makes it. Its fields are reported with prefix,
+//such as plane1_ or roof_planes[0]. A plane without an area gets the area of
+//its shape, and a plane without a shape is packed as a square of its area.
+func MakeRoofFace(prefix, name string, area, width, length float64, polygon []Point, plane *Plane, shading *float64, lat float64, errs *ValidationErrors) RoofFace {
+	face := RoofFace{Name: name, Area: area, Plane: Plane{Azimuth: EquatorAzimuth(lat)}, Shading: shading}
+	if plane != nil {
+		face.Plane = *plane
+	}
+	face.Layout.Polygon = RoofPolygon(prefix, area, width, length, polygon, errs)
+	if area == 0 && width == 0 && length == 0 && len(polygon) == 0 {
+		errs.Add(prefix+"area", CodeRequired, "Please enter the area of "+name+" or its shape.")
+	} else if area == 0 {
+		face.Area = math.Round(PolygonArea(face.Layout.Polygon)/0.092903*10) / 10 //square meters to square feet
+	}
+	return face
+}
+
+//Gives every face the setbacks and panel orientation of the roof.
+func UseSetbacks(faces []RoofFace, plan RoofPlan) {
+	for i := range faces {
+		faces[i].Layout.EdgeSetback, faces[i].Layout.RidgeSetback = plan.EdgeSetback, plan.RidgeSetback
+		faces[i].Layout.Orientation = plan.Orientation
+	}
+}
+
+//Adds up the areas of the faces, in square feet.
+func FacesArea(faces []RoofFace) float64 {
+	var area float64
+	for _, face := range faces {
+		area += face.Area
+	}
+	return area
+}
+
+//Makes the results of the faces from the output of each, and ranks them by
+//their output for each square meter, best first. Ties keep their order.
+func RankFaces(faces []RoofFace, outputs []float64, losses Losses) []FaceResult {
+	shading, _ := FindLoss("shading")
+	results := make([]FaceResult, len(faces))
+	for i, face := range faces {
+		results[i] = FaceResult{Name: face.Name, AreaSqFt: face.Area, Plane: face.Plane, Output: outputs[i]}
+		results[i].Shading = losses.Percent(shading)
+		if face.Shading != nil {
+			results[i].Shading = *face.Shading
+		}
+		if face.Area > 0 {
+			results[i].Yield = math.Round(outputs[i]/(face.Area*0.092903)*1000) / 1000
+		}
+	}
+	for rank, i := range FaceOrder(results) {
+		results[i].Rank = rank + 1
+	}
+	return results
+}
+
+//Gives the indexes of the faces from the best to the worst.
+func FaceOrder(results []FaceResult) []int {
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return results[order[a]].Yield > results[order[b]].Yield })
+	return order
+}
+
+//Puts a brand's panels on the roof faces, the best face first, until they
+//make the house's yearly usage (in kwh) or the roof is full. It gives the
+//layout of each face with the panels used, the number of panels on the roof
+//and the number needed, which puts the ones that didn't fit on the worst face.
+func AllocatePanels(faces []RoofFace, results []FaceResult, panel Panel, usage float64) ([]RoofLayout, int, int) {
+	layouts := make([]RoofLayout, len(faces))
+	for i, face := range faces {
+		layouts[i] = PackRoof(face.Layout, panel)
+		layouts[i].Plane, layouts[i].Used = face.Name, 0
+	}
+	remaining := usage
+	numPanels, needed := 0, 0
+	order := FaceOrder(results)
+	for k, i := range order {
+		oneSolarPanelOutput := PanelOutput(faces[i], results[i], panel)
+		if oneSolarPanelOutput <= 0 || remaining <= 0 {
+			continue
+		}
+		want := remaining / oneSolarPanelOutput
+		last := k == len(order)-1
+		if last {
+			needed += int(want) //the panels that didn't fit go on the worst face
+		}
+		if want <= float64(layouts[i].Fits) {
+			layouts[i].Used = int(want)
+			numPanels += int(want)
+			if !last {
+				needed += int(want)
+			}
+			break
+		}
+		layouts[i].Used = layouts[i].Fits
+		numPanels += layouts[i].Fits
+		if !last {
+			needed += layouts[i].Fits
+		}
+		remaining -= float64(layouts[i].Fits) * oneSolarPanelOutput
+	}
+	return layouts, numPanels, needed
+}
+
+//Gives the electricity one panel makes on a face in a year, the face's yearly
+//output for each kw of panels at the default efficiency times the panel's
+//rated power.
+func PanelOutput(face RoofFace, result FaceResult, panel Panel) float64 {
+	areaM2 := face.Area * 0.092903 //convert square feet to square meters
+	kw := areaM2 * DefaultSystem().Efficiency / 100
+	return result.Output * 12 / kw * panel.watts / 1000
+}
+
+//Adds up the monthly tables of the faces. The radiation is the average over
+//the faces' areas.
+func CombineMonthly(tables [][]MonthRow, faces []RoofFace) []MonthRow {
+	if len(tables) == 1 {
+		return tables[0]
+	}
+	rows := make([]MonthRow, 12)
+	total := FacesArea(faces)
+	for m := range rows {
+		rows[m] = MonthRow{Month: tables[0][m].Month, Usage: tables[0][m].Usage}
+		for i, table := range tables {
+			rows[m].Output += table[m].Output
+			rows[m].OptOutput += table[m].OptOutput
+			rows[m].HeatLoss += table[m].HeatLoss
+			if total > 0 {
+				rows[m].Radiation += table[m].Radiation * faces[i].Area / total
+			}
+		}
+		rows[m].Output = math.Round(rows[m].Output*100) / 100
+		rows[m].OptOutput = math.Round(rows[m].OptOutput*100) / 100
+		rows[m].HeatLoss = math.Round(rows[m].HeatLoss*100) / 100
+		rows[m].Radiation = math.Round(rows[m].Radiation*100) / 100
+		if rows[m].Usage > 0 {
+			rows[m].Percentage = int(rows[m].Output / rows[m].Usage * 100)
+		}
+	}
+	return rows
+}
+
+//Adds up the heat lost on the faces. The share left after heat and the
+//temperatures are averaged over what each face makes.
+func CombineHeat(heats []HeatLoss, outputs []float64) HeatLoss {
+	if len(heats) == 1 {
+		return heats[0]
+	}
+	heat := HeatLoss{Thermal: heats[0].Thermal, Factor: 1}
+	var output, sunlight float64
+	for i, face := range heats {
+		output += outputs[i]
+		if face.Factor > 0 {
+			sunlight += outputs[i] / face.Factor
+		}
+		heat.AmbientC += face.AmbientC * outputs[i]
+		heat.CellC += face.CellC * outputs[i]
+		heat.LostKwh += face.LostKwh
+		for m := range heat.Monthly {
+			heat.Monthly[m] += face.Monthly[m]
+		}
+	}
+	if output > 0 {
+		heat.AmbientC, heat.CellC = math.Round(heat.AmbientC/output*10)/10, math.Round(heat.CellC/output*10)/10
+	}
+	if sunlight > 0 {
+		heat.Factor = math.Round(output/sunlight*10000) / 10000
+	}
+	heat.LostKwh = math.Round(heat.LostKwh*100) / 100
+	for m := range heat.Monthly {
+		heat.Monthly[m] = math.Round(heat.Monthly[m]*100) / 100
+	}
+	return heat
+}
+
+//Adds up what the inverter clipped on the faces. The efficiency is averaged
+//over what each face makes.
+func CombineInverter(losses []InverterLoss, outputs []float64) InverterLoss {
+	if len(losses) == 1 {
+		return losses[0]
+	}
+	loss := InverterLoss{Inverter: losses[0].Inverter, DCACRatio: losses[0].DCACRatio}
+	var output, inverted float64
+	for i, face := range losses {
+		output += outputs[i]
+		inverted += outputs[i] + face.ClippedKwh
+		loss.Efficiency += face.Efficiency * outputs[i]
+		loss.ClippedKwh += face.ClippedKwh
+		for m := range loss.Monthly {
+			loss.Monthly[m] += face.Monthly[m]
+		}
+	}
+	if output > 0 {
+		loss.Efficiency = math.Round(loss.Efficiency/output*100) / 100
+	}
+	if inverted > 0 {
+		loss.Clipping = math.Round(loss.ClippedKwh/inverted*100*1000) / 1000
+	}
+	loss.ClippedKwh = math.Round(loss.ClippedKwh*100) / 100
+	for m := range loss.Monthly {
+		loss.Monthly[m] = math.Round(loss.Monthly[m]*100) / 100
+	}
+	return loss
+}
+
+//Adds up the hourly simulations of the faces. The sunlight on the panels is
+//the average over the faces' areas, and the plane is the first face's.
+func CombineSimulations(sims []Simulation) Simulation {
+	if len(sims) == 1 {
+		return sims[0]
+	}
+	sim := Simulation{Station: sims[0].Station, DistanceKm: sims[0].DistanceKm, Plane: sims[0].Plane}
+	heats := make([]HeatLoss, len(sims))
+	inverters := make([]InverterLoss, len(sims))
+	outputs := make([]float64, len(sims))
+	for i, face := range sims {
+		sim.AreaM2 += face.AreaM2
+		sim.Annual += face.Annual
+		for m := range sim.Monthly {
+			sim.Monthly[m] += face.Monthly[m]
+			sim.MonthlyPOA[m] += face.MonthlyPOA[m] * face.AreaM2
+		}
+		heats[i], inverters[i], outputs[i] = face.Heat, face.Inverter, face.Annual/12
+		if len(face.Hourly) == HoursPerYear {
+			if sim.Hourly == nil {
+				sim.Hourly = make([]float64, HoursPerYear)
+			}
+			for h, kwh := range face.Hourly {
+				sim.Hourly[h] += kwh
+			}
+		}
+	}
+	for m := range sim.MonthlyPOA {
+		if sim.AreaM2 > 0 {
+			sim.MonthlyPOA[m] /= sim.AreaM2
+		}
+	}
+	sim.Heat, sim.Inverter = CombineHeat(heats, outputs), CombineInverter(inverters, outputs)
+	return sim
+}
+
+//Gives the losses of the loss waterfall for the whole roof: the loss chain
+//with the faces' shading averaged over what each face makes.
+func FaceLosses(losses Losses, results []FaceResult) Losses {
+	if len(results) == 1 {
+		return losses
+	}
+	var output, shading float64
+	for _, face := range results {
+		output += face.Output
+		shading += face.Shading * face.Output
+	}
+	if output <= 0 {
+		return losses
+	}
+	combined := Losses{}
+	for key, value := range losses {
+		combined[key] = value
+	}
+	combined["shading"] = math.Round(shading/output*100) / 100
+	return combined
+}
+
+//Draws the layout of every face with panels that fit, each under its name.
+func FacesSVG(layouts []RoofLayout, width float64) string {
+	if len(layouts) == 1 {
+		return layouts[0].SVG(width)
+	}
+	var drawings strings.Builder
+	for _, layout := range layouts {
+		if layout.Fits == 0 {
+			continue
+		}
+		fmt.Fprintf(&drawings, "<p>%s: %d of the %d panels that fit</p>%s", html.EscapeString(layout.Plane), layout.Used, layout.Fits, layout.SVG(width))
+	}
+	return drawings.String()
+}
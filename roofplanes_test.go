@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+//A 300 W panel of 2 m², 15% efficient like the estimate's own panels.
+var testPanel = Panel{efficiency: 15, watts: 300, area: 2, length: 1.6, width: 1.25, price: 250, priced: true}
+
+//Makes a square roof face of an area in square feet, making a yearly kwh for
+//each square meter.
+func testFace(t *testing.T, name string, area, yearlyPerM2 float64) (RoofFace, FaceResult) {
+	t.Helper()
+	var errs ValidationErrors
+	plan := MakeRoofPlan(area, 0, 0, nil, 0, 0, OrientationBest, &errs)
+	if len(errs) > 0 {
+		t.Fatalf("making the roof plan: %v", errs)
+	}
+	face := RoofFace{Name: name, Area: area, Layout: plan}
+	output := yearlyPerM2 * area * 0.092903 / 12
+	return face, FaceResult{Name: name, AreaSqFt: area, Output: output, Yield: output / (area * 0.092903)}
+}
+
+func TestAllocatePanels(t *testing.T) {
+	face, result := testFace(t, "Roof", 3000, 150) //300 kwh a year for each panel
+	fits := PackRoof(face.Layout, testPanel).Fits
+	if fits < 40 {
+		t.Fatalf("only %d panels fit on the test roof", fits)
+	}
+	cases := []struct {
+		name         string
+		usage        float64
+		used, needed int
+	}{
+		{"fits", 9000, 30, 30},
+		{"part of a panel", 9100, 30, 30},
+		{"roof full", 300 * float64(fits+10), fits, fits + 10},
+		{"no usage", 0, 0, 0},
+	}
+	for _, c := range cases {
+		layouts, used, needed := AllocatePanels([]RoofFace{face}, []FaceResult{result}, testPanel, c.usage)
+		if used != c.used || needed != c.needed {
+			t.Errorf("%s: %d panels used and %d needed, want %d and %d", c.name, used, needed, c.used, c.needed)
+		}
+		if layouts[0].Used != used {
+			t.Errorf("%s: the layout uses %d panels, want %d", c.name, layouts[0].Used, used)
+		}
+	}
+}
+
+func TestAllocatePanelsBestFaceFirst(t *testing.T) {
+	south, southResult := testFace(t, "South", 400, 200)
+	north, northResult := testFace(t, "North", 3000, 100)
+	southFits := PackRoof(south.Layout, testPanel).Fits
+	usage := float64(southFits)*400 + 10*200 //the south face full, then 10 panels on the north one
+	layouts, used, needed := AllocatePanels([]RoofFace{north, south}, []FaceResult{northResult, southResult}, testPanel, usage)
+	if layouts[1].Used != southFits || layouts[0].Used != 10 {
+		t.Errorf("%d panels on the south face and %d on the north, want %d and 10", layouts[1].Used, layouts[0].Used, southFits)
+	}
+	if used != southFits+10 || needed != used {
+		t.Errorf("%d panels used and %d needed, want %d", used, needed, southFits+10)
+	}
+}
+
+//The panels make the usage of the house, which grows with its size.
+func TestFitPanelsHouseSize(t *testing.T) {
+	cityData := map[string]City{"Test": {avgEnergy: 650, instCost: 3}}
+	face, result := testFace(t, "Roof", 5000, 150)
+	panels := map[string]Panel{"Test": testPanel}
+	var counts []int
+	for _, houseSize := range []float64{1300, 2600} {
+		numPanels, needed, costs, _ := FitPanels([]RoofFace{face}, []FaceResult{result}, houseSize, cityData, "Test", panels)
+		yearly := 650 / 2600.0 * houseSize * 12
+		if want := int(yearly / 300); numPanels["Test"] != want || needed["Test"] != want {
+			t.Errorf("house of %v sq ft: %d panels and %d needed, want %d", houseSize, numPanels["Test"], needed["Test"], want)
+		}
+		if want := float64(numPanels["Test"])*testPanel.price + 3*5000; math.Abs(float64(costs["Test"])-want) > 1 {
+			t.Errorf("house of %v sq ft: cost %d, want %.0f", houseSize, costs["Test"], want)
+		}
+		counts = append(counts, numPanels["Test"])
+	}
+	if counts[1] != 2*counts[0] {
+		t.Errorf("twice the house size takes %d panels instead of %d", counts[1], counts[0])
+	}
+}
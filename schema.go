@@ -0,0 +1,338 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file declares the columns of energy.csv and solar.csv and
+reads them with encoding/csv. Every bad value is reported with its line and
+column instead of being read as zero. Tables can be written back out in the
+same format.*/
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//Kinds of values a column can hold.
+const (
+	KindText   = "text"   //any text
+	KindNumber = "number" //a number between Min and Max
+	KindList   = "list"   //text items separated by semicolons
+)
+
+/*This is a column struct which describes one column of a data file: its
+header name, the unit of its values, what kind of value it holds and the
+range a number has to be in.*/
+type Column struct {
+	Name     string  `json:"name"`
+	Unit     string  `json:"unit,omitempty"`
+	Kind     string  `json:"kind"`
+	Min      float64 `json:"min,omitempty"`
+	Max      float64 `json:"max,omitempty"`
+	Required bool    `json:"required"`
+}
+
+/*This is a schema struct which describes a whole data file. Key is the
+column that names each row and has to be unique.*/
+type Schema struct {
+	Name    string   `json:"name"`
+	Key     string   `json:"key"`
+	Columns []Column `json:"columns"`
+}
+
+//The columns of energy.csv.
+var CitySchema = Schema{
+	Name: "cities",
+	Key:  "city",
+	Columns: []Column{
+		{Name: "city", Kind: KindText, Required: true},
+		{Name: "lat", Unit: "degrees, north positive", Kind: KindNumber, Min: -90, Max: 90, Required: true},
+		{Name: "lon", Unit: "degrees, east positive", Kind: KindNumber, Min: -180, Max: 180, Required: true},
+		{Name: "temp", Unit: "°F annual mean", Kind: KindNumber, Min: -60, Max: 130, Required: true},
+		{Name: "record_low", Unit: "°F lowest on record (estimated from temp if empty)", Kind: KindNumber, Min: -80, Max: 80},
+		{Name: "solar_rad", Unit: "kWh/m²/day on a flat surface", Kind: KindNumber, Min: 0, Max: 12, Required: true},
+		{Name: "opt_angle", Unit: "degrees from horizontal", Kind: KindNumber, Min: 0, Max: 90, Required: true},
+		{Name: "opt_rad", Unit: "kWh/m²/day at opt_angle", Kind: KindNumber, Min: 0, Max: 12, Required: true},
+		{Name: "avg_energy", Unit: "kWh/month for a 2600 sq ft house", Kind: KindNumber, Min: 1, Max: 10000, Required: true},
+		{Name: "inst_cost", Unit: "$/W installed", Kind: KindNumber, Min: 0, Max: 20, Required: true},
+		{Name: "companies", Kind: KindList},
+		{Name: "export_policy", Unit: "a policy in export.csv (DefaultExportPolicy if empty)", Kind: KindText},
+		{Name: "state", Unit: "two letters, for the incentives in incentives.csv", Kind: KindText},
+	},
+}
+
+//The columns of solar.csv. Prices are in the price sheet, prices.csv.
+var PanelSchema = Schema{
+	Name: "panels",
+	Key:  "name",
+	Columns: []Column{
+		{Name: "name", Kind: KindText, Required: true},
+		{Name: "manufacturer", Kind: KindText},
+		{Name: "model", Kind: KindText},
+		{Name: "technology", Unit: "such as Mono-c-Si, Multi-c-Si or CdTe", Kind: KindText},
+		{Name: "efficiency", Unit: "%", Kind: KindNumber, Min: 1, Max: 50, Required: true},
+		{Name: "watts", Unit: "W at STC", Kind: KindNumber, Min: 1, Max: 1000, Required: true},
+		{Name: "ptc_watts", Unit: "W at PTC", Kind: KindNumber, Min: 1, Max: 1000},
+		{Name: "area", Unit: "m²", Kind: KindNumber, Min: 0.1, Max: 5, Required: true},
+		{Name: "length", Unit: "m, long side", Kind: KindNumber, Min: 0.1, Max: 5},
+		{Name: "width", Unit: "m, short side", Kind: KindNumber, Min: 0.1, Max: 5},
+		{Name: "temp_coeff", Unit: "%/°C of power (DefaultTempCoeff if empty)", Kind: KindNumber, Min: -2, Max: 0},
+		{Name: "noct", Unit: "°C nominal operating cell temperature (DefaultNOCT if empty)", Kind: KindNumber, Min: 20, Max: 80},
+		{Name: "voc", Unit: "V open-circuit at STC", Kind: KindNumber, Min: 1, Max: 200},
+		{Name: "vmp", Unit: "V at maximum power at STC", Kind: KindNumber, Min: 1, Max: 200},
+		{Name: "isc", Unit: "A short-circuit at STC", Kind: KindNumber, Min: 0.1, Max: 30},
+		{Name: "imp", Unit: "A at maximum power at STC", Kind: KindNumber, Min: 0.1, Max: 30},
+		{Name: "voc_coeff", Unit: "%/°C of Voc", Kind: KindNumber, Min: -1, Max: 0},
+		{Name: "isc_coeff", Unit: "%/°C of Isc", Kind: KindNumber, Min: 0, Max: 1},
+		{Name: "bifaciality", Unit: "rear side's power over the front's, 0 for one-sided panels", Kind: KindNumber, Min: 0, Max: 1},
+		{Name: "source", Unit: "where the row came from, such as cec", Kind: KindText},
+		{Name: "version", Unit: "import that last changed the row", Kind: KindText},
+	},
+}
+
+/*This is a diagnostic struct which records one problem found in a data file.
+Line is 1 for the header, and Column is empty for problems with a whole row.*/
+type Diagnostic struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column string `json:"column,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Column == "" {
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Reason)
+	}
+	return fmt.Sprintf("%s:%d: column %s: %s", d.File, d.Line, d.Column, d.Reason)
+}
+
+/*This is the list of diagnostics for one or more files.*/
+type Diagnostics []Diagnostic
+
+//Adds a diagnostic to the list.
+func (d *Diagnostics) Add(file string, line int, column, reason string) {
+	*d = append(*d, Diagnostic{file, line, column, reason})
+}
+
+/*This is a record struct which holds one row that matched the schema.*/
+type Record struct {
+	Line    int                 //Line number in the file
+	Text    map[string]string   //Text columns
+	Numbers map[string]float64  //Number columns
+	Lists   map[string][]string //List columns
+}
+
+//Gives the value of the key column.
+func (r Record) Key(schema Schema) string {
+	return r.Text[schema.Key]
+}
+
+//Reads a data file and checks every row against the schema. Rows with a
+//problem are left out and described in the diagnostics. The error is only
+//set if the file couldn't be read at all.
+func ReadTable(filename string, schema Schema) ([]Record, Diagnostics, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't open %s: %v", filename, err)
+	}
+	defer file.Close()
+	return ParseTable(file, filename, schema)
+}
+
+//Reads data in the schema's format. The filename is only used in the diagnostics.
+func ParseTable(in io.Reader, filename string, schema Schema) ([]Record, Diagnostics, error) {
+	var diagnostics Diagnostics
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1 //row lengths are checked below
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		diagnostics.Add(filename, 1, "", "file is empty, expected a header row")
+		return nil, diagnostics, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("error reading %s: %v", filename, err)
+	}
+	columnIdx := make(map[string]int)
+	for idx := range header {
+		header[idx] = strings.TrimSpace(strings.TrimPrefix(header[idx], "\ufeff"))
+		name := header[idx]
+		if _, ok := columnIdx[name]; ok {
+			diagnostics.Add(filename, 1, name, "column appears more than once")
+		}
+		columnIdx[name] = idx
+	}
+	for _, column := range schema.Columns {
+		if _, ok := columnIdx[column.Name]; !ok && column.Required {
+			diagnostics.Add(filename, 1, column.Name, "required column is missing from the header")
+		}
+	}
+	for _, name := range header {
+		if schema.Column(name) == nil {
+			diagnostics.Add(filename, 1, name, "column is not part of the "+schema.Name+" schema")
+		}
+	}
+	if len(diagnostics) > 0 {
+		return nil, diagnostics, nil
+	}
+
+	records := make([]Record, 0)
+	seen := make(map[string]int)
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				diagnostics.Add(filename, parseErr.Line, "", parseErr.Err.Error())
+				continue
+			}
+			return nil, nil, fmt.Errorf("error reading %s: %v", filename, err)
+		}
+		if IsBlankRow(fields) {
+			continue
+		}
+		if len(fields) != len(header) {
+			diagnostics.Add(filename, line, "", fmt.Sprintf("row has %d fields, header has %d", len(fields), len(header)))
+			continue
+		}
+		record, ok := schema.ParseRow(fields, columnIdx, filename, line, &diagnostics)
+		if !ok {
+			continue
+		}
+		key := record.Key(schema)
+		if firstLine, ok := seen[key]; ok {
+			diagnostics.Add(filename, line, schema.Key, fmt.Sprintf("%q is already defined on line %d", key, firstLine))
+			continue
+		}
+		seen[key] = line
+		records = append(records, record)
+	}
+	return records, diagnostics, nil
+}
+
+//Tells whether every field in a row is empty.
+func IsBlankRow(fields []string) bool {
+	for _, field := range fields {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+//Finds a column by name, or nil if the schema doesn't have it.
+func (schema Schema) Column(name string) *Column {
+	for i := range schema.Columns {
+		if schema.Columns[i].Name == name {
+			return &schema.Columns[i]
+		}
+	}
+	return nil
+}
+
+//Checks one row against the schema and converts its values. It returns false
+//if any value had a problem.
+func (schema Schema) ParseRow(fields []string, columnIdx map[string]int, filename string, line int, diagnostics *Diagnostics) (Record, bool) {
+	record := Record{line, make(map[string]string), make(map[string]float64), make(map[string][]string)}
+	ok := true
+	for _, column := range schema.Columns {
+		idx, present := columnIdx[column.Name]
+		value := ""
+		if present {
+			value = strings.TrimSpace(fields[idx])
+		}
+		if value == "" {
+			if column.Required {
+				diagnostics.Add(filename, line, column.Name, "value is missing")
+				ok = false
+			}
+			continue
+		}
+		switch column.Kind {
+		case KindText:
+			record.Text[column.Name] = value
+		case KindList:
+			items := make([]string, 0)
+			for _, item := range strings.Split(value, ";") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			record.Lists[column.Name] = items
+		case KindNumber:
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(number) {
+				diagnostics.Add(filename, line, column.Name, fmt.Sprintf("%q is not a number", value))
+				ok = false
+			} else if number < column.Min || number > column.Max {
+				diagnostics.Add(filename, line, column.Name, fmt.Sprintf("%v is outside %v to %v %s", number, column.Min, column.Max, column.Unit))
+				ok = false
+			} else {
+				record.Numbers[column.Name] = number
+			}
+		}
+	}
+	return record, ok
+}
+
+//Writes records in the schema's format, with every column of the schema in
+//order and Windows line endings like the data files. Lists are written
+//separated by semicolons.
+func WriteTable(out io.Writer, schema Schema, records []Record) error {
+	writer := csv.NewWriter(out)
+	writer.UseCRLF = true
+	header := make([]string, len(schema.Columns))
+	for i, column := range schema.Columns {
+		header[i] = column.Name
+	}
+	writer.Write(header)
+	for _, record := range records {
+		writer.Write(schema.Fields(record))
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//Writes records to a file with WriteTable. The file is only replaced once
+//every record has been written, so a failed write leaves the old file as it
+//was.
+func WriteTableFile(filename string, schema Schema, records []Record) error {
+	temp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("couldn't write %s: %v", filename, err)
+	}
+	defer os.Remove(temp.Name())
+	if err := WriteTable(temp, schema, records); err != nil {
+		temp.Close()
+		return fmt.Errorf("couldn't write %s: %v", filename, err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("couldn't write %s: %v", filename, err)
+	}
+	return os.Rename(temp.Name(), filename)
+}
+
+//Gives a record's values as fields in the order of the schema's columns.
+//Missing values are empty.
+func (schema Schema) Fields(record Record) []string {
+	fields := make([]string, len(schema.Columns))
+	for i, column := range schema.Columns {
+		switch column.Kind {
+		case KindText:
+			fields[i] = record.Text[column.Name]
+		case KindList:
+			fields[i] = strings.Join(record.Lists[column.Name], ";")
+		case KindNumber:
+			if number, ok := record.Numbers[column.Name]; ok {
+				fields[i] = strconv.FormatFloat(number, 'f', -1, 64)
+			}
+		}
+	}
+	return fields
+}
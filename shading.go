@@ -0,0 +1,604 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file works out how much sunlight shade takes from a roof
+plane. Far away things (hills, tree lines, the buildings down the street)
+are given as a horizon profile: how high the horizon is in each direction,
+as typed in or exported by site survey tools. Things close to the roof
+(trees, chimneys, the house next door) are given as boxes and cylinders. For
+every hour of the year the sun is found with the solar position algorithm
+and the direct sunlight is shaded on the points of the plane the sun can't
+reach, and the sky's diffuse light is shaded by the part of the sky the
+plane can't see. The yearly loss is what the plane's shading loss becomes.*/
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"webtest/solarpos"
+)
+
+//How high the eave of a roof plane is above the ground when it isn't given,
+//in meters, about a one story house.
+const DefaultEaveHeight = 3.0
+
+//How many points across and up a roof plane are checked for the shade of
+//obstructions. The share of the points in shade is the share of the plane's
+//direct sunlight that is shaded.
+const ShadeSamples = 5
+
+//The kinds of obstruction.
+const (
+	ObstructionBox      = "box"      //a chimney or a building, with its sides facing the compass points
+	ObstructionCylinder = "cylinder" //a tree, or a round tank
+)
+
+/*This is one point of a horizon profile: how high the horizon is, in
+degrees above flat, in one direction, in degrees clockwise from north.*/
+type HorizonPoint struct {
+	Azimuth   float64 `json:"azimuth"`
+	Elevation float64 `json:"elevation"`
+}
+
+/*This is a horizon profile, sorted by azimuth. Between its points the
+horizon's height changes in a straight line, going round past north.*/
+type Horizon []HorizonPoint
+
+/*This is the struct storing something close to the roof that casts shade on
+it. Its position is the middle of its footprint, in meters east and north of
+the middle of the roof plane, and its heights are above the ground.*/
+type Obstruction struct {
+	Name   string  `json:"name,omitempty"`
+	Kind   string  `json:"kind"`     //box or cylinder
+	East   float64 `json:"east_m"`   //west is negative
+	North  float64 `json:"north_m"`  //south is negative
+	Width  float64 `json:"width_m"`  //east to west, for a box
+	Depth  float64 `json:"depth_m"`  //north to south, for a box
+	Radius float64 `json:"radius_m"` //for a cylinder
+	Height float64 `json:"height_m"` //top above the ground
+	Base   float64 `json:"base_m"`   //bottom above the ground, such as where a tree's leaves start
+}
+
+/*This is the struct storing what shades a roof plane: the horizon, the
+obstructions close to it and how high the plane's eave is above the ground.*/
+type Shade struct {
+	Horizon      Horizon       `json:"horizon,omitempty"`
+	Obstructions []Obstruction `json:"obstructions,omitempty"`
+	EaveHeight   float64       `json:"eave_height_m"`
+}
+
+/*This is the struct storing the shading of a roof plane over a year: how much
+of the direct sunlight and of the sky's diffuse light on it is shaded, the
+loss of all of its sunlight each month and over the year, and, if asked for,
+the share of the plane in shade each hour.*/
+type ShadeReport struct {
+	Source      string      `json:"source"`               //the weather file the hours' sunlight came from, or "clear sky"
+	BeamLoss    float64     `json:"beam_loss_percent"`    //share of the direct sunlight shaded
+	DiffuseLoss float64     `json:"diffuse_loss_percent"` //share of the sky's diffuse light blocked
+	Loss        float64     `json:"loss_percent"`         //share of all the sunlight on the plane shaded over the year
+	Monthly     [12]float64 `json:"monthly_loss_percent"`
+	Hourly      []float64   `json:"hourly_fraction,omitempty"` //share of the plane in the sun's shade each hour, from 1 a.m. on January 1st
+}
+
+//Gives the height of the horizon in a direction, in degrees. An empty horizon
+//is flat.
+func (horizon Horizon) ElevationAt(azimuth float64) float64 {
+	if len(horizon) == 0 {
+		return 0
+	}
+	if len(horizon) == 1 {
+		return horizon[0].Elevation
+	}
+	azimuth = math.Mod(math.Mod(azimuth, 360)+360, 360)
+	i := sort.Search(len(horizon), func(i int) bool { return horizon[i].Azimuth > azimuth })
+	before, after := horizon[(i+len(horizon)-1)%len(horizon)], horizon[i%len(horizon)]
+	span := math.Mod(after.Azimuth-before.Azimuth+360, 360)
+	if span == 0 {
+		return before.Elevation
+	}
+	share := math.Mod(azimuth-before.Azimuth+360, 360) / span
+	return before.Elevation + share*(after.Elevation-before.Elevation)
+}
+
+//Sorts a horizon by azimuth and checks its points: azimuths from 0 to 360
+//and elevations from 0 to 90 degrees.
+func CheckHorizon(horizon Horizon) error {
+	sort.SliceStable(horizon, func(i, j int) bool { return horizon[i].Azimuth < horizon[j].Azimuth })
+	for _, point := range horizon {
+		if point.Azimuth < 0 || point.Azimuth > 360 {
+			return fmt.Errorf("the azimuth %s is not from 0 to 360 degrees", FormatNumber(point.Azimuth))
+		}
+		if point.Elevation < 0 || point.Elevation > 90 {
+			return fmt.Errorf("the elevation %s is not from 0 to 90 degrees", FormatNumber(point.Elevation))
+		}
+	}
+	return nil
+}
+
+//The names site survey tools give the columns of a horizon profile, after
+//HorizonColumn.
+var HorizonColumns = map[string]string{
+	"azimuth": "azimuth", "az": "azimuth", "azi": "azimuth", "a": "azimuth",
+	"elevation": "elevation", "el": "elevation", "elev": "elevation", "height": "elevation", "hei": "elevation",
+	"hhor": "elevation", "altitude": "elevation", "alt": "elevation", "horizon": "elevation", "angle": "elevation",
+}
+
+//Gives the column of a horizon profile a header names: "azimuth" or
+//"elevation", by its first word, so "Azimuth (S=0)" and "h_hor [deg]" are
+//found. It gives "" for other columns.
+func HorizonColumn(header string) string {
+	words := strings.FieldsFunc(strings.ToLower(header), func(r rune) bool { return !unicode.IsLetter(r) && r != '_' })
+	if len(words) == 0 {
+		return ""
+	}
+	return HorizonColumns[NormalizeHeader(words[0])]
+}
+
+//Reads a horizon profile. Text with more than one line is read as a CSV file
+//exported by a site survey tool (see ReadHorizonCSV); one line is read as
+//azimuth,elevation pairs separated by spaces or semicolons, such as
+//"90,5 180,12 270,8".
+func ParseHorizon(text string) (Horizon, error) {
+	text = strings.TrimSpace(text)
+	if strings.ContainsAny(text, "\r\n") {
+		return ReadHorizonCSV(strings.NewReader(text))
+	}
+	points, err := ParsePolygon(text)
+	if err != nil {
+		return nil, err
+	}
+	horizon := make(Horizon, len(points))
+	for i, p := range points {
+		horizon[i] = HorizonPoint{p.X, p.Y}
+	}
+	return horizon, CheckHorizon(horizon)
+}
+
+//Reads a horizon profile from a CSV file, such as those exported by site
+//survey tools. Lines before the row naming the azimuth and elevation columns
+//are skipped, and the values can be separated by commas, semicolons or tabs.
+//Azimuths are clockwise from north, unless the header says they are from
+//south or any of them are negative, as in PVsyst and PVGIS, where south is 0
+//and east is -90.
+func ReadHorizonCSV(in io.Reader) (Horizon, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	text := string(data)
+	var comma rune = ','
+	for _, separator := range []rune{';', '\t'} {
+		if strings.Count(text, string(separator)) > strings.Count(text, string(comma)) {
+			comma = separator
+		}
+	}
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma, reader.FieldsPerRecord, reader.LazyQuotes = comma, -1, true
+	azimuthColumn, elevationColumn := -1, -1
+	fromSouth := false
+	var horizon Horizon
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if azimuthColumn < 0 {
+			for i, name := range row {
+				switch HorizonColumn(name) {
+				case "azimuth":
+					azimuthColumn = i
+					fromSouth = strings.Contains(strings.ToLower(name), "south") || strings.Contains(strings.ToLower(name), "s=0")
+				case "elevation":
+					elevationColumn = i
+				}
+			}
+			if azimuthColumn < 0 || elevationColumn < 0 {
+				azimuthColumn, elevationColumn = -1, -1
+			}
+			continue
+		}
+		if len(row) <= azimuthColumn || len(row) <= elevationColumn || strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		azimuth, errAz := strconv.ParseFloat(strings.TrimSpace(row[azimuthColumn]), 64)
+		elevation, errEl := strconv.ParseFloat(strings.TrimSpace(row[elevationColumn]), 64)
+		if errAz != nil || errEl != nil {
+			return nil, fmt.Errorf("line %d: %q is not an azimuth and elevation", line, strings.Join(row, string(comma)))
+		}
+		fromSouth = fromSouth || azimuth < 0
+		horizon = append(horizon, HorizonPoint{azimuth, math.Max(0, elevation)})
+	}
+	if azimuthColumn < 0 {
+		return nil, errors.New("no row names an azimuth and an elevation column")
+	}
+	if len(horizon) == 0 {
+		return nil, errors.New("the horizon has no points")
+	}
+	if fromSouth {
+		for i := range horizon {
+			horizon[i].Azimuth = math.Mod(horizon[i].Azimuth+180+360, 360)
+		}
+	}
+	return horizon, CheckHorizon(horizon)
+}
+
+//Reads obstructions typed one a line as the kind, then east, north, the
+//size and height, and optionally the base: "box east north width depth
+//height" or "cylinder east north radius height". tree is a cylinder and
+//chimney and building are boxes. The numbers are kept in the units they were
+//typed in.
+func ParseObstructions(text string) ([]Obstruction, error) {
+	var obstructions []Obstruction
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(strings.ReplaceAll(line, ",", " "))
+		if len(fields) == 0 {
+			continue
+		}
+		obstruction := Obstruction{Name: fields[0], Kind: ObstructionKind(fields[0])}
+		sizes := map[string]int{ObstructionBox: 5, ObstructionCylinder: 4}[obstruction.Kind]
+		if sizes == 0 {
+			return nil, fmt.Errorf("%q is not a box, cylinder, tree, chimney or building", fields[0])
+		}
+		if len(fields) != sizes+1 && len(fields) != sizes+2 {
+			return nil, fmt.Errorf("%q needs %d numbers and optionally a base", strings.TrimSpace(line), sizes)
+		}
+		values := make([]float64, len(fields)-1)
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil, fmt.Errorf("%q is not a number", field)
+			}
+			values[i] = value
+		}
+		obstruction.East, obstruction.North = values[0], values[1]
+		if obstruction.Kind == ObstructionBox {
+			obstruction.Width, obstruction.Depth, obstruction.Height = values[2], values[3], values[4]
+		} else {
+			obstruction.Radius, obstruction.Height = values[2], values[3]
+		}
+		if len(values) > sizes {
+			obstruction.Base = values[sizes]
+		}
+		obstructions = append(obstructions, obstruction)
+	}
+	return obstructions, nil
+}
+
+//Gives the kind of obstruction a name is: tree is a cylinder and chimney and
+//building are boxes.
+func ObstructionKind(name string) string {
+	switch kind := strings.ToLower(strings.TrimSpace(name)); kind {
+	case "tree":
+		return ObstructionCylinder
+	case "chimney", "building":
+		return ObstructionBox
+	default:
+		return kind
+	}
+}
+
+//Gives an obstruction with its place and sizes multiplied by a factor, such
+//as FeetToMeters.
+func (obstruction Obstruction) Scale(factor float64) Obstruction {
+	obstruction.East, obstruction.North = obstruction.East*factor, obstruction.North*factor
+	obstruction.Width, obstruction.Depth = obstruction.Width*factor, obstruction.Depth*factor
+	obstruction.Radius = obstruction.Radius * factor
+	obstruction.Height, obstruction.Base = obstruction.Height*factor, obstruction.Base*factor
+	return obstruction
+}
+
+/*This is a point in meters east and north of the middle of a roof plane and
+up from the ground.*/
+type GroundPoint struct {
+	East, North, Up float64
+}
+
+//Gives where a point of a roof plane is on the ground. The plane's eave runs
+//across its azimuth and its slope rises away from it.
+func (shade Shade) Ground(face RoofFace, p Point) GroundPoint {
+	_, minY, _, _ := face.Layout.Bounds()
+	center := PolygonCenter(face.Layout.Polygon)
+	azimuth, tilt := face.Plane.Azimuth*math.Pi/180, face.Plane.Tilt*math.Pi/180
+	across, up := p.X-center.X, p.Y-center.Y
+	run := up * math.Cos(tilt) //horizontal distance up the slope, away from the way the plane faces
+	return GroundPoint{
+		East:  -math.Cos(azimuth)*across - math.Sin(azimuth)*run,
+		North: math.Sin(azimuth)*across - math.Cos(azimuth)*run,
+		Up:    shade.EaveHeight + (p.Y-minY)*math.Sin(tilt),
+	}
+}
+
+//Gives the average of a polygon's corners.
+func PolygonCenter(polygon []Point) Point {
+	var center Point
+	for _, p := range polygon {
+		center.X += p.X / float64(len(polygon))
+		center.Y += p.Y / float64(len(polygon))
+	}
+	return center
+}
+
+//Gives a grid of ShadeSamples by ShadeSamples points on a roof plane, the
+//ones inside its polygon, or its middle if none are.
+func ShadePoints(face RoofFace) []Point {
+	minX, minY, maxX, maxY := face.Layout.Bounds()
+	var points []Point
+	for i := 0; i < ShadeSamples; i++ {
+		for j := 0; j < ShadeSamples; j++ {
+			p := Point{minX + (float64(i)+0.5)/ShadeSamples*(maxX-minX), minY + (float64(j)+0.5)/ShadeSamples*(maxY-minY)}
+			if InPolygon(p, face.Layout.Polygon) {
+				points = append(points, p)
+			}
+		}
+	}
+	if len(points) == 0 {
+		points = append(points, PolygonCenter(face.Layout.Polygon))
+	}
+	return points
+}
+
+//Tells whether the line from a point toward the sun passes through an
+//obstruction. sun is the unit vector toward the sun.
+func (obstruction Obstruction) Blocks(from GroundPoint, sun GroundPoint) bool {
+	if obstruction.Kind == ObstructionCylinder {
+		dx, dy := from.East-obstruction.East, from.North-obstruction.North
+		a := sun.East*sun.East + sun.North*sun.North
+		b := 2 * (dx*sun.East + dy*sun.North)
+		c := dx*dx + dy*dy - obstruction.Radius*obstruction.Radius
+		if a < 1e-12 { //the sun straight up
+			return c <= 0 && from.Up < obstruction.Height
+		}
+		disc := b*b - 4*a*c
+		if disc < 0 {
+			return false
+		}
+		near, far := (-b-math.Sqrt(disc))/(2*a), (-b+math.Sqrt(disc))/(2*a)
+		if far < 0 {
+			return false
+		}
+		near = math.Max(near, 0)
+		return from.Up+near*sun.Up < obstruction.Height && from.Up+far*sun.Up > obstruction.Base
+	}
+	//a box: the part of the line inside each pair of its sides has to overlap
+	low, high := 0.0, math.Inf(1)
+	slabs := [3][3]float64{
+		{from.East, sun.East, obstruction.East - obstruction.Width/2},
+		{from.North, sun.North, obstruction.North - obstruction.Depth/2},
+		{from.Up, sun.Up, obstruction.Base},
+	}
+	sizes := [3]float64{obstruction.Width, obstruction.Depth, obstruction.Height - obstruction.Base}
+	for i, slab := range slabs {
+		start, direction, side := slab[0], slab[1], slab[2]
+		if math.Abs(direction) < 1e-12 {
+			if start < side || start > side+sizes[i] {
+				return false
+			}
+			continue
+		}
+		t1, t2 := (side-start)/direction, (side+sizes[i]-start)/direction
+		low, high = math.Max(low, math.Min(t1, t2)), math.Min(high, math.Max(t1, t2))
+		if low > high {
+			return false
+		}
+	}
+	return true
+}
+
+//Gives the horizon the middle of a roof plane sees in each whole degree of
+//azimuth: the horizon profile, raised wherever an obstruction is higher.
+func (shade Shade) SkyLine(face RoofFace) [360]float64 {
+	var skyline [360]float64
+	for az := range skyline {
+		skyline[az] = shade.Horizon.ElevationAt(float64(az) + 0.5)
+	}
+	center := shade.Ground(face, PolygonCenter(face.Layout.Polygon))
+	for _, obstruction := range shade.Obstructions {
+		radius := obstruction.Radius
+		if obstruction.Kind == ObstructionBox {
+			radius = math.Hypot(obstruction.Width, obstruction.Depth) / 2
+		}
+		dx, dy := obstruction.East-center.East, obstruction.North-center.North
+		distance := math.Hypot(dx, dy)
+		if distance <= radius || obstruction.Height <= center.Up {
+			continue
+		}
+		elevation := math.Atan2(obstruction.Height-center.Up, distance-radius) * 180 / math.Pi
+		middle := math.Atan2(dx, dy) * 180 / math.Pi
+		half := math.Asin(radius/distance) * 180 / math.Pi
+		for az := math.Floor(middle - half); az <= math.Ceil(middle+half); az++ {
+			bin := int(math.Mod(math.Mod(az, 360)+360, 360))
+			skyline[bin] = math.Max(skyline[bin], elevation)
+		}
+	}
+	return skyline
+}
+
+//Gives the share of the sky's diffuse light on a plane that a skyline blocks,
+//for light coming evenly from the whole sky.
+func SkyBlocked(skyline [360]float64, plane Plane) float64 {
+	tilt, facing := plane.Tilt*math.Pi/180, plane.Azimuth*math.Pi/180
+	var total, blocked float64
+	for az := range skyline {
+		azimuth := (float64(az) + 0.5) * math.Pi / 180
+		for el := 1.0; el < 90; el += 2 {
+			elevation := el * math.Pi / 180
+			cosIncidence := math.Sin(elevation)*math.Cos(tilt) + math.Cos(elevation)*math.Sin(tilt)*math.Cos(azimuth-facing)
+			if cosIncidence <= 0 {
+				continue
+			}
+			weight := cosIncidence * math.Cos(elevation)
+			total += weight
+			if el < skyline[az] {
+				blocked += weight
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return blocked / total
+}
+
+//Works out the shading of a roof plane over a year. The sunlight of each hour
+//comes from the weather file, or from a clear sky if there is none. With
+//hourly the share of the plane in shade each hour is kept.
+func AnalyzeShade(shade Shade, face RoofFace, lat, lon float64, weather *WeatherFile, hourly bool) ShadeReport {
+	report := ShadeReport{Source: "clear sky"}
+	if len(face.Layout.Polygon) < 3 {
+		face.Layout.Polygon = SquarePlan(face.Area).Polygon
+	}
+	observer := solarpos.NewObserver(lat, lon)
+	var hours []WeatherHour
+	if weather != nil && len(weather.Hours) == HoursPerYear {
+		hours, report.Source = weather.Hours, weather.Station.Name
+		observer.Elevation = weather.Station.Elevation
+	} else {
+		hours = ClearSkyHours(observer, math.Round(lon/15))
+	}
+	points := ShadePoints(face)
+	ground := make([]GroundPoint, len(points))
+	for i, p := range points {
+		ground[i] = shade.Ground(face, p)
+	}
+	skyBlocked := SkyBlocked(shade.SkyLine(face), face.Plane)
+	if hourly {
+		report.Hourly = make([]float64, len(hours))
+	}
+	var beam, beamShaded, light, shaded float64
+	var monthLight, monthShaded [12]float64
+	station := WeatherStation{TimeZone: math.Round(lon / 15)}
+	if weather != nil {
+		station = weather.Station
+	}
+	for i, hour := range hours {
+		sun := solarpos.SunPosition(HourTime(station, hour), observer)
+		if sun.Zenith >= 90 {
+			continue
+		}
+		fraction := 1.0
+		if sun.Elevation > shade.Horizon.ElevationAt(sun.Azimuth) {
+			fraction = SunShade(shade.Obstructions, ground, sun)
+		}
+		sky := SkyLight{
+			GHI: hour.GHI, DNI: hour.DNI, DHI: hour.DHI, Albedo: hour.Albedo,
+			Extraterrestrial: SolarConstant / (sun.EarthSunAU * sun.EarthSunAU),
+			Zenith:           sun.Zenith,
+			Incidence:        solarpos.Incidence(sun, face.Plane.Tilt, face.Plane.Azimuth),
+		}
+		direct := hour.DNI * math.Max(0, math.Cos(sky.Incidence*math.Pi/180))
+		diffuse := SkyDiffuse(sky, face.Plane)
+		if hourly && direct > 0 {
+			report.Hourly[i] = math.Round(fraction*1000) / 1000
+		}
+		beam += direct
+		beamShaded += direct * fraction
+		light += direct + diffuse
+		shaded += direct*fraction + diffuse*skyBlocked
+		if hour.Month >= 1 && hour.Month <= 12 {
+			monthLight[hour.Month-1] += direct + diffuse
+			monthShaded[hour.Month-1] += direct*fraction + diffuse*skyBlocked
+		}
+	}
+	if beam > 0 {
+		report.BeamLoss = math.Round(beamShaded/beam*10000) / 100
+	}
+	report.DiffuseLoss = math.Round(skyBlocked*10000) / 100
+	if light > 0 {
+		report.Loss = math.Round(shaded/light*10000) / 100
+	}
+	for m := range report.Monthly {
+		if monthLight[m] > 0 {
+			report.Monthly[m] = math.Round(monthShaded[m]/monthLight[m]*10000) / 100
+		}
+	}
+	return report
+}
+
+//Gives the share of the points of a plane whose view of the sun an
+//obstruction blocks.
+func SunShade(obstructions []Obstruction, points []GroundPoint, sun solarpos.Position) float64 {
+	if len(obstructions) == 0 || len(points) == 0 {
+		return 0
+	}
+	elevation, azimuth := sun.Elevation*math.Pi/180, sun.Azimuth*math.Pi/180
+	toward := GroundPoint{math.Cos(elevation) * math.Sin(azimuth), math.Cos(elevation) * math.Cos(azimuth), math.Sin(elevation)}
+	blocked := 0
+	for _, point := range points {
+		for _, obstruction := range obstructions {
+			if obstruction.Blocks(point, toward) {
+				blocked++
+				break
+			}
+		}
+	}
+	return float64(blocked) / float64(len(points))
+}
+
+//Makes a year of hours of a clear sky, for shading a site without a weather
+//file. The direct sunlight thins with the air it passes through (Meinel and
+//Meinel, 1976) and the sky's diffuse light is a tenth of it.
+func ClearSkyHours(observer solarpos.Observer, timeZone float64) []WeatherHour {
+	station := WeatherStation{TimeZone: timeZone}
+	hours := make([]WeatherHour, 0, HoursPerYear)
+	day := time.Date(SimYear, 1, 1, 0, 0, 0, 0, time.UTC)
+	for d := 0; d < 365; d++ {
+		date := day.AddDate(0, 0, d)
+		for h := 1; h <= 24; h++ {
+			hour := WeatherHour{Month: int(date.Month()), Day: date.Day(), Hour: h, Albedo: DefaultAlbedo}
+			sun := solarpos.SunPosition(HourTime(station, hour), observer)
+			if sun.Zenith < 90 {
+				hour.DNI = SolarConstant * math.Pow(0.7, math.Pow(AirMass(sun.Zenith), 0.678))
+				hour.DHI = hour.DNI / 10
+				hour.GHI = hour.DNI*math.Cos(sun.Zenith*math.Pi/180) + hour.DHI
+			}
+			hours = append(hours, hour)
+		}
+	}
+	return hours
+}
+
+//Gives the closest weather file within WEATHER_MAX_KM, or nil if there isn't
+//one or it can't be read.
+func NearestWeather(weather *WeatherIndex, lat, lon float64) *WeatherFile {
+	station, _, ok := weather.Nearest(lat, lon, getWeatherMaxKm())
+	if !ok {
+		return nil
+	}
+	file, err := weather.Load(station)
+	if err != nil {
+		return nil
+	}
+	return file
+}
+
+//Works out the shading of every roof face that has a horizon or
+//obstructions, and makes each one's yearly loss its shading loss, up to the
+//loss chain's most, unless the face's shading was given. Faces without shade
+//get nil.
+func ShadeFaces(faces []RoofFace, lat, lon float64, weather *WeatherFile) []*ShadeReport {
+	shading, _ := FindLoss("shading")
+	reports := make([]*ShadeReport, len(faces))
+	for i, face := range faces {
+		if face.Shade == nil || (len(face.Shade.Horizon) == 0 && len(face.Shade.Obstructions) == 0) {
+			continue
+		}
+		report := AnalyzeShade(*face.Shade, face, lat, lon, weather, false)
+		reports[i] = &report
+		if face.Shading == nil {
+			loss := math.Min(report.Loss, shading.Max)
+			faces[i].Shading = &loss
+		}
+	}
+	return reports
+}
@@ -0,0 +1,140 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file simulates a solar array hour by hour over a typical
+year from a weather file. For every hour it works out where the sun is, how
+much of the direct, sky and ground-reflected sunlight lands on panels at a
+given tilt and azimuth, and how much energy the panels make from it after
+heat, the loss chain and the inverter. PlaneOutput stays as the quick
+estimate for sites without a weather file.*/
+
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"webtest/solarpos"
+)
+
+//Which model made an estimate's output numbers.
+const (
+	ModelQuick  = "quick"  //PlaneOutput from the monthly radiation
+	ModelHourly = "hourly" //Simulate from a weather file
+)
+
+/*This is the struct storing the result of a simulation. Hourly has the
+energy made in each of the 8760 hours of the year, starting at 1 a.m. on
+January 1st.*/
+type Simulation struct {
+	Station    WeatherStation `json:"station"`
+	DistanceKm float64        `json:"distance_km"` //from the site to the weather station
+	Plane                     //which way the panels face
+	AreaM2     float64        `json:"area_m2"`
+	Annual     float64        `json:"annual_kwh"`
+	Monthly    [12]float64    `json:"monthly_kwh"`
+	MonthlyPOA [12]float64    `json:"monthly_poa_kwh_m2_day"` //average daily sunlight on the panels
+	Heat       HeatLoss       `json:"heat"`                   //energy lost to heat, included in Annual and Monthly
+	Inverter   InverterLoss   `json:"inverter"`               //inverter efficiency and clipping, included in Annual and Monthly
+	Losses     LossWaterfall  `json:"losses"`                 //the average month's output with no losses, and what each step took
+	Hourly     []float64      `json:"hourly_kwh,omitempty"`
+}
+
+//Gives the direction panels should face: south in the northern hemisphere
+//and north in the southern one.
+func EquatorAzimuth(lat float64) float64 {
+	if lat < 0 {
+		return 0
+	}
+	return 180
+}
+
+//The year the hours of a weather file are placed in to find the sun. Any
+//year that isn't a leap year works, since a typical year has 365 days.
+const SimYear = 2023
+
+//Gives the middle of an hour of a weather file as a time in the station's
+//local standard time.
+func HourTime(station WeatherStation, hour WeatherHour) time.Time {
+	zone := time.FixedZone("", int(station.TimeZone*3600))
+	start := time.Date(SimYear, time.Month(hour.Month), hour.Day, 0, 0, 0, 0, zone)
+	return start.Add(time.Duration(hour.Hour)*time.Hour - 30*time.Minute)
+}
+
+//Gives the sunlight landing on the panels in W/m² (the plane of array
+//irradiance) in an hour of a weather file, with the plane's transposition model.
+func PlaneOfArray(hour WeatherHour, sun solarpos.Position, plane Plane) float64 {
+	light := SkyLight{
+		GHI:              hour.GHI,
+		DNI:              hour.DNI,
+		DHI:              hour.DHI,
+		Albedo:           hour.Albedo,
+		Extraterrestrial: SolarConstant / (sun.EarthSunAU * sun.EarthSunAU),
+		Zenith:           sun.Zenith,
+		Incidence:        solarpos.Incidence(sun, plane.Tilt, plane.Azimuth),
+	}
+	return Transpose(light, plane)
+}
+
+//Simulates a system of panels of the given area in m² facing a plane for
+//every hour of a weather file. The heat loss, the inverter's efficiency and
+//clipping are worked out hour by hour.
+func Simulate(weather *WeatherFile, plane Plane, system System, areaM2 float64) (Simulation, error) {
+	if weather == nil || len(weather.Hours) != HoursPerYear {
+		return Simulation{}, errors.New("the weather file doesn't have a full year of hours")
+	}
+	station := weather.Station
+	observer := solarpos.NewObserver(station.Latitude, station.Longitude)
+	observer.Elevation = station.Elevation
+	sim := Simulation{Station: station, Plane: plane, AreaM2: areaM2, Hourly: make([]float64, HoursPerYear)}
+	kw := areaM2 * system.Efficiency / 100 //the panels' rated power
+	var months [12]FlowTotals
+	for i, hour := range weather.Hours {
+		//the sun's position half way through the hour
+		sun := solarpos.SunPosition(HourTime(station, hour), observer)
+		poa := PlaneOfArray(hour, sun, plane)
+		flow := system.Flow(poa/1000, hour.Temp)
+		sim.Hourly[i] = flow.AC * kw
+		sim.Annual += sim.Hourly[i]
+		if hour.Month >= 1 && hour.Month <= 12 {
+			months[hour.Month-1].Add(flow, hour.Temp, 1, kw)
+			sim.Monthly[hour.Month-1] += sim.Hourly[i]
+			sim.MonthlyPOA[hour.Month-1] += poa / 1000 / float64(MonthDays[hour.Month-1])
+		}
+	}
+	sim.Heat = NewHeatLoss(system.Thermal, months)
+	sim.Inverter = NewInverterLoss(system, months)
+	sim.Losses = MakeWaterfall(sim.Annual/12, sim.Heat, sim.Inverter, system.Losses)
+	return sim, nil
+}
+
+//Simulates the site with the closest weather file, if there is one within
+//WEATHER_MAX_KM. It returns false if there isn't.
+func SimulateSite(weather *WeatherIndex, lat, lon float64, plane Plane, system System, roofSize float64) (Simulation, bool, error) {
+	station, km, ok := weather.Nearest(lat, lon, getWeatherMaxKm())
+	if !ok {
+		return Simulation{}, false, nil
+	}
+	file, err := weather.Load(station)
+	if err != nil {
+		return Simulation{}, true, err
+	}
+	sim, err := Simulate(file, plane, system, roofSize*0.092903) //square feet to square meters
+	sim.DistanceKm = math.Round(km*10) / 10
+	return sim, true, err
+}
+
+//Replaces the output and radiation columns of the monthly table with
+//simulated ones: the roof for the output and radiation and tilted for the
+//output at the optimal angle.
+func SimulatedTable(rows []MonthRow, roof, tilted Simulation) {
+	for m := range rows {
+		rows[m].Output = float64(int(roof.Monthly[m]*100)) / 100
+		rows[m].OptOutput = float64(int(tilted.Monthly[m]*100)) / 100
+		rows[m].Radiation = math.Round(roof.MonthlyPOA[m]*100) / 100
+		rows[m].HeatLoss = roof.Heat.Monthly[m]
+		rows[m].Percentage = 0
+		if rows[m].Usage > 0 {
+			rows[m].Percentage = int(roof.Monthly[m] / rows[m].Usage * 100)
+		}
+	}
+}
@@ -9,41 +9,89 @@ MakeCityMap(), and ReadFile() were written by Caryn Willis.*/
 package main
 
 import (
-	"bufio"
-	"fmt"
 	"html/template"
 	"log"
-	"math"
 	"net/http"
+	"net/url"
 	"os"
-	"strconv"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
 /*This is a city struct which stores all of the data for each city.
-It stores the coordinates, temperature, solar radiation (at flat angle),
+It stores the coordinates (signed degrees, east positive), temperature, record low, solar radiation (at flat angle),
 optimal angle, optimal radiation (at optimal angle), average energy usage,
 installation cost, and a slice of 3 company names for each city.*/
 type City struct {
-	coordN    float64
-	coordW    float64
+	lat       float64
+	lon       float64
 	temp      float64
+	recordLow float64 //lowest temperature on record, °F
 	solarRad  float64
 	optAng    float64
 	optRad    float64
 	avgEnergy float64
 	instCost  float64
 	companies []string
+	export    string //key of the export policy its utilities usually use
+	state     string //two letters, such as NY
+
+	monthlyRad    [12]float64 //radiation on a flat surface each month, kWh/m²/day
+	monthlyOptRad [12]float64 //radiation at optAng each month, kWh/m²/day
+	monthlyUse    [12]float64 //energy used in each month by a 2600 sq ft house, kwh
+	monthlySource string      //MonthlyMeasured or MonthlyEstimated
+
+	recordLowEstimated bool //recordLow was estimated from temp
 }
 
 /* This is a panel struct which stores the information for each type of solar
-panel. We have chosen 6 panels for the user to choose from here, each with
-information on its efficiency (percentage), watts, panel area, and price.*/
+panel. The user can choose from every panel in solar.csv that has a price
+in prices.csv, each with information on its maker and model, efficiency
+(percentage), watts, size, price, how much power it loses to heat, and the
+voltages and currents strings are sized with.*/
 type Panel struct {
-	efficiency float64
-	watts      float64
-	area       float64
-	price      float64
+	manufacturer string
+	model        string
+	technology   string
+	efficiency   float64
+	watts        float64 //at STC
+	ptcWatts     float64 //at PTC, 0 if not known
+	area         float64
+	length       float64 //long side, m
+	width        float64 //short side, m
+	price        float64
+	priced       bool    //the price sheet has a price for it
+	priceDate    string  //when the price was last updated
+	tempCoeff    float64 //% of power for each °C the cells are above 25°C
+	noct         float64 //nominal operating cell temperature, °C
+	voc          float64 //open-circuit voltage at STC, 0 if not known
+	vmp          float64 //voltage at maximum power at STC
+	isc          float64 //short-circuit current at STC, amps
+	imp          float64 //current at maximum power at STC, amps
+	vocCoeff     float64 //% of Voc for each °C the cells are above 25°C
+	iscCoeff     float64 //% of Isc for each °C the cells are above 25°C
+	bifaciality  float64 //rear side's power over the front's, 0 for one-sided panels
+	source       string  //where the row came from, such as cec
+	version      string  //import that last changed the row
+}
+
+/*This is a panel row struct which stores one row of the panel table on the
+results page: the brand, how many panels are needed and fit on the roof,
+what they cost, the recommended inverter and the drawing of the panels on
+the roof.*/
+type PanelRow struct {
+	Name       string
+	Efficiency float64
+	Watts      float64
+	NumPanels  int
+	Needed     int
+	Cost       int
+	Inverter   string
+	Layout     template.HTML
+	Finance    Finance
+	Incentives CostBreakdown
+	Financing  Financing
 }
 
 /*This is a coordinates struct which has a identifying name (for the web
@@ -65,36 +113,76 @@ type House struct {
 /*This is the struct storing all of the variables needed to be displayed
 on the web app.*/
 type PageVariables struct {
-	PageTitle       string        //Title of the page
-	PageCoordinates []Coordinates //Coordinates of the user
-	PageHouseSize   []House       //House size of the user
-	PageRoofSize    float64       //Roof size of the user
-	MyCity          string        //City name that is closest to the user
-	Output          float64       //Expected solar energy output
-	OptAngle        float64       //Optimal angle for panels
-	OptOutput       float64       //Optimal solar energy output
-	Usage           float64       //Average energy usage
-	Optimal         string        //Is it optimal to install solar power? Gives recommendation.
-	InstCost        float64       //Installation cost
-	Companies       []string      //3 company names
-	NumPanels       []int         //Number of panels needed for each brand
-	PanelCost       []int         //Cost of panels for each brand
-	Recommendation  []string      //Recommendation for each of the user preferences (efficiency, cost, production)
-	Percentage      int           //Percentage that their energy is covered by solar
-	Map             []string      //Map colors for each city (red, yellow, green)
-	RedList         []string      //List of cities in red
-	YellowList      []string      //List of cities in yellow
-	GreenList       []string      //List of cities in green
-	RedPercent      float64       //Percentage of cities in red
-	YellowPercent   float64       //Percentage of cities in yellow
-	GreenPercent    float64       //Percentage of cities in green
+	PageTitle       string            //Title of the page
+	PageCoordinates []Coordinates     //Coordinates of the user
+	PageHouseSize   []House           //House size of the user
+	PageRoofSize    float64           //Roof size of the user
+	MyCity          string            //City name that is closest to the user
+	Output          float64           //Expected solar energy output
+	OptAngle        float64           //Optimal angle for panels
+	OptOutput       float64           //Optimal solar energy output
+	Usage           float64           //Average energy usage
+	Optimal         string            //Is it optimal to install solar power? Gives recommendation.
+	InstCost        float64           //Installation cost
+	Companies       []string          //3 company names
+	Panels          []PanelRow        //Number, cost and inverter of each brand in the catalog
+	Recommendation  []string          //Recommendation for each of the user preferences (efficiency, cost, production)
+	Percentage      int               //Percentage that their energy is covered by solar
+	Map             []string          //Map colors for each city (red, yellow, green)
+	RedList         []string          //List of cities in red
+	YellowList      []string          //List of cities in yellow
+	GreenList       []string          //List of cities in green
+	RedPercent      float64           //Percentage of cities in red
+	YellowPercent   float64           //Percentage of cities in yellow
+	GreenPercent    float64           //Percentage of cities in green
+	FieldErrors     map[string]string //Error message for each form field that was invalid
+	FormValues      map[string]string //Values the user entered, kept when the form is shown again
+	DistanceKm      float64           //Distance to the closest city in km
+	DistanceMiles   float64           //Distance to the closest city in miles
+	Warnings        []string          //Warnings about the estimate
+	Location        string            //The user's coordinates with hemisphere letters
+	Interpolation   *Interpolation    //Cities blended into the climate data, if any
+	Monthly         []MonthRow        //Output and usage for each month
+	MonthlySource   string            //Whether the monthly radiation was measured or estimated
+	MonthlyChart    []ChartBar        //Bars of the monthly chart
+	Simulation      *Simulation       //Hourly simulation the output came from, if any
+	Roof            Plane             //Which way the user's roof faces
+	Faces           []FaceResult      //Output of each plane of the roof
+	FaceForms       []string          //Start of the field names of each row of planes on the form, such as plane1_
+	FaceFormFields  []string          //Fields of each row of planes on the form
+	Heat            HeatLoss          //Output lost to heat
+	Losses          LossWaterfall     //Output with no losses and what each loss took
+	LossChart       []WaterfallBar    //Bars of the loss waterfall chart
+	LossChain       []Loss            //Losses the user can change, with their defaults
+	Inverter        InverterLoss      //Inverter efficiency and clipping
+	InverterNames   []string          //Models in the inverter catalog, for the form
+	FinanceSettings []FinanceSetting  //Finance settings the user can change, with their defaults
+	LoanSettings    []FinanceSetting  //Loan, lease and PPA settings the user can change, with their defaults
+	Tariffs         []Tariff          //Utility rates the user can choose, for the form
+	ExportPolicies  []ExportPolicy    //Export policies the user can choose, for the form
+	Bill            BillComparison    //Bills before and after solar
+	MonthCashFlows  bool              //Whether the financing options' cash flows are shown month by month instead of year by year
 }
 
 func main() {
+	if code, ran := RunCommand(os.Args[1:]); ran { //run a command such as validate-data instead of the server
+		os.Exit(code)
+	}
+	store, err := NewDataStore(DefaultDataFiles) //load the data files once for every request
+	if err != nil {
+		log.Fatal("could not load data: ", err)
+	}
+	Data = store
+	Data.ReloadOnSignal() //reload the data files on SIGHUP
+	if interval := getReloadInterval(); interval > 0 {
+		Data.ReloadOnChange(interval) //reload the data files when they change
+	}
+
 	http.HandleFunc("/", DisplayCoordinates)          //DisplayCoordinates() loads when called with / at the end of the URL
 	http.HandleFunc("/selected", UserSelected)        //UserSelected() will load after the form with / is submitted
 	http.HandleFunc("/heatmap", DisplayHouseSize)     //DisplayHouseSize() will load when URL is called with /heatmap, or click tab
 	http.HandleFunc("/displayheatmap", UserInteracts) //UserInteracts() will load after form with /heatmap is submitted
+	RegisterAPI()                                     //JSON versions of the pages under /api/v1/
 	log.Fatal(http.ListenAndServe(getPort(), nil))
 }
 
@@ -111,35 +199,105 @@ func getPort() string {
 //user's info such as house size and coordinates and then loads
 //to the next page after this information is submitted.
 func DisplayCoordinates(w http.ResponseWriter, r *http.Request) {
+	RenderPage(w, http.StatusOK, "solarenergy.html", CoordinatesPage(nil, nil))
+}
 
+//Makes the variables for the page asking for the user's info. Any errors
+//from a previous submission are shown next to the fields they belong to.
+func CoordinatesPage(form url.Values, errs ValidationErrors) PageVariables {
 	Title := "Solar Energy"
 	MyCoordinates := []Coordinates{
-		Coordinates{"coordinaten", 0, "North"},
-		Coordinates{"coordinatew", 0, "West"},
+		Coordinates{"latitude", 0, "Latitude"},
+		Coordinates{"longitude", 0, "Longitude"},
 	}
 	MyHouse := []House{
 		House{"housesize", 0, "Size"},
 	}
 
 	var MyRoof float64
+	fields := []string{"location", "latitude", "longitude", "housesize", "roofsize", "pitch", "azimuth", "transposition", "inverter", "dc_ac_ratio", "interpolation",
+		"roof_width", "roof_length", "roof_polygon", "setback", "ridge_setback", "orientation", "horizon", "obstructions", "eave_height", "tariff", "export_policy", "install_date"}
+	var faceForms []string
+	for n := 1; n <= FormRoofFaces; n++ {
+		prefix := FaceFormPrefix(n)
+		faceForms = append(faceForms, prefix)
+		for _, field := range FaceFormFields {
+			fields = append(fields, prefix+field)
+		}
+	}
+	for _, loss := range LossChain {
+		fields = append(fields, "loss_"+loss.Key)
+	}
+	for _, setting := range AllFinanceSettings() {
+		fields = append(fields, setting.Key)
+	}
 
-	MyPageVariables := PageVariables{
+	var inverterNames []string
+	for name := range Data.Get().Inverters {
+		inverterNames = append(inverterNames, name)
+	}
+	sort.Strings(inverterNames)
+	tariffs := Data.Get().Tariffs
+	var tariffList []Tariff
+	for _, key := range TariffKeys(tariffs) {
+		tariffList = append(tariffList, tariffs[key])
+	}
+	policies := Data.Get().Export
+	var policyList []ExportPolicy
+	for _, key := range ExportPolicyKeys(policies) {
+		policyList = append(policyList, policies[key])
+	}
+
+	return PageVariables{
 		PageTitle:       Title,
+		LossChain:       LossChain,
+		FinanceSettings: FinanceSettings,
+		LoanSettings:    FinancingSettings,
+		Tariffs:         tariffList,
+		ExportPolicies:  policyList,
+		InverterNames:   inverterNames,
+		FaceForms:       faceForms,
+		FaceFormFields:  FaceFormFields,
 		PageCoordinates: MyCoordinates,
 		PageHouseSize:   MyHouse,
 		PageRoofSize:    MyRoof,
+		FieldErrors:     errs.ByField(),
+		FormValues:      FormValues(form, fields...),
 	}
+}
 
-	t, err := template.ParseFiles("solarenergy.html") //parse the html file solarenergy.html
+//Parses an html file and executes it with the page variables.
+func RenderPage(w http.ResponseWriter, status int, filename string, vars PageVariables) {
+	t, err := template.New(filepath.Base(filename)).Funcs(TemplateFuncs).ParseFiles(filename) //parse the html file
 	if err != nil {
 		log.Print("template parsing error: ", err)
+		http.Error(w, "could not load page", http.StatusInternalServerError)
+		return
 	}
 
-	err = t.Execute(w, MyPageVariables) //execute the template and pass it the MyPageVariables
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err = t.Execute(w, vars) //execute the template and pass it the page variables
 	if err != nil {
 		log.Print("template executing error: ", err)
 	}
+}
 
+//Helper functions the html files can use.
+var TemplateFuncs = template.FuncMap{
+	"percent":   func(share float64) float64 { return share * 100 }, //turns a share such as 0.25 into 25
+	"compass":   CompassPoint,                                       //turns an azimuth such as 225 into SW
+	"neg":       func(value float64) float64 { return -value },      //turns a loss into a gain
+	"hasPrefix": strings.HasPrefix,                                  //finds the errors of a list, such as obstructions[2]
+}
+
+//Copies the named form values into a map so the form can be filled in again.
+func FormValues(form url.Values, names ...string) map[string]string {
+	values := make(map[string]string)
+	for _, name := range names {
+		values[name] = form.Get(name)
+	}
+	return values
 }
 
 //This is the main function where the user interacts with the web app.
@@ -147,180 +305,134 @@ func DisplayCoordinates(w http.ResponseWriter, r *http.Request) {
 //with.
 func UserSelected(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm() //Parse the page for the variables needed
-	cityData := MakeCityMap("energy.csv")
-	solarPanels := MakeSolarMap("solar.csv")
-	northcoord, err1 := strconv.ParseFloat(r.Form.Get("coordinaten"), 64)
-	ErrorMessage(err1, "north coordinate", northcoord)
-	westcoord, err2 := strconv.ParseFloat(r.Form.Get("coordinatew"), 64)
-	ErrorMessage(err2, "west coordinate", westcoord)
-	houseSize, err3 := strconv.ParseFloat(r.Form.Get("housesize"), 64)
-	ErrorMessage(err3, "house size", houseSize)
-	roofSize, err4 := strconv.ParseFloat(r.Form.Get("roofsize"), 64)
-	ErrorMessage(err4, "roof size", roofSize)
-	closestcity := ClosestCity(cityData, northcoord, westcoord)
-	solarOutput := SolarOutput(closestcity, cityData, "horizontal", 15, roofSize)
-	solarOutput = float64(int(solarOutput*100)) / 100
-	optAngle := OptAngle(cityData, closestcity)
-	optEnergy := OptEnergy(cityData, closestcity, 15, roofSize)
-	optEnergy = float64(int(optEnergy*100)) / 100
-	avgUsage := AverageEnergy(cityData, closestcity) * houseSize
-	avgUsage = float64(int(avgUsage*100)) / 100
-	percent, recommendation := IsItOptimal(avgUsage, solarOutput)
-	percentage := int(percent * 100)
-	companylist := Companies(closestcity, cityData)
-	instCost := InstallationCost(cityData, closestcity)
-	instCost = float64(int(instCost*100)) / 100
-	numPanels, panelCost := CalcCostBrand(solarOutput, roofSize, cityData, closestcity, solarPanels)
-	preferences := Preferences(panelCost, solarPanels, closestcity, cityData, houseSize)
-
-	Title := "Your Home"
-	MyPageVariables := PageVariables{
-		PageTitle:      Title,
-		MyCity:         closestcity,
-		Output:         solarOutput,
-		OptAngle:       optAngle,
-		OptOutput:      optEnergy,
-		Usage:          avgUsage,
-		Optimal:        recommendation,
-		InstCost:       instCost,
-		Companies:      companylist,
-		NumPanels:      numPanels,
-		PanelCost:      panelCost,
-		Recommendation: preferences,
-		Percentage:     percentage,
-	}
-
-	t, err := template.ParseFiles("solarenergy.html") //parse the html file solarenergy.html
-	if err != nil {
-		log.Print("template parsing error: ", err)
-	}
-
-	err = t.Execute(w, MyPageVariables) //execute the template and pass it the MyPageVariables
-	if err != nil {
-		log.Print("template executing error: ", err)
-	}
-}
-
-//Read in the file.
-func ReadFile(filename string) []string {
-	//reads file and makes a line for each file
-	file, err := os.Open(filename)
-	if err != nil {
-		fmt.Println("Error: couldn't open the file")
-		os.Exit(1)
+	req, errs := ParseEstimateForm(r.Form)
+	dataset := Data.Get()
+	if len(errs) == 0 {
+		CheckCoverage(dataset, req.Latitude, req.Longitude, req.LocationField("latitude"), &errs)
 	}
-	lines := make([]string, 0)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	CheckInverter(dataset, req.Inverter, "inverter", &errs)
+	CheckTariff(dataset, req.Tariff, "tariff", &errs)
+	CheckExportPolicy(dataset, req.Export, "export_policy", &errs)
+	if len(errs) > 0 {
+		RenderPage(w, http.StatusBadRequest, "solarenergy.html", CoordinatesPage(r.Form, errs))
+		return
 	}
-	if scanner.Err() != nil {
-		fmt.Println("Error: there was some kind of error during the file reading")
-		os.Exit(1)
+	estimate := ComputeEstimate(dataset, req)
+	months := r.Form.Get("monthly_cash_flows") == "true"
+	if !months {
+		estimate.DropMonthlyCashFlows() //they make the page over a megabyte
 	}
-	file.Close()
-	return lines
+	page := estimate.PageVariables()
+	page.MonthCashFlows = months
+	RenderPage(w, http.StatusOK, "solarenergy.html", page)
 }
 
 //Makes a map data structure of all of the City objects.
-func MakeCityMap(filename string) map[string]City {
-	lines := ReadFile(filename)
+func MakeCityMap(records []Record) map[string]City {
 	cityData := make(map[string]City)
-	for i := 0; i < len(lines); i++ {
-		var items []string = strings.Split(lines[i], ",")
-		cityName := items[0]
-		cityData[cityName] = MakeCity(cityData, items)
+	for _, record := range records {
+		cityData[record.Key(CitySchema)] = MakeCity(record)
 	}
-	delete(cityData, "")
 	return cityData
 }
 
 //Creates a City object with its characteristics using City struct.
-func MakeCity(cityData map[string]City, items []string) City {
+func MakeCity(record Record) City {
 	var city City
-	city.coordN, _ = strconv.ParseFloat(items[1], 64)
-	city.coordW, _ = strconv.ParseFloat(items[2], 64)
-	city.temp, _ = strconv.ParseFloat(items[3], 64)
-	city.solarRad, _ = strconv.ParseFloat(items[4], 64)
-	city.optAng, _ = strconv.ParseFloat(items[5], 64)
-	city.optRad, _ = strconv.ParseFloat(items[6], 64)
-	city.avgEnergy, _ = strconv.ParseFloat(items[7], 64)
-	city.instCost, _ = strconv.ParseFloat(items[8], 64)
-	var companyNames []string = strings.Split(items[9], ";")
-	for i := range companyNames {
-		city.companies = append(city.companies, companyNames[i])
+	city.lat = record.Numbers["lat"]
+	city.lon = record.Numbers["lon"]
+	city.temp = record.Numbers["temp"]
+	if recordLow, ok := record.Numbers["record_low"]; ok {
+		city.recordLow = recordLow
+	} else {
+		city.recordLow, city.recordLowEstimated = EstimateRecordLow(city.temp), true
 	}
+	city.solarRad = record.Numbers["solar_rad"]
+	city.optAng = record.Numbers["opt_angle"]
+	city.optRad = record.Numbers["opt_rad"]
+	city.avgEnergy = record.Numbers["avg_energy"]
+	city.instCost = record.Numbers["inst_cost"]
+	city.companies = record.Lists["companies"]
+	city.export = record.Text["export_policy"]
+	city.state = record.Text["state"]
 	return city
 }
 
 //Make the map data structure of all of the different solar panel brands.
-func MakeSolarMap(filename string) map[string]Panel {
-	lines := ReadFile(filename)
+func MakeSolarMap(records []Record) map[string]Panel {
 	solarPanels := make(map[string]Panel)
-	for i := 0; i < len(lines); i++ {
-		var items []string = strings.Split(lines[i], ",")
-		panelName := items[0]
-		solarPanels[panelName] = MakePanel(solarPanels, items)
-	}
-	keys := make([]string, 0)
-	for key, _ := range solarPanels {
-		keys = append(keys, key)
+	for _, record := range records {
+		solarPanels[record.Key(PanelSchema)] = MakePanel(record)
 	}
 	return solarPanels
 }
 
 //Make a Solar Panel object using Panel struct.
-func MakePanel(solarPanels map[string]Panel, items []string) Panel {
+func MakePanel(record Record) Panel {
 	var panel Panel
-	panel.efficiency, _ = strconv.ParseFloat(items[1], 64)
-	panel.watts, _ = strconv.ParseFloat(items[2], 64)
-	panel.area, _ = strconv.ParseFloat(items[3], 64)
-	panel.price, _ = strconv.ParseFloat(items[4], 64)
-	return panel
-}
-
-//Finds the city closest to their coordinates.
-func ClosestCity(cityData map[string]City, userCoordN, userCoordW float64) string {
-	distance := 10000.00
-	closestCityName := ""
-	var cityDistance float64
-	if userCoordN < 0 {
-		userCoordN *= -1
+	panel.manufacturer = record.Text["manufacturer"]
+	panel.model = record.Text["model"]
+	panel.technology = record.Text["technology"]
+	panel.efficiency = record.Numbers["efficiency"]
+	panel.watts = record.Numbers["watts"]
+	panel.ptcWatts = record.Numbers["ptc_watts"]
+	panel.area = record.Numbers["area"]
+	panel.length = record.Numbers["length"]
+	panel.width = record.Numbers["width"]
+	panel.tempCoeff, panel.noct = DefaultTempCoeff, DefaultNOCT
+	if tempCoeff, ok := record.Numbers["temp_coeff"]; ok {
+		panel.tempCoeff = tempCoeff
 	}
-	if userCoordW < 0 {
-		userCoordW *= -1
+	if noct, ok := record.Numbers["noct"]; ok {
+		panel.noct = noct
 	}
-	for city, data := range cityData {
-		cityDistance = math.Sqrt(math.Pow((userCoordN-data.coordN), 2) + math.Pow((userCoordW-data.coordW), 2))
-		if cityDistance < distance {
-			distance = cityDistance
-			closestCityName = city
-		}
+	panel.voc = record.Numbers["voc"]
+	panel.vmp = record.Numbers["vmp"]
+	panel.isc = record.Numbers["isc"]
+	panel.imp = record.Numbers["imp"]
+	panel.vocCoeff = DefaultVocCoeff
+	if vocCoeff, ok := record.Numbers["voc_coeff"]; ok {
+		panel.vocCoeff = vocCoeff
 	}
-	return closestCityName
+	panel.iscCoeff = record.Numbers["isc_coeff"]
+	panel.bifaciality = record.Numbers["bifaciality"]
+	panel.source = record.Text["source"]
+	panel.version = record.Text["version"]
+	return panel
 }
 
-//Calculates expected generated energy from solar panels. (in kwh per month)
-func SolarOutput(cityName string, cityData map[string]City, angleType string, efficiency, houseSize float64) float64 {
-	var radiation float64
-	//241.5479 meters squared as solar panel area (average house size)
-	//assume standard 15% efficiency
-	//0.75 default performance ratio
-	// E = Solar Panel Area * solar panel efficiency * radiation * performance ratio
-	if angleType == "horizontal" {
-		radiation = cityData[cityName].solarRad
-	} else if angleType == "optimal" {
-		radiation = cityData[cityName].optRad
+//Calculates expected generated energy from solar panels facing any plane,
+//such as lying on the user's roof, after the system's heat loss, loss chain
+//and inverter. (in kwh per month) The radiation on the plane is transposed
+//from the radiation on a flat surface each month.
+func PlaneOutput(cityName string, cityData map[string]City, plane Plane, system System, houseSize float64) float64 {
+	city := cityData[cityName]
+	radiation := PlaneRadiation(city, plane)
+	yearly := MonthAverage(radiation)
+	if plane.Tilt == 0 {
+		yearly = city.solarRad //the measured yearly value
 	}
+	return PanelEnergy(yearly, system.Efficiency, houseSize) * YearlyFactor(radiation, MonthlyFlows(city, plane, system))
+}
+
+//Gives the energy output in kwh per month for a radiation (in kwh/m² a day),
+//panel efficiency (in %) and area in square feet, before any losses.
+func PanelEnergy(radiation, efficiency, houseSize float64) float64 {
 	houseSize *= 0.092903 //convert square feet to square meters
-	energyOutput := houseSize * efficiency * radiation * 0.75
+	energyOutput := houseSize * efficiency / 100 * radiation * 365
 	return energyOutput / 12
 }
 
-//Gives the potential optimal energy output for their home.
+//Gives the potential optimal energy output for their home, with panels at
+//the city's optimal angle and its measured radiation at that angle, after
+//the heat loss, the default loss chain (see losses.go) and clipping at the
+//default DC/AC ratio. (in kwh per month)
 func OptEnergy(cityData map[string]City, cityName string, efficiency, houseSize float64) float64 {
-	optOutput := SolarOutput(cityName, cityData, "optimal", efficiency, houseSize)
-	return optOutput
+	city := cityData[cityName]
+	plane := Plane{Tilt: city.optAng, Azimuth: EquatorAzimuth(city.lat), Model: DefaultTransposition}
+	system := DefaultSystem()
+	system.Efficiency = efficiency
+	return PanelEnergy(city.optRad, efficiency, houseSize) * YearlyFactor(city.monthlyOptRad, MonthlyFlows(city, plane, system))
 }
 
 //Output the optimal angle that they should use to get the optimal output.
@@ -354,17 +466,9 @@ func InstallationCost(cityData map[string]City, cityName string) float64 {
 	return cityData[cityName].instCost * 5000
 }
 
-//Calculates the number of solar panels needed on their house.
-func NumSolarPanels(energyOutput, houseSize float64, cityData map[string]City, panelName, cityName string, solarPanels map[string]Panel) int {
-	houseSize *= 0.092903 //convert square feet to square meters
-	oneSolarPanelOutput := (energyOutput * 12 / houseSize) * solarPanels[panelName].area
-	numPanels := cityData[cityName].avgEnergy / oneSolarPanelOutput
-	return int(numPanels)
-}
-
-//Calculates how much it would cost for user to get that brand of solar panels on their house.
-func SolarPanelCost(energyOutput, houseSize float64, cityData map[string]City, panelName, cityName string, solarPanels map[string]Panel, numPanels int) float64 {
-	houseSize *= 0.092903 //convert square feet to square meters
+//Calculates how much it would cost for user to get a number of panels of
+//that brand on their house: the panels' price sheet price plus installation.
+func SolarPanelCost(cityData map[string]City, panelName, cityName string, solarPanels map[string]Panel, numPanels int) float64 {
 	cost := solarPanels[panelName].price * float64(numPanels)
 	return cost + InstallationCost(cityData, cityName)
 }
@@ -376,115 +480,95 @@ func Companies(cityName string, cityData map[string]City) []string {
 	return companyNames
 }
 
-//Calculates the cost and number of panels required for each brand of solar panel.
-//0: Suntech, 1: Samsung, 2: Kyocera, 3: Canadian Solar, 4: Grape Solar 390W, 5: Grape Solar 250
-func CalcCostBrand(energyOutput, houseSize float64, cityData map[string]City, cityName string, solarPanels map[string]Panel) ([]int, []int) {
-	NumPanels := make([]int, 6)
-	PanelCosts := make([]int, 6)
-	NumPanels[0] = NumSolarPanels(energyOutput, houseSize, cityData, "Suntech", cityName, solarPanels)
-	NumPanels[1] = NumSolarPanels(energyOutput, houseSize, cityData, "Samsung", cityName, solarPanels)
-	NumPanels[2] = NumSolarPanels(energyOutput, houseSize, cityData, "Kyocera", cityName, solarPanels)
-	NumPanels[3] = NumSolarPanels(energyOutput, houseSize, cityData, "CanadianSolar", cityName, solarPanels)
-	NumPanels[4] = NumSolarPanels(energyOutput, houseSize, cityData, "GrapeSolar390W", cityName, solarPanels)
-	NumPanels[5] = NumSolarPanels(energyOutput, houseSize, cityData, "GrapeSolar250", cityName, solarPanels)
-	PanelCosts[0] = int(SolarPanelCost(energyOutput, houseSize, cityData, "Suntech", cityName, solarPanels, NumPanels[0]))
-	PanelCosts[1] = int(SolarPanelCost(energyOutput, houseSize, cityData, "Samsung", cityName, solarPanels, NumPanels[1]))
-	PanelCosts[2] = int(SolarPanelCost(energyOutput, houseSize, cityData, "Kyocera", cityName, solarPanels, NumPanels[2]))
-	PanelCosts[3] = int(SolarPanelCost(energyOutput, houseSize, cityData, "CanadianSolar", cityName, solarPanels, NumPanels[3]))
-	PanelCosts[4] = int(SolarPanelCost(energyOutput, houseSize, cityData, "GrapeSolar390W", cityName, solarPanels, NumPanels[4]))
-	PanelCosts[5] = int(SolarPanelCost(energyOutput, houseSize, cityData, "GrapeSolar250", cityName, solarPanels, NumPanels[5]))
-	return NumPanels, PanelCosts
-}
-
-//Preferences in a slice, with 0: min cost, 1: max output, 2: max efficiency
-func Preferences(panelCost []int, solarPanels map[string]Panel, cityName string, cityData map[string]City, houseSize float64) []string {
+//Puts the panels of each brand offered on the roof's faces, the best face
+//first, to make the yearly usage of a house of houseSize square feet, and
+//gives how many fit, how many are needed, the cost of the ones that fit and
+//where they go on each face.
+func FitPanels(faces []RoofFace, results []FaceResult, houseSize float64, cityData map[string]City, cityName string, solarPanels map[string]Panel) (map[string]int, map[string]int, map[string]int, map[string][]RoofLayout) {
+	usage := AverageEnergy(cityData, cityName) * houseSize * 12
+	numPanels := make(map[string]int)
+	needed := make(map[string]int)
+	panelCosts := make(map[string]int)
+	layouts := make(map[string][]RoofLayout)
+	for _, name := range MakePanelArray(solarPanels) {
+		layouts[name], numPanels[name], needed[name] = AllocatePanels(faces, results, solarPanels[name], usage)
+		panelCosts[name] = int(SolarPanelCost(cityData, name, cityName, solarPanels, numPanels[name]))
+	}
+	return numPanels, needed, panelCosts, layouts
+}
+
+//Preferences in a slice, with 0: min cost, 1: max output, 2: max efficiency.
+//They are empty if the catalog has no panels.
+func Preferences(panelCost map[string]int, solarPanels map[string]Panel, cityName string, cityData map[string]City, houseSize float64) []string {
 	minCostPanel := FindMinCostPanel(panelCost)
-	efficiencyarray := MakeEfficiencyArray(solarPanels)
-	mostEfficientPanel := FindMostEfficient(efficiencyarray)
-	maxOutput := FindMaxOutput(efficiencyarray, cityName, cityData, houseSize)
+	mostEfficientPanel := FindMostEfficient(solarPanels)
+	maxOutput := FindMaxOutput(solarPanels, cityName, cityData, houseSize)
 	preferences := []string{minCostPanel, maxOutput, mostEfficientPanel}
 	return preferences
 }
 
-//Converts index to panel brand name.
-func IdxToPanel(idx int) string {
-	switch idx {
-	case 0:
-		return "Suntech"
-	case 1:
-		return "Samsung"
-	case 2:
-		return "Kyocera"
-	case 3:
-		return "CanadianSolar"
-	case 4:
-		return "GrapeSolar390W"
-	case 5:
-		return "GrapeSolar250"
-	}
-	return ""
-}
-
-//Puts panel brand efficiencies in an array according to the same indices as above.
-func MakeEfficiencyArray(solarPanels map[string]Panel) []float64 {
-	efficiencyArray := make([]float64, 6)
-	efficiencyArray[0] = solarPanels["Suntech"].efficiency
-	efficiencyArray[1] = solarPanels["Samsung"].efficiency
-	efficiencyArray[2] = solarPanels["Kyocera"].efficiency
-	efficiencyArray[3] = solarPanels["CanadianSolar"].efficiency
-	efficiencyArray[4] = solarPanels["GrapeSolar390W"].efficiency
-	efficiencyArray[5] = solarPanels["GrapeSolar250"].efficiency
-	return efficiencyArray
-}
-
-//Gives the minimum cost panel option.
-func FindMinCostPanel(panelCost []int) string {
-	minCost := panelCost[0]
-	minCostIDX := 0
-	for idx, cost := range panelCost {
-		if cost < minCost {
-			minCost = cost
-			minCostIDX = idx
+//Gives the names of the panels in the catalog that have a price, the ones
+//offered, in alphabetical order, so every list of panels is in the same
+//order whatever order solar.csv is in.
+func MakePanelArray(solarPanels map[string]Panel) []string {
+	panelArray := make([]string, 0, len(solarPanels))
+	for name, panel := range solarPanels {
+		if panel.priced {
+			panelArray = append(panelArray, name)
+		}
+	}
+	sort.Strings(panelArray)
+	return panelArray
+}
+
+//Gives the minimum cost panel option. Ties go to the first brand alphabetically.
+func FindMinCostPanel(panelCost map[string]int) string {
+	minCostPanel := ""
+	for _, name := range SortedKeys(panelCost) {
+		if minCostPanel == "" || panelCost[name] < panelCost[minCostPanel] {
+			minCostPanel = name
 		}
 	}
-	minCostPanel := IdxToPanel(minCostIDX)
 	return minCostPanel
 }
 
-//Finds the brand of solar panel with the highest efficiency.
-func FindMostEfficient(efficiencyarray []float64) string {
-	mostEfficient := efficiencyarray[0]
-	mostEfficientIDX := 0
-	for idx, efficiency := range efficiencyarray {
-		if efficiency > mostEfficient {
-			mostEfficient = efficiency
-			mostEfficientIDX = idx
+//Finds the brand of solar panel with the highest efficiency. Ties go to the
+//first brand alphabetically.
+func FindMostEfficient(solarPanels map[string]Panel) string {
+	mostEfficientPanel := ""
+	for _, name := range MakePanelArray(solarPanels) {
+		if mostEfficientPanel == "" || solarPanels[name].efficiency > solarPanels[mostEfficientPanel].efficiency {
+			mostEfficientPanel = name
 		}
 	}
-	mostEfficientPanel := IdxToPanel(mostEfficientIDX)
 	return mostEfficientPanel
 }
 
-//Finds the panel brand with the highest output of solar energy.
-func FindMaxOutput(efficiencyarray []float64, cityName string, cityData map[string]City, houseSize float64) string {
+//Gives the keys of a map of counts in alphabetical order.
+func SortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Finds the panel brand with the highest output of solar energy, after each
+//brand's heat loss in the city. Ties go to the first brand alphabetically.
+func FindMaxOutput(solarPanels map[string]Panel, cityName string, cityData map[string]City, houseSize float64) string {
 	var maxOutput, output float64
-	var maxIDX int
-	for i := range efficiencyarray {
-		output = SolarOutput(cityName, cityData, "horizontal", efficiencyarray[i], houseSize)
-		if output > maxOutput {
+	maxOutputPanel := ""
+	flat := Plane{Azimuth: EquatorAzimuth(cityData[cityName].lat), Model: DefaultTransposition}
+	for _, name := range MakePanelArray(solarPanels) {
+		panel := solarPanels[name]
+		system := DefaultSystem()
+		system.Efficiency, system.Thermal = panel.efficiency, panel.Thermal()
+		output = PlaneOutput(cityName, cityData, flat, system, houseSize)
+		if maxOutputPanel == "" || output > maxOutput {
 			maxOutput = output
-			maxIDX = i
+			maxOutputPanel = name
 		}
 	}
-	maxOutputPanel := IdxToPanel(maxIDX)
 	return maxOutputPanel
 }
-
-//Error message, error if not nil or less than 0.
-func ErrorMessage(err error, input string, variableInput float64) {
-	if err != nil { //there was a problem
-		fmt.Printf("Error: Number entered for %s was invalid.\n", input)
-	} else if variableInput <= 0 {
-		fmt.Printf("Error: Number entered for %s was less than zero.\n", input)
-	} // else no errors
-}
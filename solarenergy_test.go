@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+//The recommendation follows the share of the usage the output covers.
+func TestIsItOptimal(t *testing.T) {
+	cases := []struct {
+		usage, output float64
+		want          string
+	}{
+		{1000, 500, "is not recommended"},
+		{1000, 600, "is not recommended"},
+		{1000, 700, "is recommended"},
+		{1000, 800, "is highly recommended"},
+		{1000, 920, "is highly recommended"},
+		{1000, 2300, "is highly recommended"},
+	}
+	for _, c := range cases {
+		percentage, got := IsItOptimal(c.usage, c.output)
+		if got != c.want {
+			t.Errorf("%v kwh of %v: %q, want %q", c.output, c.usage, got, c.want)
+		}
+		if percentage != c.output/c.usage {
+			t.Errorf("%v kwh of %v: percentage %v, want %v", c.output, c.usage, percentage, c.output/c.usage)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: Package solarpos works out where the sun is in the sky for any
+place and time, and when it rises, crosses the meridian and sets. It follows
+NREL's Solar Position Algorithm (Reda and Andreas, 2004), which is accurate
+to ±0.0003° between the years -2000 and 6000. Angles are in degrees,
+latitude is positive north and longitude is positive east.*/
+
+package solarpos
+
+import (
+	"math"
+	"time"
+)
+
+//Angles of the sun at rise and set: its radius and the default bending of
+//its light by the atmosphere at the horizon, in degrees.
+const (
+	SunRadius         = 0.26667
+	DefaultRefraction = 0.5667
+)
+
+/*This is an observer struct which stores the place the sun is seen from.
+Pressure (mbar) and Temperature (°C) are used for the bending of sunlight by
+the atmosphere. DeltaT is the difference between terrestrial time and UT in
+seconds; 0 means use EstimateDeltaT.*/
+type Observer struct {
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	Elevation   float64 `json:"elevation_m"`
+	Pressure    float64 `json:"pressure_mbar"`
+	Temperature float64 `json:"temperature_c"`
+	Refraction  float64 `json:"refraction_deg"` //refraction at sunrise and sunset
+	DeltaT      float64 `json:"delta_t_s"`
+}
+
+/*This is a position struct which stores where the sun is. Azimuth is
+clockwise from north. Zenith and Elevation include the bending of sunlight
+by the atmosphere.*/
+type Position struct {
+	Zenith         float64 `json:"zenith_deg"`
+	Azimuth        float64 `json:"azimuth_deg"`
+	Elevation      float64 `json:"elevation_deg"`
+	Declination    float64 `json:"declination_deg"`     //topocentric
+	RightAscension float64 `json:"right_ascension_deg"` //topocentric
+	HourAngle      float64 `json:"hour_angle_deg"`      //topocentric, west of the meridian
+	EquationOfTime float64 `json:"equation_of_time_min"`
+	EarthSunAU     float64 `json:"earth_sun_distance_au"`
+}
+
+/*This is a day struct which stores the sunrise, solar noon and sunset of a
+day, in the time zone of the date asked for. When the sun doesn't rise or
+doesn't set that day, PolarNight or PolarDay is set and Sunrise and Sunset
+are zero.*/
+type Day struct {
+	Sunrise    time.Time
+	SolarNoon  time.Time
+	Sunset     time.Time
+	DayLength  time.Duration
+	PolarDay   bool
+	PolarNight bool
+}
+
+//Makes an observer at sea level with the standard atmosphere.
+func NewObserver(latitude, longitude float64) Observer {
+	return Observer{Latitude: latitude, Longitude: longitude, Pressure: 1013.25, Temperature: 12, Refraction: DefaultRefraction}
+}
+
+//Estimates DeltaT in seconds for a year, from the polynomials of Espenak and
+//Meeus. It is within about a second of the measured value since 1900.
+func EstimateDeltaT(year float64) float64 {
+	switch {
+	case year >= 2005 && year < 2050:
+		t := year - 2000
+		return 62.92 + 0.32217*t + 0.005589*t*t
+	case year >= 1986 && year < 2005:
+		t := year - 2000
+		return 63.86 + 0.3345*t - 0.060374*t*t + 0.0017275*t*t*t + 0.000651814*t*t*t*t + 0.00002373599*t*t*t*t*t
+	case year >= 1961 && year < 1986:
+		t := year - 1975
+		return 45.45 + 1.067*t - t*t/260 - t*t*t/718
+	case year >= 1941 && year < 1961:
+		t := year - 1950
+		return 29.07 + 0.407*t - t*t/233 + t*t*t/2547
+	case year >= 1920 && year < 1941:
+		t := year - 1920
+		return 21.20 + 0.84493*t - 0.076100*t*t + 0.0020936*t*t*t
+	case year >= 1900 && year < 1920:
+		t := year - 1900
+		return -2.79 + 1.494119*t - 0.0598939*t*t + 0.0061966*t*t*t - 0.000197*t*t*t*t
+	case year >= 2050 && year < 2150:
+		u := (year - 1820) / 100
+		return -20 + 32*u*u - 0.5628*(2150-year)
+	}
+	u := (year - 1820) / 100
+	return -20 + 32*u*u
+}
+
+//Gives the Julian day of a time.
+func JulianDay(t time.Time) float64 {
+	t = t.UTC()
+	year, month := t.Year(), int(t.Month())
+	day := float64(t.Day()) + (float64(t.Hour())+(float64(t.Minute())+(float64(t.Second())+float64(t.Nanosecond())/1e9)/60)/60)/24
+	if month < 3 {
+		month += 12
+		year--
+	}
+	jd := math.Floor(365.25*float64(year+4716)) + math.Floor(30.6001*float64(month+1)) + day - 1524.5
+	if jd > 2299160 { //Gregorian calendar
+		a := math.Floor(float64(year) / 100)
+		jd += 2 - a + math.Floor(a/4)
+	}
+	return jd
+}
+
+//Gives where the sun is at a time, seen by an observer.
+func SunPosition(t time.Time, obs Observer) Position {
+	jd := JulianDay(t)
+	g := geocentric(jd, obs.deltaT(t))
+
+	//topocentric coordinates, seen from the observer rather than the earth's center
+	h := limitDegrees(g.nu + obs.Longitude - g.alpha)
+	xi := 8.794 / (3600 * g.r)
+	phi := rad(obs.Latitude)
+	u := math.Atan(0.99664719 * math.Tan(phi))
+	x := math.Cos(u) + obs.Elevation/6378140*math.Cos(phi)
+	y := 0.99664719*math.Sin(u) + obs.Elevation/6378140*math.Sin(phi)
+	deltaAlpha := math.Atan2(-x*math.Sin(rad(xi))*math.Sin(rad(h)), math.Cos(rad(g.delta))-x*math.Sin(rad(xi))*math.Cos(rad(h)))
+	deltaPrime := math.Atan2((math.Sin(rad(g.delta))-y*math.Sin(rad(xi)))*math.Cos(deltaAlpha),
+		math.Cos(rad(g.delta))-x*math.Sin(rad(xi))*math.Cos(rad(h)))
+	hPrime := h - deg(deltaAlpha)
+
+	//elevation, corrected for the bending of sunlight by the atmosphere
+	e0 := deg(math.Asin(math.Sin(phi)*math.Sin(deltaPrime) + math.Cos(phi)*math.Cos(deltaPrime)*math.Cos(rad(hPrime))))
+	var refraction float64
+	if e0 >= -(SunRadius + obs.Refraction) {
+		refraction = obs.Pressure / 1010 * 283 / (273 + obs.Temperature) * 1.02 / (60 * math.Tan(rad(e0+10.3/(e0+5.11))))
+	}
+	elevation := e0 + refraction
+
+	//azimuth measured from south, westward, turned to clockwise from north
+	gamma := deg(math.Atan2(math.Sin(rad(hPrime)), math.Cos(rad(hPrime))*math.Sin(phi)-math.Tan(deltaPrime)*math.Cos(phi)))
+
+	return Position{
+		Zenith:         90 - elevation,
+		Azimuth:        limitDegrees(gamma + 180),
+		Elevation:      elevation,
+		Declination:    deg(deltaPrime),
+		RightAscension: limitDegrees(g.alpha + deg(deltaAlpha)),
+		HourAngle:      limitDegrees(hPrime),
+		EquationOfTime: equationOfTime(g),
+		EarthSunAU:     g.r,
+	}
+}
+
+//Gives the angle between the sun and the normal of a surface tilted tilt
+//degrees from horizontal and facing azimuth degrees clockwise from north.
+func Incidence(pos Position, tilt, azimuth float64) float64 {
+	z, beta := rad(pos.Zenith), rad(tilt)
+	cosI := math.Cos(z)*math.Cos(beta) + math.Sin(z)*math.Sin(beta)*math.Cos(rad(pos.Azimuth-azimuth))
+	return deg(math.Acos(math.Max(-1, math.Min(1, cosI))))
+}
+
+//Gives the sunrise, solar noon and sunset of the local calendar day of date
+//(in date's time zone), seen by an observer.
+func SunTimes(date time.Time, obs Observer) Day {
+	loc := date.Location()
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	_, offset := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, loc).Zone()
+	timeZone := float64(offset) / 3600
+
+	//sun's position at 0 UT on the day before, the day and the day after
+	jd0 := JulianDay(midnight)
+	nu := geocentric(jd0, 0).nu
+	var alpha, delta [3]float64
+	for i := range alpha {
+		g := geocentric(jd0+float64(i-1), 0)
+		alpha[i], delta[i] = g.alpha, g.delta
+	}
+
+	h0Prime := -(SunRadius + obs.Refraction)
+	phi := rad(obs.Latitude)
+	transit := limitZeroToOne((alpha[1] - obs.Longitude - nu) / 360)
+	cosH0 := (math.Sin(rad(h0Prime)) - math.Sin(phi)*math.Sin(rad(delta[1]))) / (math.Cos(phi) * math.Cos(rad(delta[1])))
+
+	//corrects an approximate time (fraction of the day) with the sun's motion during the day
+	dayFraction := func(m float64) (hPrime, altitude, declination float64) {
+		n := m + obs.deltaT(midnight)/86400
+		alphaPrime := interpolate(alpha, n)
+		declination = interpolate(delta, n)
+		hPrime = limitDegrees180pm(nu + 360.985647*m + obs.Longitude - alphaPrime)
+		altitude = deg(math.Asin(math.Sin(phi)*math.Sin(rad(declination)) + math.Cos(phi)*math.Cos(rad(declination))*math.Cos(rad(hPrime))))
+		return
+	}
+	toTime := func(fraction float64) time.Time {
+		hours := 24 * limitZeroToOne(fraction+timeZone/24)
+		local := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.FixedZone("", offset))
+		return local.Add(time.Duration(hours * float64(time.Hour))).In(loc)
+	}
+
+	var day Day
+	hTransit, _, _ := dayFraction(transit)
+	day.SolarNoon = toTime(transit - hTransit/360)
+	if math.Abs(cosH0) > 1 {
+		//the sun is above (or below) the rise and set altitude all day
+		_, noonAltitude, _ := dayFraction(transit)
+		day.PolarDay = noonAltitude > h0Prime
+		day.PolarNight = !day.PolarDay
+		if day.PolarDay {
+			day.DayLength = 24 * time.Hour
+		}
+		return day
+	}
+	h0 := deg(math.Acos(cosH0)) / 360
+	var times [2]float64
+	for i, m := range []float64{limitZeroToOne(transit - h0), limitZeroToOne(transit + h0)} {
+		hPrime, altitude, declination := dayFraction(m)
+		times[i] = m + (altitude-h0Prime)/(360*math.Cos(rad(declination))*math.Cos(phi)*math.Sin(rad(hPrime)))
+	}
+	day.Sunrise, day.Sunset = toTime(times[0]), toTime(times[1])
+	day.DayLength = time.Duration((times[1] - times[0]) * 24 * float64(time.Hour))
+	if day.DayLength < 0 {
+		day.DayLength += 24 * time.Hour
+	}
+	return day
+}
+
+/*This is the sun's position seen from the earth's center.*/
+type geocentricSun struct {
+	jme      float64 //Julian ephemeris millennium
+	r        float64 //earth-sun distance, AU
+	alpha    float64 //right ascension
+	delta    float64 //declination
+	nu       float64 //apparent sidereal time at Greenwich
+	deltaPsi float64 //nutation in longitude
+	epsilon  float64 //true obliquity of the ecliptic
+}
+
+//Works out the geocentric sun for a Julian day and DeltaT in seconds.
+func geocentric(jd, deltaT float64) geocentricSun {
+	jde := jd + deltaT/86400
+	jc := (jd - 2451545) / 36525
+	jce := (jde - 2451545) / 36525
+	jme := jce / 10
+
+	l := limitDegrees(deg(earthValue(lTerms, jme)))
+	b := deg(earthValue(bTerms, jme))
+	r := earthValue(rTerms, jme)
+	theta := limitDegrees(l + 180)
+	beta := -b
+
+	//nutation
+	x := [5]float64{
+		297.85036 + 445267.111480*jce - 0.0019142*jce*jce + jce*jce*jce/189474,
+		357.52772 + 35999.050340*jce - 0.0001603*jce*jce - jce*jce*jce/300000,
+		134.96298 + 477198.867398*jce + 0.0086972*jce*jce + jce*jce*jce/56250,
+		93.27191 + 483202.017538*jce - 0.0036825*jce*jce + jce*jce*jce/327270,
+		125.04452 - 1934.136261*jce + 0.0020708*jce*jce + jce*jce*jce/450000,
+	}
+	var deltaPsi, deltaEpsilon float64
+	for i, y := range yTerms {
+		var arg float64
+		for j := range x {
+			arg += x[j] * y[j]
+		}
+		deltaPsi += (peTerms[i][0] + peTerms[i][1]*jce) * math.Sin(rad(arg))
+		deltaEpsilon += (peTerms[i][2] + peTerms[i][3]*jce) * math.Cos(rad(arg))
+	}
+	deltaPsi /= 36000000
+	deltaEpsilon /= 36000000
+
+	u := jme / 10
+	epsilon0 := 84381.448 + u*(-4680.93+u*(-1.55+u*(1999.25+u*(-51.38+u*(-249.67+u*(-39.05+u*(7.12+u*(27.87+u*(5.79+u*2.45)))))))))
+	epsilon := epsilon0/3600 + deltaEpsilon
+
+	lambda := theta + deltaPsi - 20.4898/(3600*r)
+	nu0 := limitDegrees(280.46061837 + 360.98564736629*(jd-2451545) + jc*jc*(0.000387933-jc/38710000))
+	nu := nu0 + deltaPsi*math.Cos(rad(epsilon))
+
+	alpha := limitDegrees(deg(math.Atan2(math.Sin(rad(lambda))*math.Cos(rad(epsilon))-math.Tan(rad(beta))*math.Sin(rad(epsilon)), math.Cos(rad(lambda)))))
+	delta := deg(math.Asin(math.Sin(rad(beta))*math.Cos(rad(epsilon)) + math.Cos(rad(beta))*math.Sin(rad(epsilon))*math.Sin(rad(lambda))))
+	return geocentricSun{jme: jme, r: r, alpha: alpha, delta: delta, nu: nu, deltaPsi: deltaPsi, epsilon: epsilon}
+}
+
+//Sums a set of periodic terms: each series is Σ A*cos(B + C*JME), and the
+//series are added up as a polynomial in JME. The result is divided by 10^8.
+func earthValue(terms [][][3]float64, jme float64) float64 {
+	var total float64
+	for i := len(terms) - 1; i >= 0; i-- {
+		var sum float64
+		for _, term := range terms[i] {
+			sum += term[0] * math.Cos(term[1]+term[2]*jme)
+		}
+		total = total*jme + sum
+	}
+	return total / 1e8
+}
+
+//Gives the equation of time in minutes: how far sundial time is ahead of
+//mean solar time.
+func equationOfTime(g geocentricSun) float64 {
+	jme := g.jme
+	m := limitDegrees(280.4664567 + 360007.6982779*jme + 0.03032028*jme*jme + jme*jme*jme/49931 - jme*jme*jme*jme/15300 - jme*jme*jme*jme*jme/2000000)
+	minutes := 4 * (m - 0.0057183 - g.alpha + g.deltaPsi*math.Cos(rad(g.epsilon)))
+	if minutes > 20 {
+		minutes -= 1440
+	} else if minutes < -20 {
+		minutes += 1440
+	}
+	return minutes
+}
+
+//Gives DeltaT for a time, estimated if the observer doesn't set it.
+func (obs Observer) deltaT(t time.Time) float64 {
+	if obs.DeltaT != 0 {
+		return obs.DeltaT
+	}
+	return EstimateDeltaT(float64(t.Year()) + (float64(t.YearDay())-0.5)/365.25)
+}
+
+//Interpolates the sun's right ascension or declination on the day before,
+//the day and the day after to a fraction n of the day.
+func interpolate(values [3]float64, n float64) float64 {
+	a := values[1] - values[0]
+	b := values[2] - values[1]
+	if math.Abs(a) >= 2 {
+		a = limitZeroToOne(a)
+	}
+	if math.Abs(b) >= 2 {
+		b = limitZeroToOne(b)
+	}
+	return values[1] + n*(a+b+(b-a)*n)/2
+}
+
+func rad(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+func deg(radians float64) float64 { return radians * 180 / math.Pi }
+
+//Limits an angle to 0 to 360 degrees.
+func limitDegrees(degrees float64) float64 {
+	return degrees - 360*math.Floor(degrees/360)
+}
+
+//Limits an angle to -180 to 180 degrees.
+func limitDegrees180pm(degrees float64) float64 {
+	degrees = limitDegrees(degrees)
+	if degrees > 180 {
+		degrees -= 360
+	}
+	return degrees
+}
+
+//Keeps the fractional part of a number, from 0 to 1.
+func limitZeroToOne(value float64) float64 {
+	return value - math.Floor(value)
+}
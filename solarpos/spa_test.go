@@ -0,0 +1,83 @@
+package solarpos
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+//Gives the hours since midnight of a time.
+func hours(when time.Time) float64 {
+	return float64(when.Hour()) + float64(when.Minute())/60 + (float64(when.Second())+float64(when.Nanosecond())/1e9)/3600
+}
+
+//The example in NREL's Solar Position Algorithm report (Reda and Andreas,
+//2004, table A5.1).
+func TestReferenceExample(t *testing.T) {
+	when := time.Date(2003, 10, 17, 12, 30, 30, 0, time.FixedZone("", -7*3600))
+	obs := Observer{Latitude: 39.742476, Longitude: -105.1786, Elevation: 1830.14,
+		Pressure: 820, Temperature: 11, Refraction: DefaultRefraction, DeltaT: 67}
+	pos := SunPosition(when, obs)
+	day := SunTimes(when, obs)
+	if day.PolarDay || day.PolarNight {
+		t.Fatalf("got polar day %v, polar night %v, want a sunrise and sunset", day.PolarDay, day.PolarNight)
+	}
+	cases := []struct {
+		name                 string
+		got, want, tolerance float64
+	}{
+		{"julian day", JulianDay(when), 2452930.312847, 0.000001},
+		{"zenith", pos.Zenith, 50.11162, 0.00001},
+		{"azimuth", pos.Azimuth, 194.34024, 0.00001},
+		{"incidence", Incidence(pos, 30, 180-10), 25.18700, 0.00001},
+		{"equation of time", pos.EquationOfTime, 14.641503, 0.0001},
+		{"sunrise", hours(day.Sunrise), 6.212067, 0.0003}, //one second
+		{"solar noon", hours(day.SolarNoon), 11.768045, 0.0003},
+		{"sunset", hours(day.Sunset), 17.338667, 0.0003},
+	}
+	for _, c := range cases {
+		if math.Abs(c.got-c.want) > c.tolerance {
+			t.Errorf("%s = %.6f, want %.6f ± %g", c.name, c.got, c.want, c.tolerance)
+		}
+	}
+}
+
+//The sun doesn't set in Tromsø at midsummer or rise at midwinter, and the
+//seasons are the other way around at McMurdo Station.
+func TestPolarDays(t *testing.T) {
+	tromso := NewObserver(69.6492, 18.9553)
+	mcmurdo := NewObserver(-77.846, 166.676)
+	cases := []struct {
+		name       string
+		obs        Observer
+		date       time.Time
+		polarDay   bool
+		polarNight bool
+	}{
+		{"Tromsø in June", tromso, time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC), true, false},
+		{"Tromsø in December", tromso, time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC), false, true},
+		{"McMurdo in December", mcmurdo, time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC), true, false},
+		{"McMurdo in June", mcmurdo, time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC), false, true},
+	}
+	for _, c := range cases {
+		day := SunTimes(c.date, c.obs)
+		if day.PolarDay != c.polarDay || day.PolarNight != c.polarNight {
+			t.Errorf("%s: polar day %v, polar night %v, want %v, %v", c.name, day.PolarDay, day.PolarNight, c.polarDay, c.polarNight)
+			continue
+		}
+		if !day.Sunrise.IsZero() || !day.Sunset.IsZero() {
+			t.Errorf("%s: sunrise %v and sunset %v, want none", c.name, day.Sunrise, day.Sunset)
+		}
+		want := time.Duration(0)
+		if c.polarDay {
+			want = 24 * time.Hour
+		}
+		if day.DayLength != want {
+			t.Errorf("%s: day length %v, want %v", c.name, day.DayLength, want)
+		}
+		noon := SunPosition(day.SolarNoon, c.obs)
+		if (noon.Elevation > 0) != c.polarDay {
+			t.Errorf("%s: sun at %.2f° at solar noon", c.name, noon.Elevation)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file sizes the strings of panels wired in series to an
+inverter's MPPT inputs. A string's voltage is the sum of its panels'
+voltages, and it rises as the cells get colder, so it has to stay under the
+inverter's highest DC voltage on the coldest morning on record and inside
+the MPPT window from a hot afternoon to that cold morning. Strings wired in
+parallel on one input add their currents, which have to stay under what the
+input takes. It lists the combinations of panels per string and strings per
+input with the limits each one breaks, and lays a number of panels out on a
+number of inverters.*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+//The Voc temperature coefficient of panels that don't give their own,
+//typical of crystalline silicon panels.
+const DefaultVocCoeff = -0.30 //% of Voc for each °C
+
+//What a string's short-circuit current is multiplied by for sunlight
+//brighter than the rated 1000 W/m² (NEC 690.8), and what an inverter's
+//short-circuit limit is when it doesn't give one.
+const StringCurrentFactor = 1.25
+
+//How much warmer the air is on a hot afternoon than the city's mean
+//temperature, in °C, and the sunlight on the panels then in W/m². This is
+//when the string voltage is lowest.
+const (
+	HotDayWarming  = 20.0
+	HotDaySunlight = 1000.0
+)
+
+//Kinds of problem with a string.
+const (
+	StringOverVoltage    = "over_voltage"    //open-circuit voltage on the coldest morning is above the inverter's highest DC voltage
+	StringOverCurrent    = "over_current"    //short-circuit current is above what the input takes
+	StringBelowMPPT      = "below_mppt"      //voltage on a hot afternoon is below the MPPT window
+	StringAboveMPPT      = "above_mppt"      //voltage on the coldest morning is above the MPPT window
+	StringCurrentLimited = "current_limited" //current is above what the MPPT uses, so the rest is lost
+)
+
+//Estimates a city's record low in °F from its mean temperature when
+//energy.csv doesn't give one. It errs on the cold side, which gives higher
+//string voltages.
+func EstimateRecordLow(temp float64) float64 {
+	return 2.1*temp - 140
+}
+
+/*
+This is the struct storing the temperatures strings are sized for. The
+panels are at the air temperature on the coldest morning, before the sun
+has warmed them.
+*/
+type DesignTemps struct {
+	ColdC         float64 `json:"cold_c"`                   //lowest temperature on record
+	ColdEstimated bool    `json:"cold_estimated,omitempty"` //the record low was estimated from the mean temperature
+	HotAirC       float64 `json:"hot_air_c"`                //air temperature on a hot afternoon
+}
+
+//Gives the temperatures a city's strings are sized for.
+func (city City) DesignTemps() DesignTemps {
+	return DesignTemps{
+		ColdC:         math.Round(FahrenheitToCelsius(city.recordLow)*10) / 10,
+		ColdEstimated: city.recordLowEstimated,
+		HotAirC:       math.Round((FahrenheitToCelsius(city.temp)+HotDayWarming)*10) / 10,
+	}
+}
+
+//Tells whether a panel's voltages and currents are known, so its strings
+//can be sized.
+func (panel Panel) HasElectrical() bool {
+	return panel.voc > 0 && panel.vmp > 0 && panel.isc > 0 && panel.imp > 0
+}
+
+//Checks what the schema can't: that a panel gives all of its voltages and
+//currents or none of them, and that its maximum power voltage and current
+//are below its open-circuit voltage and short-circuit current.
+func CheckPanels(records []Record, filename string, diagnostics *Diagnostics) {
+	for _, record := range records {
+		given := 0
+		for _, name := range []string{"voc", "vmp", "isc", "imp"} {
+			if _, ok := record.Numbers[name]; ok {
+				given++
+			}
+		}
+		if given == 0 {
+			continue
+		}
+		if given < 4 {
+			diagnostics.Add(filename, record.Line, "", "voc, vmp, isc and imp must be given together")
+			continue
+		}
+		if record.Numbers["vmp"] >= record.Numbers["voc"] {
+			diagnostics.Add(filename, record.Line, "vmp", "must be below voc")
+		}
+		if record.Numbers["imp"] >= record.Numbers["isc"] {
+			diagnostics.Add(filename, record.Line, "imp", "must be below isc")
+		}
+	}
+}
+
+/*
+This is one problem with a string. Limit problems can damage the inverter
+and rule the string out; the others only cost energy.
+*/
+type StringProblem struct {
+	Code    string `json:"code"`
+	Limit   bool   `json:"limit"`
+	Message string `json:"message"`
+}
+
+/*
+This is one way of wiring an MPPT input: a number of panels in each string
+and a number of strings in parallel, with the string's voltages and the
+input's currents at the design temperatures.
+*/
+type StringOption struct {
+	Modules  int             `json:"modules_per_string"`
+	Strings  int             `json:"strings_per_mppt"`
+	VocCold  float64         `json:"voc_cold_v"` //string open-circuit voltage on the coldest morning
+	VmpCold  float64         `json:"vmp_cold_v"` //string voltage at maximum power on the coldest morning
+	VmpHot   float64         `json:"vmp_hot_v"`  //string voltage at maximum power on a hot afternoon
+	IscAmps  float64         `json:"isc_a"`      //input short-circuit current, with StringCurrentFactor
+	ImpAmps  float64         `json:"imp_a"`      //input current at maximum power
+	Problems []StringProblem `json:"problems,omitempty"`
+}
+
+//Tells whether an option breaks no limit and loses no energy.
+func (option StringOption) Valid() bool {
+	return len(option.Problems) == 0
+}
+
+//Tells whether an option breaks no limit, though it may lose energy.
+func (option StringOption) Usable() bool {
+	for _, problem := range option.Problems {
+		if problem.Limit {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+This is the string design for one panel on one inverter: one panel's
+voltages and currents at the design temperatures, and every option near the
+inverter's limits. Micro inverters have one panel on each input.
+*/
+type StringDesign struct {
+	Panel    string `json:"panel,omitempty"`
+	Inverter string `json:"inverter,omitempty"`
+	DesignTemps
+	HotCellC float64        `json:"hot_cell_c"`  //cell temperature on a hot afternoon
+	VocCold  float64        `json:"voc_cold_v"`  //one panel's open-circuit voltage on the coldest morning
+	VmpCold  float64        `json:"vmp_cold_v"`  //one panel's voltage at maximum power on the coldest morning
+	VmpHot   float64        `json:"vmp_hot_v"`   //one panel's voltage at maximum power on a hot afternoon
+	IscAmps  float64        `json:"isc_a"`       //one panel's short-circuit current, with StringCurrentFactor
+	ImpAmps  float64        `json:"imp_a"`       //one panel's current at maximum power
+	Inputs   int            `json:"mppt_inputs"` //MPPT inputs on each inverter
+	Options  []StringOption `json:"options"`
+	inverter Inverter
+}
+
+//Designs the strings for a panel on an inverter. The voltage at maximum
+//power changes with temperature by about the panel's power temperature
+//coefficient. For string and hybrid inverters the options go from one panel
+//too few for the MPPT window to one too many for the highest DC voltage,
+//and from one string to one more than the input's current allows.
+func DesignStrings(panel Panel, inverter Inverter, temps DesignTemps) StringDesign {
+	design := StringDesign{DesignTemps: temps, Inputs: inverter.mpptCount, inverter: inverter}
+	design.HotCellC = math.Round(CellTemperature(temps.HotAirC, HotDaySunlight, panel.noct)*10) / 10
+	design.VocCold = panel.voc * (1 + panel.vocCoeff/100*(temps.ColdC-25))
+	design.VmpCold = panel.vmp * (1 + panel.tempCoeff/100*(temps.ColdC-25))
+	design.VmpHot = panel.vmp * (1 + panel.tempCoeff/100*(design.HotCellC-25))
+	design.IscAmps = panel.isc * StringCurrentFactor
+	design.ImpAmps = panel.imp
+	if inverter.kind == InverterMicro {
+		design.Inputs = inverter.modules
+		design.Options = []StringOption{design.Option(1, 1)}
+	} else {
+		fewest := int(math.Ceil(inverter.mpptMinVolts/design.VmpHot)) - 1
+		most := int(inverter.maxDCVolts/design.VocCold) + 1
+		parallel := int(inverter.maxIscAmps/design.IscAmps) + 1
+		for modules := int(math.Max(1, float64(fewest))); modules <= most; modules++ {
+			for count := 1; count <= parallel; count++ {
+				design.Options = append(design.Options, design.Option(modules, count))
+			}
+		}
+	}
+	design.VocCold = math.Round(design.VocCold*100) / 100
+	design.VmpCold = math.Round(design.VmpCold*100) / 100
+	design.VmpHot = math.Round(design.VmpHot*100) / 100
+	design.IscAmps = math.Round(design.IscAmps*100) / 100
+	return design
+}
+
+//Works out the voltages and currents of an MPPT input with strings of a
+//number of panels in parallel, and the inverter limits they break.
+func (design StringDesign) Option(modules, count int) StringOption {
+	inverter := design.inverter
+	option := StringOption{Modules: modules, Strings: count}
+	option.VocCold = design.VocCold * float64(modules)
+	option.VmpCold = design.VmpCold * float64(modules)
+	option.VmpHot = design.VmpHot * float64(modules)
+	option.IscAmps = design.IscAmps * float64(count)
+	option.ImpAmps = design.ImpAmps * float64(count)
+	if option.VocCold > inverter.maxDCVolts {
+		option.Problems = append(option.Problems, StringProblem{StringOverVoltage, true,
+			fmt.Sprintf("Voc is %.0f V at %.0f°C, above the inverter's %.0f V.", option.VocCold, design.ColdC, inverter.maxDCVolts)})
+	}
+	if option.IscAmps > inverter.maxIscAmps {
+		option.Problems = append(option.Problems, StringProblem{StringOverCurrent, true,
+			fmt.Sprintf("Isc is %.1f A, above the input's %.1f A.", option.IscAmps, inverter.maxIscAmps)})
+	}
+	if option.VmpHot < inverter.mpptMinVolts {
+		option.Problems = append(option.Problems, StringProblem{StringBelowMPPT, false,
+			fmt.Sprintf("Vmp is %.0f V at a %.0f°C cell temperature, below the MPPT window's %.0f V.", option.VmpHot, design.HotCellC, inverter.mpptMinVolts)})
+	}
+	if option.VmpCold > inverter.mpptMaxVolts {
+		option.Problems = append(option.Problems, StringProblem{StringAboveMPPT, false,
+			fmt.Sprintf("Vmp is %.0f V at %.0f°C, above the MPPT window's %.0f V.", option.VmpCold, design.ColdC, inverter.mpptMaxVolts)})
+	}
+	if option.ImpAmps > inverter.maxInputAmps {
+		option.Problems = append(option.Problems, StringProblem{StringCurrentLimited, false,
+			fmt.Sprintf("Imp is %.1f A, above the %.1f A the input uses.", option.ImpAmps, inverter.maxInputAmps)})
+	}
+	option.VocCold = math.Round(option.VocCold*10) / 10
+	option.VmpCold = math.Round(option.VmpCold*10) / 10
+	option.VmpHot = math.Round(option.VmpHot*10) / 10
+	option.IscAmps = math.Round(option.IscAmps*100) / 100
+	option.ImpAmps = math.Round(option.ImpAmps*100) / 100
+	return option
+}
+
+/*
+This is a group of strings of the same length, spread over MPPT inputs
+with up to StringsPerMPPT strings on each.
+*/
+type StringGroup struct {
+	Modules        int `json:"modules_per_string"`
+	Strings        int `json:"strings"`
+	MPPTs          int `json:"mppts"`
+	StringsPerMPPT int `json:"strings_per_mppt"`
+}
+
+/*
+This is how a number of panels are wired to a number of inverters: the
+groups of strings, and the problems they have that only cost energy.
+*/
+type StringLayout struct {
+	Inverters int             `json:"inverters"`
+	Inputs    int             `json:"mppt_inputs"` //MPPT inputs on all of the inverters
+	Groups    []StringGroup   `json:"groups"`
+	Problems  []StringProblem `json:"problems,omitempty"`
+}
+
+//Lays a number of panels out on a number of inverters in as few strings as
+//it can. Every string on an input has the same length, and the lengths of
+//all of the strings differ by at most one panel. Options that lose no
+//energy are used if they can be, and otherwise ones that break no limit.
+//It gives nil if the panels can't be wired to the inverters at all.
+func (design StringDesign) Layout(numPanels, inverters int) *StringLayout {
+	inputs := design.Inputs * inverters
+	for _, strict := range []bool{true, false} {
+		parallel := make(map[int]int) //the most strings on an input for each string length
+		for _, option := range design.Options {
+			if (strict && option.Valid()) || (!strict && option.Usable()) {
+				if option.Strings > parallel[option.Modules] {
+					parallel[option.Modules] = option.Strings
+				}
+			}
+		}
+		for count := 1; count <= numPanels; count++ {
+			short, long := numPanels/count, numPanels%count //long strings have one more panel
+			if parallel[short] == 0 || (long > 0 && parallel[short+1] == 0) {
+				continue
+			}
+			groups := make([]StringGroup, 0, 2)
+			used := 0
+			for _, group := range []StringGroup{{Modules: short + 1, Strings: long}, {Modules: short, Strings: count - long}} {
+				if group.Strings == 0 {
+					continue
+				}
+				group.MPPTs = (group.Strings + parallel[group.Modules] - 1) / parallel[group.Modules]
+				group.StringsPerMPPT = (group.Strings + group.MPPTs - 1) / group.MPPTs
+				used += group.MPPTs
+				groups = append(groups, group)
+			}
+			if used > inputs {
+				continue
+			}
+			layout := &StringLayout{Inverters: inverters, Inputs: inputs, Groups: groups}
+			seen := make(map[string]bool)
+			for _, group := range groups {
+				for _, problem := range design.Option(group.Modules, group.StringsPerMPPT).Problems {
+					if !seen[problem.Code] {
+						seen[problem.Code] = true
+						layout.Problems = append(layout.Problems, problem)
+					}
+				}
+			}
+			return layout
+		}
+	}
+	return nil
+}
+
+//Describes a layout for the results page, such as "2 strings of 9 panels and
+//1 string of 8 panels on 3 of 4 MPPT inputs".
+func (layout StringLayout) String() string {
+	parts := make([]string, len(layout.Groups))
+	used := 0
+	for i, group := range layout.Groups {
+		parts[i] = fmt.Sprintf("%d %s of %d panels", group.Strings, Plural(group.Strings, "string", "strings"), group.Modules)
+		used += group.MPPTs
+	}
+	return fmt.Sprintf("%s on %d of %d MPPT inputs", strings.Join(parts, " and "), used, layout.Inputs)
+}
+
+//Gives the singular or plural of a word for a count.
+func Plural(count int, one, many string) string {
+	if count == 1 {
+		return one
+	}
+	return many
+}
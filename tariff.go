@@ -0,0 +1,500 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file prices the electricity a house buys. Rates are read
+from JSON files in a subset of the OpenEI Utility Rate Database (URDB)
+format, one rate or a list of them in each file of the tariffs directory.
+A rate can have a fixed monthly fee, a minimum bill, energy charges that are
+flat, tiered, seasonal or time of use, and demand charges on the month's
+peak, flat or time of use. Hour by hour usage and solar output are made from
+the monthly ones, and each month is billed before and after solar.*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"webtest/solarpos"
+)
+
+//The units of a rate's tiers and charges, as the URDB writes them.
+const (
+	UnitKwh      = "kWh"       //energy tiers' maximum, kwh in the month
+	UnitKwhDaily = "kWh daily" //energy tiers' maximum, kwh each day of the month
+	UnitKw       = "kW"        //demand tiers' maximum
+	UnitPerMonth = "$/month"   //fixed charge and minimum bill
+	UnitPerDay   = "$/day"     //fixed charge and minimum bill, each day of the month
+)
+
+/*This is one tier of a rate: its price up to max, in kwh for energy and kW
+for demand. The last tier has no max.*/
+type RateTier struct {
+	Max  *float64 `json:"max,omitempty"`
+	Rate float64  `json:"rate"`           //$ per kwh or kW
+	Adj  float64  `json:"adj,omitempty"`  //adjustment added to the rate, such as fuel costs
+	Unit string   `json:"unit,omitempty"` //kWh, kWh daily or kW (kWh or kW if empty)
+}
+
+/*This is the struct storing a utility rate, with the names the URDB gives its
+fields. The schedules have a row for each month and a column for each hour,
+giving the period of the rate structure that applies.*/
+type Tariff struct {
+	Label       string `json:"label"` //URDB id, the key the rate is chosen by (its file name if empty)
+	Name        string `json:"name"`
+	Utility     string `json:"utility"`
+	Sector      string `json:"sector,omitempty"`
+	Description string `json:"description,omitempty"`
+	File        string `json:"file,omitempty"` //file the rate was read from
+
+	FixedChargeFirstMeter float64 `json:"fixedchargefirstmeter,omitempty"`
+	FixedChargeUnits      string  `json:"fixedchargeunits,omitempty"`   //$/month or $/day
+	FixedMonthlyCharge    float64 `json:"fixedmonthlycharge,omitempty"` //older URDB rates
+	MinCharge             float64 `json:"mincharge,omitempty"`
+	MinChargeUnits        string  `json:"minchargeunits,omitempty"` //$/month or $/day
+
+	EnergyRateStructure   [][]RateTier `json:"energyratestructure"`
+	EnergyWeekdaySchedule [][]int      `json:"energyweekdayschedule"`
+	EnergyWeekendSchedule [][]int      `json:"energyweekendschedule"`
+
+	DemandRateStructure   [][]RateTier `json:"demandratestructure,omitempty"`
+	DemandWeekdaySchedule [][]int      `json:"demandweekdayschedule,omitempty"`
+	DemandWeekendSchedule [][]int      `json:"demandweekendschedule,omitempty"`
+	FlatDemandStructure   [][]RateTier `json:"flatdemandstructure,omitempty"`
+	FlatDemandMonths      []int        `json:"flatdemandmonths,omitempty"` //period of the flat demand structure for each month
+}
+
+//Makes a rate with one price for every kwh, for a house without a tariff.
+func FlatTariff(price float64) Tariff {
+	schedule := make([][]int, 12)
+	for m := range schedule {
+		schedule[m] = make([]int, 24)
+	}
+	return Tariff{
+		Label:                 "flat",
+		Name:                  fmt.Sprintf("Flat rate of $%s a kwh", FormatNumber(price)),
+		EnergyRateStructure:   [][]RateTier{{{Rate: price}}},
+		EnergyWeekdaySchedule: schedule,
+		EnergyWeekendSchedule: schedule,
+	}
+}
+
+//Checks that a rate can be billed: it has an energy rate structure, its
+//schedules have 12 months of 24 hours naming periods it has, and its tiers
+//rise.
+func (tariff Tariff) Check() error {
+	if len(tariff.EnergyRateStructure) == 0 {
+		return errors.New("it has no energyratestructure")
+	}
+	checks := []struct {
+		name      string
+		schedule  [][]int
+		structure [][]RateTier
+	}{
+		{"energyweekdayschedule", tariff.EnergyWeekdaySchedule, tariff.EnergyRateStructure},
+		{"energyweekendschedule", tariff.EnergyWeekendSchedule, tariff.EnergyRateStructure},
+		{"demandweekdayschedule", tariff.DemandWeekdaySchedule, tariff.DemandRateStructure},
+		{"demandweekendschedule", tariff.DemandWeekendSchedule, tariff.DemandRateStructure},
+	}
+	for i, check := range checks {
+		if i >= 2 && len(check.structure) == 0 {
+			continue
+		}
+		if len(check.schedule) != 12 {
+			return fmt.Errorf("%s has %d months, not 12", check.name, len(check.schedule))
+		}
+		for m, hours := range check.schedule {
+			if len(hours) != 24 {
+				return fmt.Errorf("%s month %d has %d hours, not 24", check.name, m+1, len(hours))
+			}
+			for _, period := range hours {
+				if period < 0 || period >= len(check.structure) {
+					return fmt.Errorf("%s month %d names period %d, which isn't in its rate structure", check.name, m+1, period)
+				}
+			}
+		}
+	}
+	for _, period := range tariff.FlatDemandMonths {
+		if period < 0 || period >= len(tariff.FlatDemandStructure) {
+			return fmt.Errorf("flatdemandmonths names period %d, which isn't in flatdemandstructure", period)
+		}
+	}
+	if len(tariff.FlatDemandStructure) > 0 && len(tariff.FlatDemandMonths) != 12 {
+		return fmt.Errorf("flatdemandmonths has %d months, not 12", len(tariff.FlatDemandMonths))
+	}
+	for _, structure := range [][][]RateTier{tariff.EnergyRateStructure, tariff.DemandRateStructure, tariff.FlatDemandStructure} {
+		for p, tiers := range structure {
+			if err := CheckTiers(tiers); err != nil {
+				return fmt.Errorf("period %d: %v", p, err)
+			}
+		}
+	}
+	for _, units := range []string{tariff.FixedChargeUnits, tariff.MinChargeUnits} {
+		if units != "" && units != UnitPerMonth && units != UnitPerDay {
+			return fmt.Errorf("the charge unit %q is not %s or %s", units, UnitPerMonth, UnitPerDay)
+		}
+	}
+	return nil
+}
+
+//Checks that a period's tiers rise, only the last has no max, and their unit
+//is one the bill can use.
+func CheckTiers(tiers []RateTier) error {
+	if len(tiers) == 0 {
+		return errors.New("it has no tiers")
+	}
+	last := 0.0
+	for i, tier := range tiers {
+		switch tier.Unit {
+		case "", UnitKwh, UnitKwhDaily, UnitKw:
+		default:
+			return fmt.Errorf("the tier unit %q is not %s, %s or %s", tier.Unit, UnitKwh, UnitKwhDaily, UnitKw)
+		}
+		if tier.Max == nil {
+			if i != len(tiers)-1 {
+				return fmt.Errorf("tier %d has no max but isn't the last", i+1)
+			}
+			continue
+		}
+		if *tier.Max <= last {
+			return fmt.Errorf("tier %d's max %s isn't above the one before it", i+1, FormatNumber(*tier.Max))
+		}
+		last = *tier.Max
+	}
+	return nil
+}
+
+//Gives the cost of an amount, kwh or kW, on a period's tiers. Tiers in kWh
+//daily are scaled to the days of the month, and the amount past the last
+//tier's max is priced at the last tier.
+func TierCost(tiers []RateTier, amount float64, days int) float64 {
+	var cost, below float64
+	for i, tier := range tiers {
+		top := math.Inf(1)
+		if tier.Max != nil && i < len(tiers)-1 {
+			top = *tier.Max
+			if tier.Unit == UnitKwhDaily {
+				top *= float64(days)
+			}
+		}
+		if amount <= below {
+			break
+		}
+		cost += (math.Min(amount, top) - below) * (tier.Rate + tier.Adj)
+		below = top
+	}
+	return cost
+}
+
+/*This is one month of a bill: the energy bought and the peak demand, and
+what each part of the bill costs.*/
+type MonthBill struct {
+	Month        string  `json:"month"`
+	Energy       float64 `json:"energy_kwh"`    //energy billed, after netting any solar sent to the grid
+	Demand       float64 `json:"peak_kw"`       //highest hour's average power bought
+	EnergyCharge float64 `json:"energy_charge"` //flat, tiered, seasonal and time of use charges
+	DemandCharge float64 `json:"demand_charge"`
+	FixedCharge  float64 `json:"fixed_charge"`
+	Exported     float64 `json:"exported_kwh,omitempty"`  //solar sent to the grid
+	Credit       float64 `json:"export_credit,omitempty"` //what the export policy credits for it
+	CreditUsed   float64 `json:"credit_used,omitempty"`   //credit taken off the energy and demand charges, this month's or carried over
+	Total        float64 `json:"total"`                   //at least the minimum bill
+}
+
+//Bills a year of hourly energy bought from the grid, kwh in each hour
+//from midnight on January 1st of SimYear. Solar sent to the grid (a negative hour)
+//is netted against what is bought in the same period of the same month, and
+//a period's energy is never below 0. Tiers are priced on the month's total
+//energy, shared among the periods by their energy.
+func (tariff Tariff) Bill(load []float64) [12]MonthBill {
+	var bills [12]MonthBill
+	energy := make([][]float64, 12)
+	demand := make([][]float64, 12)
+	var peak [12]float64
+	for m := range energy {
+		energy[m] = make([]float64, len(tariff.EnergyRateStructure))
+		demand[m] = make([]float64, len(tariff.DemandRateStructure))
+	}
+	for i, kwh := range load {
+		m, h, weekend := HourOfYear(i)
+		schedule := tariff.EnergyWeekdaySchedule
+		if weekend {
+			schedule = tariff.EnergyWeekendSchedule
+		}
+		energy[m][schedule[m][h]] += kwh
+		peak[m] = math.Max(peak[m], kwh)
+		if len(tariff.DemandRateStructure) > 0 {
+			schedule = tariff.DemandWeekdaySchedule
+			if weekend {
+				schedule = tariff.DemandWeekendSchedule
+			}
+			p := schedule[m][h]
+			demand[m][p] = math.Max(demand[m][p], kwh)
+		}
+	}
+	for m := range bills {
+		days := MonthDays[m]
+		bill := MonthBill{Month: MonthNames[m], Demand: peak[m]}
+		for p := range energy[m] {
+			energy[m][p] = math.Max(0, energy[m][p])
+			bill.Energy += energy[m][p]
+		}
+		for p, kwh := range energy[m] {
+			if bill.Energy > 0 {
+				bill.EnergyCharge += kwh / bill.Energy * TierCost(tariff.EnergyRateStructure[p], bill.Energy, days)
+			}
+		}
+		for p, kw := range demand[m] {
+			bill.DemandCharge += TierCost(tariff.DemandRateStructure[p], kw, days)
+		}
+		if len(tariff.FlatDemandMonths) == 12 {
+			bill.DemandCharge += TierCost(tariff.FlatDemandStructure[tariff.FlatDemandMonths[m]], peak[m], days)
+		}
+		bill.FixedCharge = PerMonth(tariff.FixedChargeFirstMeter, tariff.FixedChargeUnits, days) + tariff.FixedMonthlyCharge
+		bill.Total = math.Max(bill.EnergyCharge+bill.DemandCharge+bill.FixedCharge, PerMonth(tariff.MinCharge, tariff.MinChargeUnits, days))
+		bill.Energy = math.Round(bill.Energy*10) / 10
+		bill.Demand = math.Round(bill.Demand*100) / 100
+		for _, value := range []*float64{&bill.EnergyCharge, &bill.DemandCharge, &bill.FixedCharge, &bill.Total} {
+			*value = math.Round(*value*100) / 100
+		}
+		bills[m] = bill
+	}
+	return bills
+}
+
+//Gives a charge for a month: a charge in $/day times the days in it, or
+//a charge in $/month.
+func PerMonth(charge float64, units string, days int) float64 {
+	if units == UnitPerDay {
+		return charge * float64(days)
+	}
+	return charge
+}
+
+//The share of a day's energy a typical house uses in each hour, from midnight,
+//low overnight with peaks in the morning and evening.
+var ResidentialLoadShape = [24]float64{
+	0.030, 0.027, 0.026, 0.025, 0.026, 0.030, 0.038, 0.045, 0.045, 0.041, 0.039, 0.039,
+	0.039, 0.039, 0.040, 0.043, 0.048, 0.056, 0.063, 0.065, 0.063, 0.057, 0.047, 0.037,
+}
+
+//Spreads a house's monthly usage over the hours of each day with the typical
+//load shape, giving kwh in each hour from midnight on January 1st.
+func HourlyUsage(monthly [12]float64) []float64 {
+	var total float64
+	for _, share := range ResidentialLoadShape {
+		total += share
+	}
+	hours := make([]float64, 0, HoursPerYear)
+	start := time.Date(SimYear, 1, 1, 0, 0, 0, 0, time.UTC)
+	for d := 0; d < 365; d++ {
+		m := int(start.AddDate(0, 0, d).Month()) - 1
+		for h := 0; h < 24; h++ {
+			hours = append(hours, monthly[m]/float64(MonthDays[m])*ResidentialLoadShape[h]/total)
+		}
+	}
+	return hours
+}
+
+//Spreads monthly solar output over the hours of each month in the shape of
+//a clear sky's sunlight on flat ground at the site, giving kwh in each hour
+//from midnight on January 1st.
+func HourlySolar(monthly [12]float64, lat, lon float64) []float64 {
+	sky := ClearSkyHours(solarpos.NewObserver(lat, lon), math.Round(lon/15))
+	var sunlight [12]float64
+	for _, hour := range sky {
+		sunlight[hour.Month-1] += hour.GHI
+	}
+	hours := make([]float64, len(sky))
+	for i, hour := range sky {
+		if sunlight[hour.Month-1] > 0 {
+			hours[i] = monthly[hour.Month-1] * hour.GHI / sunlight[hour.Month-1]
+		}
+	}
+	return hours
+}
+
+//Gives hourly output scaled to add up to total.
+func ScaleHours(hours []float64, total float64) []float64 {
+	var sum float64
+	for _, kwh := range hours {
+		sum += kwh
+	}
+	scaled := make([]float64, len(hours))
+	for i, kwh := range hours {
+		if sum > 0 {
+			scaled[i] = kwh * total / sum
+		}
+	}
+	return scaled
+}
+
+//Reads the tariffs in a directory by key: their label, or their file's name
+//with a number after it for each rate after the first. A directory that
+//doesn't exist gives no tariffs, and files that can't be read or rates that
+//can't be billed are added to the diagnostics and left out.
+func LoadTariffs(dir string, diagnostics *Diagnostics) map[string]Tariff {
+	tariffs := make(map[string]Tariff)
+	if dir == "" {
+		return tariffs
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			diagnostics.Add(dir, 0, "", "couldn't read the tariff directory: "+err.Error())
+		}
+		return tariffs
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.ToLower(filepath.Ext(entry.Name())) != ".json" {
+			continue
+		}
+		filename := filepath.Join(dir, entry.Name())
+		rates, err := ReadTariffs(filename)
+		if err != nil {
+			diagnostics.Add(filename, 1, "", err.Error())
+			continue
+		}
+		for i, tariff := range rates {
+			if err := tariff.Check(); err != nil {
+				diagnostics.Add(filename, 1, "", fmt.Sprintf("rate %d can't be billed: %v", i+1, err))
+				continue
+			}
+			if tariff.Label == "" {
+				tariff.Label = strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+				if i > 0 {
+					tariff.Label += fmt.Sprintf("-%d", i+1)
+				}
+			}
+			if tariff.Name == "" {
+				tariff.Name = tariff.Label
+			}
+			tariff.File = filename
+			tariffs[tariff.Label] = tariff
+		}
+	}
+	return tariffs
+}
+
+//Reads the rates in a URDB JSON file: one rate, a list of them, or the
+//URDB API's answer, with the rates in items.
+func ReadTariffs(filename string) ([]Tariff, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.TrimSpace(string(data)))
+	var rates []Tariff
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &rates)
+	} else {
+		var answer struct {
+			Items []Tariff `json:"items"`
+		}
+		if err = json.Unmarshal(data, &answer); err == nil && answer.Items != nil {
+			rates = answer.Items
+		} else if err == nil {
+			var rate Tariff
+			err = json.Unmarshal(data, &rate)
+			rates = []Tariff{rate}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("not a URDB rate: %v", err)
+	}
+	return rates, nil
+}
+
+//Gives the keys of the tariffs in alphabetical order.
+func TariffKeys(tariffs map[string]Tariff) []string {
+	keys := make([]string, 0, len(tariffs))
+	for key := range tariffs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*This is the struct storing a house's bills for a year before and after
+solar, on a tariff and under an export policy.*/
+type BillComparison struct {
+	Tariff       string        `json:"tariff"`        //name of the rate
+	Policy       string        `json:"export_policy"` //name of the export policy
+	Months       []BillSavings `json:"monthly"`
+	Exported     float64       `json:"exported_kwh"`     //solar sent to the grid in the year
+	ExportCredit float64       `json:"export_credit"`    //what the export policy credits for it
+	Offset       float64       `json:"offset_kwh"`       //solar that saves the retail rate: used in the house, or netted under net metering
+	Worth        float64       `json:"worth_kwh"`        //kwh at the retail rate the solar is worth: the offset, and the credits and true-up at the average price before solar
+	TrueUp       float64       `json:"true_up_payment"`  //paid for the year's net surplus at the true-up
+	Forfeited    float64       `json:"credit_forfeited"` //credit left unused at the true-up
+	Before       float64       `json:"before_solar"`     //a year of bills without solar
+	After        float64       `json:"after_solar"`      //a year of bills with solar, less the true-up payment
+	Savings      float64       `json:"savings"`          //before less after
+}
+
+/*This is one month of a house's bills before and after solar.*/
+type BillSavings struct {
+	Month   string    `json:"month"`
+	Usage   float64   `json:"usage_kwh"`
+	Solar   float64   `json:"solar_kwh"`
+	Before  MonthBill `json:"before_solar"`
+	After   MonthBill `json:"after_solar"`
+	Savings float64   `json:"savings"`
+}
+
+//Bills a house's hourly usage for a year on a tariff, without solar and with
+//the hourly solar output taken off it under an export policy.
+func CompareBills(tariff Tariff, policy ExportPolicy, usage, solar []float64) BillComparison {
+	var usageMonths, solarMonths [12]float64
+	var solarTotal, bought float64
+	for i := range usage {
+		m, _, _ := HourOfYear(i)
+		usageMonths[m] += usage[i]
+		if i < len(solar) {
+			solarMonths[m] += solar[i]
+			solarTotal += solar[i]
+			bought += math.Max(0, usage[i]-solar[i])
+		} else {
+			bought += usage[i]
+		}
+	}
+	before := tariff.Bill(usage)
+	after, trueUp, forfeited := policy.Bill(tariff, usage, solar)
+	comparison := BillComparison{Tariff: tariff.Name, Policy: policy.Name, Months: make([]BillSavings, 12), TrueUp: trueUp, Forfeited: forfeited}
+	for m := range comparison.Months {
+		savings := math.Round((before[m].Total-after[m].Total)*100) / 100
+		comparison.Months[m] = BillSavings{MonthNames[m], math.Round(usageMonths[m]*10) / 10, math.Round(solarMonths[m]*10) / 10, before[m], after[m], savings}
+		comparison.Before += before[m].Total
+		comparison.After += after[m].Total
+		comparison.Exported += after[m].Exported
+		comparison.ExportCredit += after[m].Credit
+	}
+	comparison.Offset = solarTotal - comparison.Exported
+	credits := trueUp + comparison.ExportCredit
+	if policy.Kind == ExportNetMetering {
+		comparison.Offset = solarTotal - math.Max(0, comparison.Exported-bought)
+		credits = trueUp
+	}
+	var energyCharges, energy float64
+	for _, bill := range before {
+		energyCharges += bill.EnergyCharge
+		energy += bill.Energy
+	}
+	comparison.Worth = comparison.Offset
+	if energyCharges > 0 {
+		comparison.Worth += credits / (energyCharges / energy)
+	}
+	comparison.Worth = math.Round(comparison.Worth*10) / 10
+	comparison.Offset = math.Round(comparison.Offset*10) / 10
+	comparison.Exported = math.Round(comparison.Exported*10) / 10
+	comparison.ExportCredit = math.Round(comparison.ExportCredit*100) / 100
+	comparison.Before = math.Round(comparison.Before*100) / 100
+	comparison.After = math.Round((comparison.After-trueUp)*100) / 100
+	comparison.Savings = math.Round((comparison.Before-comparison.After)*100) / 100
+	return comparison
+}
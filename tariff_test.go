@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//Gives a URDB rate of one price for every kwh, at every hour of the year.
+func flatTariffJSON(name string, rate float64) string {
+	day := "[" + strings.TrimSuffix(strings.Repeat("0,", 24), ",") + "]"
+	year := "[" + strings.TrimSuffix(strings.Repeat(day+",", 12), ",") + "]"
+	return fmt.Sprintf(`{"name": %q, "energyratestructure": [[{"rate": %v}]], "energyweekdayschedule": %s, "energyweekendschedule": %s}`, name, rate, year, year)
+}
+
+//A tariff file that isn't JSON and a rate that can't be billed are reported
+//with their file, and the good rates are still read.
+func TestLoadTariffsDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"flat.json":   flatTariffJSON("Flat", 0.15),
+		"broken.json": `{"name": "Broken"`,
+		"nobill.json": `[{"name": "No rates"}]`,
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var diagnostics Diagnostics
+	tariffs := LoadTariffs(dir, &diagnostics)
+	if len(diagnostics) != 2 {
+		t.Fatalf("got %d diagnostics, want 2: %v", len(diagnostics), diagnostics)
+	}
+	for _, diagnostic := range diagnostics {
+		name := filepath.Base(diagnostic.File)
+		if name != "broken.json" && name != "nobill.json" {
+			t.Errorf("diagnostic for %s: %v", name, diagnostic)
+		}
+		if name == "nobill.json" && !strings.Contains(diagnostic.Reason, "rate 1") {
+			t.Errorf("%v doesn't say which rate", diagnostic)
+		}
+	}
+	if _, ok := tariffs["flat"]; !ok || len(tariffs) != 1 {
+		t.Errorf("got tariffs %v, want only flat", tariffs)
+	}
+}
+
+//Bills for a house using 1 kwh every hour, worked by hand from the example
+//rates' tiers, seasons, demand charge, fixed charge and minimum bill.
+func TestBillExampleTariffs(t *testing.T) {
+	tiered, err := ReadTariffs("tariffs/example-tiered.json")
+	if err != nil || len(tiered) != 1 {
+		t.Fatalf("got %d rates: %v", len(tiered), err)
+	}
+	load := make([]float64, 8760)
+	for i := range load {
+		load[i] = 1
+	}
+	bills := tiered[0].Bill(load)
+	cases := []struct {
+		month                                     int
+		energy, energyCharge, demandCharge, total float64
+	}{
+		{0, 744, 91.6, 2.5, 106.1},    //500 kwh at $0.11 and 244 at $0.15, $2.50 for the 1 kW peak, $12 a month
+		{1, 672, 80.8, 2.5, 95.3},     //172 kwh at $0.15
+		{6, 744, 103.92, 2.5, 118.42}, //summer: 500 kwh at $0.12 and 244 at $0.18
+	}
+	for _, c := range cases {
+		bill := bills[c.month]
+		if bill.Energy != c.energy || bill.EnergyCharge != c.energyCharge || bill.DemandCharge != c.demandCharge || bill.FixedCharge != 12 || bill.Total != c.total {
+			t.Errorf("%s: %v kwh, $%v energy, $%v demand, $%v fixed, $%v total, want %v kwh, $%v, $%v, $12, $%v",
+				bill.Month, bill.Energy, bill.EnergyCharge, bill.DemandCharge, bill.FixedCharge, bill.Total, c.energy, c.energyCharge, c.demandCharge, c.total)
+		}
+	}
+
+	//a house that buys nothing pays the minimum bill of $0.35 a day, more than the $10 fee
+	tou, err := ReadTariffs("tariffs/example-tou.json")
+	if err != nil || len(tou) != 1 {
+		t.Fatalf("got %d rates: %v", len(tou), err)
+	}
+	bills = tou[0].Bill(make([]float64, 8760))
+	if bills[0].Total != 10.85 || bills[1].Total != 10 {
+		t.Errorf("January $%v and February $%v without usage, want $10.85 and $10", bills[0].Total, bills[1].Total)
+	}
+}
+
+func TestTierCost(t *testing.T) {
+	ten, hundred := 10.0, 100.0
+	cases := []struct {
+		name   string
+		tiers  []RateTier
+		amount float64
+		want   float64
+	}{
+		{"flat", []RateTier{{Rate: 0.2}}, 300, 60},
+		{"within the first tier", []RateTier{{Max: &hundred, Rate: 0.1}, {Rate: 0.3}}, 80, 8},
+		{"into the second tier", []RateTier{{Max: &hundred, Rate: 0.1}, {Rate: 0.3}}, 150, 25},
+		{"with an adjustment", []RateTier{{Max: &hundred, Rate: 0.1, Adj: 0.02}, {Rate: 0.3, Adj: 0.02}}, 150, 28},
+		{"daily tiers over 30 days", []RateTier{{Max: &ten, Rate: 0.1, Unit: UnitKwhDaily}, {Rate: 0.3, Unit: UnitKwhDaily}}, 400, 60},
+		{"a last tier's max is ignored", []RateTier{{Max: &hundred, Rate: 0.1}, {Max: &hundred, Rate: 0.3}}, 300, 70},
+	}
+	for _, c := range cases {
+		if got := TierCost(c.tiers, c.amount, 30); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: $%v, want $%v", c.name, got, c.want)
+		}
+	}
+}
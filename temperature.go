@@ -0,0 +1,85 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file works out how much output panels lose to heat. Panels
+in the sun get hotter than the air around them, and lose a share of their
+power for every degree their cells are above 25°C, the temperature their
+watts are rated at. The cell temperature comes from the air temperature and
+the sunlight on the panels (the NOCT model), so hot, sunny cities lose the
+most.*/
+
+package main
+
+import "math"
+
+//The temperature coefficient and NOCT of panels that don't give their own,
+//typical of crystalline silicon panels.
+const (
+	DefaultTempCoeff = -0.40 //% of power for each °C
+	DefaultNOCT      = 45.0  //°C
+)
+
+//How much warmer the air is while panels make their energy than the city's
+//mean temperature, in °C. Most of the energy is made around the middle of
+//the day, when it is warmer than the mean of the day and night.
+const DaytimeWarming = 5.0
+
+/*This is a thermal struct which stores how a panel heats up in the sun and
+how much power it loses when it does.*/
+type Thermal struct {
+	TempCoeff float64 `json:"temp_coeff_percent_per_c"` //change in power for each °C the cells are above 25°C
+	NOCT      float64 `json:"noct_c"`                   //cell temperature in 800 W/m² of sunlight, 20°C air and a 1 m/s wind
+}
+
+//The thermal values of panels that don't give their own.
+var DefaultThermal = Thermal{DefaultTempCoeff, DefaultNOCT}
+
+/*This is the struct storing how much output was lost to heat. The
+temperatures are averages over the time the panels make energy, weighted by
+the energy made. A factor above 1 and a negative loss mean the cells were
+mostly below 25°C and the panels gained from the cold.*/
+type HeatLoss struct {
+	Thermal
+	AmbientC float64     `json:"ambient_c"`        //air temperature
+	CellC    float64     `json:"cell_c"`           //cell temperature
+	Factor   float64     `json:"factor"`           //share of the output left after heat
+	LostKwh  float64     `json:"lost_kwh_month"`   //energy lost to heat before the loss chain, kwh per month
+	Monthly  [12]float64 `json:"monthly_lost_kwh"` //energy lost to heat in each month, kwh
+}
+
+//Gives a panel's thermal values.
+func (panel Panel) Thermal() Thermal {
+	return Thermal{panel.tempCoeff, panel.noct}
+}
+
+//Converts a temperature from °F to °C.
+func FahrenheitToCelsius(temp float64) float64 {
+	return (temp - 32) * 5 / 9
+}
+
+//Gives the temperature of the cells in °C for the air temperature in °C and
+//the sunlight on the panels in W/m² (the NOCT model).
+func CellTemperature(ambient, irradiance, noct float64) float64 {
+	return ambient + (noct-20)/800*irradiance
+}
+
+//Gives the share of the rated power left at a cell temperature in °C.
+func (thermal Thermal) Derate(cell float64) float64 {
+	return math.Max(0, 1+thermal.TempCoeff/100*(cell-25))
+}
+
+//Makes the breakdown of the energy lost to heat from each month's energy
+//flow. The energy lost is counted before the loss chain, like the heat step
+//of the loss waterfall.
+func NewHeatLoss(thermal Thermal, months [12]FlowTotals) HeatLoss {
+	heat := HeatLoss{Thermal: thermal, Factor: 1}
+	for m, month := range months {
+		heat.Monthly[m] = math.Round((month.Sunlight-month.AfterHeat)*100) / 100
+	}
+	year := SumFlows(months)
+	ambient, cell := year.Temperatures()
+	heat.AmbientC, heat.CellC = math.Round(ambient*10)/10, math.Round(cell*10)/10
+	if year.Sunlight > 0 {
+		heat.Factor = math.Round(year.AfterHeat/year.Sunlight*10000) / 10000
+	}
+	heat.LostKwh = math.Round((year.Sunlight-year.AfterHeat)/12*100) / 100
+	return heat
+}
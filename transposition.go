@@ -0,0 +1,348 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file works out how much sunlight lands on panels at any
+tilt and azimuth (the plane of array irradiance) from the sunlight on flat
+ground. The sky's diffuse light can be spread over the sky in three ways: the
+same from every part of the sky (isotropic), brighter around the sun
+(Hay-Davies), or brighter around the sun and along the horizon (Perez). It
+also reads roof pitches and directions the way people describe them.*/
+
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"webtest/solarpos"
+)
+
+//The transposition models, for spreading the sky's diffuse light.
+const (
+	TransposeIsotropic = "isotropic"  //the same from every part of the sky (Liu and Jordan)
+	TransposeHayDavies = "hay-davies" //part of it comes from around the sun (Hay and Davies, 1980)
+	TransposePerez     = "perez"      //around the sun and along the horizon (Perez et al., 1990)
+)
+
+//The transposition model used when a request doesn't name one.
+const DefaultTransposition = TransposePerez
+
+/*This is a plane struct which stores which way a set of panels faces: the
+tilt from horizontal, the azimuth in degrees clockwise from north and the
+transposition model to use for it.*/
+type Plane struct {
+	Tilt    float64 `json:"tilt_deg"`
+	Azimuth float64 `json:"azimuth_deg"`
+	Model   string  `json:"transposition"`
+}
+
+/*This is the sunlight at one moment, split into its parts, with where the
+sun is relative to a plane. The irradiances can be in any unit as long as
+they are all in the same one.*/
+type SkyLight struct {
+	GHI              float64 //global horizontal: all the sunlight on flat ground
+	DNI              float64 //direct normal: the direct sunlight on a surface facing the sun
+	DHI              float64 //diffuse horizontal: the sky's light on flat ground
+	Albedo           float64 //share of the sunlight reflected off the ground
+	Extraterrestrial float64 //direct normal sunlight at the top of the atmosphere
+	Zenith           float64 //degrees of the sun from straight up
+	Incidence        float64 //degrees between the sun and straight out from the plane
+}
+
+//Checks that a transposition model is one of the three, where "" means the default.
+func ValidTransposition(model string) bool {
+	switch model {
+	case "", TransposeIsotropic, TransposeHayDavies, TransposePerez:
+		return true
+	}
+	return false
+}
+
+//Gives the sunlight landing on a plane: the direct sunlight that hits it,
+//the sky's diffuse light it sees and the light reflected off the ground in
+//front of it.
+func Transpose(light SkyLight, plane Plane) float64 {
+	var beam float64
+	if light.Zenith < 90 {
+		beam = light.DNI * math.Max(0, math.Cos(light.Incidence*math.Pi/180))
+	}
+	ground := light.GHI * light.Albedo * (1 - math.Cos(plane.Tilt*math.Pi/180)) / 2
+	return beam + SkyDiffuse(light, plane) + ground
+}
+
+//Gives the sky's diffuse light landing on a plane, with the plane's
+//transposition model.
+func SkyDiffuse(light SkyLight, plane Plane) float64 {
+	tilt := plane.Tilt * math.Pi / 180
+	isotropic := light.DHI * (1 + math.Cos(tilt)) / 2
+	if light.DHI <= 0 || light.Zenith >= 90 || light.Extraterrestrial <= 0 {
+		return math.Max(0, isotropic)
+	}
+	cosIncidence := math.Max(0, math.Cos(light.Incidence*math.Pi/180))
+	cosZenith := math.Cos(light.Zenith * math.Pi / 180)
+	switch plane.Model {
+	case TransposeHayDavies:
+		anisotropy := math.Min(1, light.DNI/light.Extraterrestrial) //share of the diffuse light that comes from around the sun
+		ratio := cosIncidence / math.Max(cosZenith, 0.01745)
+		return light.DHI * (anisotropy*ratio + (1-anisotropy)*(1+math.Cos(tilt))/2)
+	case TransposePerez, "":
+		zenith := light.Zenith * math.Pi / 180
+		kz3 := 1.041 * zenith * zenith * zenith
+		clearness := ((light.DHI+light.DNI)/light.DHI + kz3) / (1 + kz3)
+		brightness := light.DHI * AirMass(light.Zenith) / light.Extraterrestrial
+		bin := perezBin(clearness)
+		f1 := math.Max(0, PerezF1[bin][0]+PerezF1[bin][1]*brightness+PerezF1[bin][2]*zenith)
+		f2 := PerezF2[bin][0] + PerezF2[bin][1]*brightness + PerezF2[bin][2]*zenith
+		circumsolar := cosIncidence / math.Max(cosZenith, math.Cos(85*math.Pi/180))
+		sky := (1-f1)*(1+math.Cos(tilt))/2 + f1*circumsolar + f2*math.Sin(tilt)
+		return math.Max(0, light.DHI*sky)
+	}
+	return isotropic
+}
+
+//The upper edges of Perez's sky clearness bins. The last bin has no upper edge.
+var PerezClearness = [7]float64{1.065, 1.23, 1.5, 1.95, 2.8, 4.5, 6.2}
+
+//Perez's coefficients for the circumsolar (F1) and horizon (F2) brightening
+//in each sky clearness bin, fitted to all sites together (Perez et al., 1990).
+var (
+	PerezF1 = [8][3]float64{
+		{-0.0083117, 0.5877285, -0.0620636},
+		{0.1299457, 0.6825954, -0.1513752},
+		{0.3296958, 0.4868735, -0.2210958},
+		{0.5682053, 0.1874525, -0.2951290},
+		{0.8730280, -0.3920403, -0.3616149},
+		{1.1326077, -1.2367284, -0.4118494},
+		{1.0601591, -1.5999137, -0.3589221},
+		{0.6777470, -0.3272588, -0.2504286},
+	}
+	PerezF2 = [8][3]float64{
+		{-0.0596012, 0.0721249, -0.0220216},
+		{-0.0189325, 0.0659650, -0.0288748},
+		{0.0554140, -0.0639588, -0.0260542},
+		{0.1088631, -0.1519229, -0.0139754},
+		{0.2255647, -0.4620442, 0.0012448},
+		{0.2877813, -0.8230357, 0.0558651},
+		{0.2642124, -1.1272340, 0.1310694},
+		{0.1561313, -1.3765031, 0.2506212},
+	}
+)
+
+//Gives the Perez bin a sky clearness falls in.
+func perezBin(clearness float64) int {
+	for bin, edge := range PerezClearness {
+		if clearness < edge {
+			return bin
+		}
+	}
+	return len(PerezClearness)
+}
+
+//Gives how many atmospheres thick the air is in the direction of the sun,
+//relative to straight up (Kasten and Young, 1989).
+func AirMass(zenith float64) float64 {
+	zenith = math.Min(zenith, 90)
+	return 1 / (math.Cos(zenith*math.Pi/180) + 0.50572*math.Pow(96.07995-zenith, -1.6364))
+}
+
+//Gives how much of a day's sunlight lands on a plane compared to flat ground,
+//given the day's sunlight on flat ground in kWh/m². Flat planes give 1.
+func PlaneFactor(latitude float64, day int, ghi float64, plane Plane) float64 {
+	if plane.Tilt == 0 || ghi <= 0 {
+		return 1
+	}
+	var onPlane float64
+	steps, hours := PlaneDay(latitude, day, ghi, plane)
+	for _, poa := range steps {
+		onPlane += poa * hours
+	}
+	return onPlane / ghi
+}
+
+//Gives the sunlight landing on a plane over a typical day, given the day's
+//sunlight on flat ground in kWh/m²: the plane's irradiance in kW/m² for each
+//few minutes from sunrise to sunset, and how many hours each lasts. The
+//day's sunlight is split into direct and diffuse with Erbs' monthly
+//correlation, spread over the day with Collares-Pereira and Rabl's hourly
+//shapes and transposed onto the plane one step at a time.
+func PlaneDay(latitude float64, day int, ghi float64, plane Plane) ([]float64, float64) {
+	h0 := ExtraterrestrialDaily(latitude, day)
+	if ghi <= 0 || h0 <= 0 {
+		return nil, 0
+	}
+	lat := latitude * math.Pi / 180
+	decl := Declination(day)
+	sunset := math.Acos(math.Max(-1, math.Min(1, -math.Tan(lat)*math.Tan(decl))))
+
+	//share of the day's sunlight that is diffuse (Erbs et al., 1982)
+	kt := math.Max(0.3, math.Min(0.8, ghi/h0))
+	diffuse := 1.391 - 3.560*kt + 4.189*kt*kt - 2.137*kt*kt*kt
+	if sunset > 81.4*math.Pi/180 {
+		diffuse = 1.311 - 3.022*kt + 3.427*kt*kt - 1.821*kt*kt*kt
+	}
+	dhi := ghi * math.Min(1, diffuse)
+
+	//the shape of the total and diffuse sunlight over the day (Collares-Pereira and Rabl, 1979)
+	const steps = 96
+	a := 0.409 + 0.5016*math.Sin(sunset-math.Pi/3)
+	b := 0.6609 - 0.4767*math.Sin(sunset-math.Pi/3)
+	step := 2 * sunset / steps
+	var totalShape, diffuseShape [steps]float64
+	var totalSum, diffuseSum float64
+	for i := range totalShape {
+		hourAngle := -sunset + (float64(i)+0.5)*step
+		above := math.Cos(hourAngle) - math.Cos(sunset)
+		totalShape[i] = (a + b*math.Cos(hourAngle)) * above
+		diffuseShape[i] = above
+		totalSum += totalShape[i]
+		diffuseSum += diffuseShape[i]
+	}
+	if totalSum <= 0 || diffuseSum <= 0 {
+		return nil, 0
+	}
+
+	hours := step * 12 / math.Pi
+	extraterrestrial := SolarConstant / 1000 * (1 + 0.033*math.Cos(2*math.Pi*float64(day)/365))
+	poas := make([]float64, steps)
+	for i := range totalShape {
+		hourAngle := -sunset + (float64(i)+0.5)*step
+		cosZenith := math.Sin(lat)*math.Sin(decl) + math.Cos(lat)*math.Cos(decl)*math.Cos(hourAngle)
+		if cosZenith <= 0 {
+			continue
+		}
+		//sunlight in kW/m² during this step
+		global := ghi * totalShape[i] / totalSum / hours
+		sky := math.Min(global, dhi*diffuseShape[i]/diffuseSum/hours)
+		sun := solarpos.Position{
+			Zenith:  math.Acos(cosZenith) * 180 / math.Pi,
+			Azimuth: math.Atan2(math.Sin(hourAngle), math.Cos(hourAngle)*math.Sin(lat)-math.Tan(decl)*math.Cos(lat))*180/math.Pi + 180,
+		}
+		light := SkyLight{
+			GHI:              global,
+			DNI:              (global - sky) / math.Max(cosZenith, 0.01745),
+			DHI:              sky,
+			Albedo:           DefaultAlbedo,
+			Extraterrestrial: extraterrestrial,
+			Zenith:           sun.Zenith,
+			Incidence:        solarpos.Incidence(sun, plane.Tilt, plane.Azimuth),
+		}
+		poa := global
+		if plane.Tilt != 0 {
+			poa = Transpose(light, plane)
+		}
+		poas[i] = poa
+	}
+	return poas, hours
+}
+
+//The number of days of different clearness a month's sunlight is split into
+//when following it hour by hour, so the peaks of clear days aren't averaged
+//away by cloudy ones.
+const ClearnessDays = 5
+
+//Gives the clearness (the share of the sunlight at the top of the atmosphere
+//that reaches the ground) of ClearnessDays days that together have a month's
+//average clearness. The days are spread over the distribution of daily
+//clearness of Bendt, Collares-Pereira and Rabl (1981).
+func DailyClearness(mean float64) [ClearnessDays]float64 {
+	var days [ClearnessDays]float64
+	low := 0.05
+	high := 0.6313 + 0.267*mean - 11.9*math.Pow(mean-0.75, 8)
+	if mean <= low || mean >= high {
+		for i := range days {
+			days[i] = mean
+		}
+		return days
+	}
+	//the distribution is exponential between low and high, with gamma set so its mean is the month's
+	distributionMean := func(gamma float64) float64 {
+		a, b := math.Exp(gamma*low), math.Exp(gamma*high)
+		return ((low-1/gamma)*a - (high-1/gamma)*b) / (a - b)
+	}
+	minGamma, maxGamma := -40.0, 60.0
+	for i := 0; i < 60; i++ {
+		gamma := (minGamma + maxGamma) / 2
+		if distributionMean(gamma) < mean {
+			minGamma = gamma
+		} else {
+			maxGamma = gamma
+		}
+	}
+	gamma := (minGamma + maxGamma) / 2
+	if math.Abs(gamma) < 1e-6 {
+		gamma = 1e-6
+	}
+	a, b := math.Exp(gamma*low), math.Exp(gamma*high)
+	var total float64
+	for i := range days {
+		share := (float64(i) + 0.5) / ClearnessDays
+		days[i] = math.Log(a-share*(a-b)) / gamma
+		total += days[i]
+	}
+	for i := range days {
+		days[i] *= mean * ClearnessDays / total
+	}
+	return days
+}
+
+//Gives a city's average daily sunlight on a plane for each month, in
+//kWh/m²/day, from its monthly sunlight on flat ground.
+func PlaneRadiation(city City, plane Plane) [12]float64 {
+	var radiation [12]float64
+	for m, day := range MidMonthDay {
+		radiation[m] = city.monthlyRad[m] * PlaneFactor(city.lat, day, city.monthlyRad[m], plane)
+	}
+	return radiation
+}
+
+//The sixteen compass points, each 22.5 degrees clockwise from the last.
+var CompassPoints = []string{"n", "nne", "ne", "ene", "e", "ese", "se", "sse", "s", "ssw", "sw", "wsw", "w", "wnw", "nw", "nnw"}
+
+//Reads a roof pitch as degrees ("22.5", "22.5°", "22.5 deg") or as rise over
+//run ("4/12", "4:12", "4 in 12"), and gives it in degrees from horizontal.
+func ParsePitch(text string) (float64, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	for _, separator := range []string{"/", ":", " in "} {
+		if parts := strings.SplitN(text, separator, 2); len(parts) == 2 {
+			rise, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+			run, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if err1 != nil || err2 != nil || rise < 0 || run <= 0 {
+				return 0, errors.New("a pitch as rise/run needs two numbers with a run above 0, such as 4/12")
+			}
+			return math.Atan2(rise, run) * 180 / math.Pi, nil
+		}
+	}
+	for _, unit := range []string{"degrees", "degree", "deg", "°"} {
+		text = strings.TrimSpace(strings.TrimSuffix(text, unit))
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, errors.New("a pitch is degrees, such as 22.5, or rise/run, such as 4/12")
+	}
+	return value, nil
+}
+
+//Reads a direction as degrees clockwise from north ("270") or as a compass
+//point ("W", "SSE", "south-west").
+func ParseAzimuth(text string) (float64, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	for _, unit := range []string{"degrees", "degree", "deg", "°"} {
+		text = strings.TrimSpace(strings.TrimSuffix(text, unit))
+	}
+	if value, err := strconv.ParseFloat(text, 64); err == nil && !math.IsNaN(value) && !math.IsInf(value, 0) {
+		return value, nil
+	}
+	short := strings.NewReplacer("north", "n", "south", "s", "east", "e", "west", "w", "-", "", " ", "").Replace(text)
+	for i, point := range CompassPoints {
+		if short == point {
+			return float64(i) * 22.5, nil
+		}
+	}
+	return 0, errors.New("a direction is degrees clockwise from north, such as 180, or a compass point, such as S or SW")
+}
+
+//Gives the compass point closest to an azimuth, such as SW.
+func CompassPoint(azimuth float64) string {
+	i := int(math.Round(math.Mod(math.Mod(azimuth, 360)+360, 360)/22.5)) % len(CompassPoints)
+	return strings.ToUpper(CompassPoints[i])
+}
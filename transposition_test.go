@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+//Two skies worked by hand from Perez et al. (1990): the clearness and
+//brightness, the bin they fall in, that bin's F1 and F2 from the paper's
+//table and the sky diffuse on a plane tilted 30°.
+func TestPerezWorkedExamples(t *testing.T) {
+	cases := []struct {
+		name  string
+		light SkyLight
+		want  float64
+	}{
+		//clearness 7.960 (bin 8), brightness 0.0844, F1 0.5190, F2 0.1712
+		{"clear", SkyLight{DHI: 100, DNI: 800, Extraterrestrial: 1367, Zenith: 30, Incidence: 10}, 112.454},
+		//clearness 1.114 (bin 2), brightness 0.2918, F1 0.1706, F2 -0.0299
+		{"overcast", SkyLight{DHI: 200, DNI: 50, Extraterrestrial: 1367, Zenith: 60, Incidence: 40}, 204.050},
+	}
+	for _, c := range cases {
+		got := SkyDiffuse(c.light, Plane{Tilt: 30, Azimuth: 180, Model: TransposePerez})
+		if math.Abs(got-c.want) > 0.001 {
+			t.Errorf("%s sky: %.3f W/m² of diffuse on the plane, want %.3f", c.name, got, c.want)
+		}
+	}
+
+	//with the clear sky's direct light and the ground's reflection
+	clear := cases[0].light
+	clear.GHI = clear.DNI*math.Cos(30*math.Pi/180) + clear.DHI
+	clear.Albedo = 0.2
+	if got := Transpose(clear, Plane{Tilt: 30, Azimuth: 180, Model: TransposePerez}); math.Abs(got-910.922) > 0.001 {
+		t.Errorf("clear sky: %.3f W/m² on the plane, want 910.922", got)
+	}
+}
+
+//A flat plane sees all of the sky's diffuse light and nothing else, with
+//every model.
+func TestTransposeFlat(t *testing.T) {
+	light := SkyLight{GHI: 792.82, DNI: 800, DHI: 100, Albedo: 0.2, Extraterrestrial: 1367, Zenith: 30, Incidence: 30}
+	for _, model := range []string{TransposeIsotropic, TransposeHayDavies, TransposePerez} {
+		plane := Plane{Tilt: 0, Azimuth: 180, Model: model}
+		if got := SkyDiffuse(light, plane); math.Abs(got-light.DHI) > 1e-9 {
+			t.Errorf("%s: %v of diffuse on a flat plane, want %v", model, got, light.DHI)
+		}
+		if got := Transpose(light, plane); math.Abs(got-light.GHI) > 0.01 {
+			t.Errorf("%s: %v on a flat plane, want the GHI %v", model, got, light.GHI)
+		}
+	}
+}
@@ -0,0 +1,788 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file checks the numbers the user submits before any
+estimate is computed, so bad input gets an error instead of NaN results.*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"webtest/solarpos"
+)
+
+//Limits on the user's input. These match checkInput() in the html files.
+const (
+	MinLatitude  = -90.0
+	MaxLatitude  = 90.0
+	MinLongitude = -180.0
+	MaxLongitude = 180.0
+	MaxSizeSqFt  = 100000.0
+)
+
+//Error codes returned for a field.
+const (
+	CodeRequired      = "required"
+	CodeInvalidNumber = "invalid_number"
+	CodeOutOfRange    = "out_of_range"
+	CodeInvalidCoord  = "invalid_coordinate"
+	CodeOutOfCoverage = "out_of_coverage"
+	CodeUnknown       = "unknown"
+)
+
+/*This is a field error struct which stores which input was wrong, a code
+for programs to check, and a message to show to the user.*/
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+/*This is the list of every field error found in one request.*/
+type ValidationErrors []FieldError
+
+//Joins all of the field errors into one message.
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, fieldErr := range v {
+		messages[i] = fieldErr.Field + ": " + fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+//Adds an error for a field.
+func (v *ValidationErrors) Add(field, code, message string) {
+	*v = append(*v, FieldError{field, code, message})
+}
+
+//Puts the errors in a map from field name to its first message for the html templates.
+func (v ValidationErrors) ByField() map[string]string {
+	fields := make(map[string]string)
+	for _, fieldErr := range v {
+		if _, ok := fields[fieldErr.Field]; !ok {
+			fields[fieldErr.Field] = fieldErr.Message
+		}
+	}
+	return fields
+}
+
+/*This is the struct returned by the API when the input doesn't validate.*/
+type ValidationResponse struct {
+	Error  string           `json:"error"`
+	Fields ValidationErrors `json:"fields"`
+}
+
+//Parses a number from a form value, recording an error if it is missing or not a number.
+func ParseField(form url.Values, field, label string, errs *ValidationErrors) (float64, bool) {
+	text := strings.TrimSpace(form.Get(field))
+	if text == "" {
+		errs.Add(field, CodeRequired, "Please enter a "+label+".")
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		errs.Add(field, CodeInvalidNumber, "Number entered for "+label+" was invalid.")
+		return 0, false
+	}
+	return value, true
+}
+
+//Checks that a value is within the range min to max.
+func CheckRange(value, min, max float64, field, label string, errs *ValidationErrors) {
+	if value < min || value > max {
+		errs.Add(field, CodeOutOfRange, "The "+label+" must be between "+FormatNumber(min)+" and "+FormatNumber(max)+".")
+	}
+}
+
+//Checks that a size is above zero and no bigger than MaxSizeSqFt.
+func CheckSize(value float64, field, label string, errs *ValidationErrors) {
+	if value <= 0 || value > MaxSizeSqFt {
+		errs.Add(field, CodeOutOfRange, "The "+label+" must be more than 0 and at most "+FormatNumber(MaxSizeSqFt)+" square feet.")
+	}
+}
+
+//Formats a number without trailing zeros.
+func FormatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+//Parses a coordinate from a form value, which can have a hemisphere letter
+//or be in degrees, minutes and seconds, and checks it is within min to max.
+func ParseCoordinate(form url.Values, field, label string, letters [2]rune, min, max float64, errs *ValidationErrors) float64 {
+	text := strings.TrimSpace(form.Get(field))
+	if text == "" {
+		errs.Add(field, CodeRequired, "Please enter a "+label+".")
+		return 0
+	}
+	value, err := ParseAngle(text, letters)
+	if err != nil {
+		errs.Add(field, CodeInvalidCoord, "The "+label+" was invalid: "+err.Error()+".")
+		return 0
+	}
+	CheckRange(value, min, max, field, label, errs)
+	return value
+}
+
+//Reads the user's location from a form. It is either typed together in the
+//location field or split over the latitude and longitude fields.
+func ParseLocationForm(form url.Values, errs *ValidationErrors) (float64, float64, string) {
+	location := strings.TrimSpace(form.Get("location"))
+	if location != "" {
+		lat, lon, err := ParseLocation(location)
+		if err != nil {
+			errs.Add("location", CodeInvalidCoord, err.Error())
+		}
+		return lat, lon, location
+	}
+	lat := ParseCoordinate(form, "latitude", "latitude", LatitudeLetters, MinLatitude, MaxLatitude, errs)
+	lon := ParseCoordinate(form, "longitude", "longitude", LongitudeLetters, MinLongitude, MaxLongitude, errs)
+	return lat, lon, ""
+}
+
+//Gives the field to report a problem with the request's location on.
+func (req EstimateRequest) LocationField(latField string) string {
+	if req.Location != "" {
+		return "location"
+	}
+	return latField
+}
+
+//Parses an optional number from a form value and checks it is within min to
+//max. It gives def if the value is empty.
+func ParseOptional(form url.Values, field, label string, min, max, def float64, errs *ValidationErrors) float64 {
+	if strings.TrimSpace(form.Get(field)) == "" {
+		return def
+	}
+	value, ok := ParseField(form, field, label, errs)
+	if ok {
+		CheckRange(value, min, max, field, label, errs)
+	}
+	return value
+}
+
+//Parses a size from a form value and checks it is a realistic size.
+func ParseSize(form url.Values, field, label string, errs *ValidationErrors) float64 {
+	value, ok := ParseField(form, field, label, errs)
+	if ok {
+		CheckSize(value, field, label, errs)
+	}
+	return value
+}
+
+//Reads and validates an estimate request from the form on the solar energy page.
+func ParseEstimateForm(form url.Values) (EstimateRequest, ValidationErrors) {
+	var errs ValidationErrors
+	lat, lon, location := ParseLocationForm(form, &errs)
+	house := ParseSize(form, "housesize", "house size", &errs)
+	faces := ParseFacesForm(form, lat, &errs)
+	roof := FacesArea(faces)
+	if len(faces) == 0 {
+		roof = ParseSize(form, "roofsize", "roof size", &errs)
+	}
+	req := EstimateRequest{Latitude: lat, Longitude: lon, Location: location, HouseSize: house, RoofSize: roof, Faces: faces}
+	req.Roof = ParseRoof(form.Get("pitch"), form.Get("azimuth"), lat, "pitch", "azimuth", &errs)
+	req.Transposition = strings.TrimSpace(form.Get("transposition"))
+	CheckTransposition(req.Transposition, "transposition", &errs)
+	req.Losses = ParseLossForm(form, &errs)
+	req.Inverter = strings.TrimSpace(form.Get("inverter"))
+	req.DCACRatio = ParseOptional(form, "dc_ac_ratio", "DC/AC ratio", 0.5, 2, DefaultDCACRatio, &errs)
+	req.Layout = ParseRoofPlanForm(form, roof, &errs)
+	UseSetbacks(req.Faces, req.Layout)
+	req.Shade = ParseShadeForm(form, &errs)
+	req.Finance = ParseFinanceForm(form, &errs)
+	req.Tariff = strings.TrimSpace(form.Get("tariff"))
+	req.Export = strings.TrimSpace(form.Get("export_policy"))
+	req.InstallDate = strings.TrimSpace(form.Get("install_date"))
+	CheckInstallDate(req.InstallDate, "install_date", &errs)
+	req.Mode = strings.TrimSpace(form.Get("interpolation"))
+	if form.Get("neighbors") != "" {
+		if value, ok := ParseField(form, "neighbors", "number of cities to blend", &errs); ok {
+			req.Neighbors = int(value)
+		}
+	}
+	if form.Get("power") != "" {
+		req.Power, _ = ParseField(form, "power", "blending power", &errs)
+	}
+	CheckInterpolation(req, "interpolation", "neighbors", "power", &errs)
+	return req, errs
+}
+
+//Reads the roof's shape and setbacks from a form: roof_width and roof_length,
+//or roof_polygon, and setback and ridge_setback, all in feet, and orientation.
+func ParseRoofPlanForm(form url.Values, roofSize float64, errs *ValidationErrors) RoofPlan {
+	width := ParseOptional(form, "roof_width", "roof width", 1, 1000, 0, errs)
+	length := ParseOptional(form, "roof_length", "roof length", 1, 1000, 0, errs)
+	var polygon []Point
+	if text := strings.TrimSpace(form.Get("roof_polygon")); text != "" {
+		var err error
+		if polygon, err = ParsePolygon(text); err != nil {
+			errs.Add("roof_polygon", CodeInvalidNumber, "The roof corners were invalid: "+err.Error()+".")
+		}
+	}
+	setback := ParseOptional(form, "setback", "edge setback", 0, 10, DefaultEdgeSetback/FeetToMeters, errs)
+	ridgeSetback := ParseOptional(form, "ridge_setback", "ridge setback", 0, 10, DefaultRidgeSetback/FeetToMeters, errs)
+	return MakeRoofPlan(roofSize, width, length, polygon, setback, ridgeSetback, strings.TrimSpace(form.Get("orientation")), errs)
+}
+
+//Checks a roof's setbacks, in feet, and the way its panels are turned, and
+//makes the plan the panels are packed into, in meters. Its shape is checked
+//by RoofPolygon.
+func MakeRoofPlan(roofSize, width, length float64, polygon []Point, setback, ridgeSetback float64, orientation string, errs *ValidationErrors) RoofPlan {
+	plan := RoofPlan{Polygon: RoofPolygon("", roofSize, width, length, polygon, errs)}
+	plan.EdgeSetback, plan.RidgeSetback = setback*FeetToMeters, ridgeSetback*FeetToMeters
+	switch orientation {
+	case "", OrientationBest:
+		plan.Orientation = OrientationBest
+	case OrientationPortrait, OrientationLandscape:
+		plan.Orientation = orientation
+	default:
+		errs.Add("orientation", CodeOutOfRange, "The panel orientation must be "+OrientationPortrait+", "+OrientationLandscape+" or "+OrientationBest+".")
+	}
+	return plan
+}
+
+//Checks a roof's shape, given as its width and length or the corners of a
+//polygon in feet, and gives its corners in meters. A roof without either is
+//a square of its size. The fields are reported with prefix, such as plane1_.
+func RoofPolygon(prefix string, roofSize, width, length float64, polygon []Point, errs *ValidationErrors) []Point {
+	switch {
+	case len(polygon) > 0 && (width != 0 || length != 0):
+		errs.Add(prefix+"roof_polygon", CodeOutOfRange, "Please enter either the roof's corners or its width and length, not both.")
+	case len(polygon) > 0:
+		if err := CheckPolygon(polygon); err != nil {
+			errs.Add(prefix+"roof_polygon", CodeOutOfRange, "The roof corners were invalid: "+err.Error()+".")
+			break
+		}
+		corners := make([]Point, len(polygon))
+		for i, p := range polygon {
+			corners[i] = Point{p.X * FeetToMeters, p.Y * FeetToMeters}
+		}
+		return corners
+	case width == 0 && length != 0:
+		errs.Add(prefix+"roof_width", CodeRequired, "Please enter the roof's width as well as its length.")
+	case width != 0 && length == 0:
+		errs.Add(prefix+"roof_length", CodeRequired, "Please enter the roof's length as well as its width.")
+	case width != 0:
+		return RectanglePlan(width*FeetToMeters, length*FeetToMeters).Polygon
+	}
+	return SquarePlan(roofSize).Polygon
+}
+
+//Reads the planes of the roof from the rows of the form, plane1_ to plane4_
+//and the field's name, such as plane1_area. Rows left empty are skipped.
+func ParseFacesForm(form url.Values, lat float64, errs *ValidationErrors) []RoofFace {
+	var faces []RoofFace
+	for n := 1; n <= FormRoofFaces; n++ {
+		prefix := FaceFormPrefix(n)
+		empty := true
+		for _, field := range FaceFormFields {
+			if strings.TrimSpace(form.Get(prefix+field)) != "" {
+				empty = false
+			}
+		}
+		if empty {
+			continue
+		}
+		name := strings.TrimSpace(form.Get(prefix + "name"))
+		if name == "" {
+			name = fmt.Sprintf("Plane %d", n)
+		}
+		area := ParseOptional(form, prefix+"area", "plane area", 1, MaxSizeSqFt, 0, errs)
+		width := ParseOptional(form, prefix+"roof_width", "plane width", 1, 1000, 0, errs)
+		length := ParseOptional(form, prefix+"roof_length", "plane length", 1, 1000, 0, errs)
+		plane := ParseRoof(form.Get(prefix+"pitch"), form.Get(prefix+"azimuth"), lat, prefix+"pitch", prefix+"azimuth", errs)
+		var shading *float64
+		if strings.TrimSpace(form.Get(prefix+"shading")) != "" {
+			value := ParseOptional(form, prefix+"shading", "shading", 0, 80, 0, errs)
+			shading = &value
+		}
+		faces = append(faces, MakeRoofFace(prefix, name, area, width, length, nil, plane, shading, lat, errs))
+	}
+	return faces
+}
+
+//The fields of each row of planes on the form, after its plane1_ to plane4_.
+var FaceFormFields = []string{"name", "area", "roof_width", "roof_length", "pitch", "azimuth", "shading"}
+
+//Reads what shades the roof from a form: horizon, a horizon profile as
+//azimuth,elevation pairs or a survey tool's CSV file, obstructions, one a
+//line in feet, and eave_height in feet. It gives nil if nothing shades it.
+func ParseShadeForm(form url.Values, errs *ValidationErrors) *Shade {
+	var horizon Horizon
+	if text := strings.TrimSpace(form.Get("horizon")); text != "" {
+		var err error
+		if horizon, err = ParseHorizon(text); err != nil {
+			errs.Add("horizon", CodeInvalidNumber, "The horizon was invalid: "+err.Error()+".")
+		}
+	}
+	obstructions, err := ParseObstructions(form.Get("obstructions"))
+	if err != nil {
+		errs.Add("obstructions", CodeInvalidNumber, "The obstructions were invalid: "+err.Error()+".")
+	}
+	eaveHeight := ParseOptional(form, "eave_height", "eave height", 0, 200, DefaultEaveHeight/FeetToMeters, errs)
+	return MakeShade("", horizon, obstructions, eaveHeight, errs)
+}
+
+//Checks the obstructions around a roof, in feet, and makes what shades it,
+//in meters, with the eave eaveHeight feet above the ground. It gives nil if
+//there is no horizon and no obstructions.
+func MakeShade(prefix string, horizon Horizon, obstructions []Obstruction, eaveHeight float64, errs *ValidationErrors) *Shade {
+	for i, obstruction := range obstructions {
+		if err := CheckObstruction(obstruction); err != nil {
+			errs.Add(fmt.Sprintf("%sobstructions[%d]", prefix, i), CodeOutOfRange, fmt.Sprintf("Obstruction %d was invalid: %s.", i+1, err))
+		}
+	}
+	if len(horizon) == 0 && len(obstructions) == 0 {
+		return nil
+	}
+	shade := &Shade{Horizon: horizon, EaveHeight: eaveHeight * FeetToMeters}
+	for _, obstruction := range obstructions {
+		shade.Obstructions = append(shade.Obstructions, obstruction.Scale(FeetToMeters))
+	}
+	return shade
+}
+
+//Checks that an obstruction, in feet, is a box or a cylinder with a size, a
+//top above its base and a place within 3000 feet of the roof.
+func CheckObstruction(obstruction Obstruction) error {
+	switch obstruction.Kind {
+	case ObstructionBox:
+		if obstruction.Width <= 0 || obstruction.Depth <= 0 {
+			return errors.New("a box needs a width and depth above 0")
+		}
+	case ObstructionCylinder:
+		if obstruction.Radius <= 0 {
+			return errors.New("a cylinder needs a radius above 0")
+		}
+	default:
+		return fmt.Errorf("the kind must be %s or %s", ObstructionBox, ObstructionCylinder)
+	}
+	if obstruction.Base < 0 || obstruction.Height <= obstruction.Base || obstruction.Height > 1000 {
+		return errors.New("the height must be above the base and at most 1000 feet, and the base at least 0")
+	}
+	if math.Abs(obstruction.East) > 3000 || math.Abs(obstruction.North) > 3000 {
+		return errors.New("it must be within 3000 feet of the roof")
+	}
+	return nil
+}
+
+//Checks the optional interpolation settings of a request.
+func CheckInterpolation(req EstimateRequest, modeField, neighborsField, powerField string, errs *ValidationErrors) {
+	switch req.Mode {
+	case "", ModeNearest, ModeIDW, ModeIDWLatitude:
+	default:
+		errs.Add(modeField, CodeOutOfRange, "The climate data mode must be "+ModeNearest+", "+ModeIDW+" or "+ModeIDWLatitude+".")
+	}
+	if req.Neighbors != 0 {
+		CheckRange(float64(req.Neighbors), 1, MaxNeighbors, neighborsField, "number of cities to blend", errs)
+	}
+	if req.Power != 0 {
+		CheckRange(req.Power, 0.5, 5, powerField, "blending power", errs)
+	}
+}
+
+//Reads which way the roof faces from its pitch (degrees or rise/run) and
+//azimuth (degrees clockwise from north or a compass point). It gives nil if
+//neither was given, for a flat roof. A roof with a pitch and no azimuth faces
+//the equator.
+func ParseRoof(pitchText, azimuthText string, lat float64, pitchField, azimuthField string, errs *ValidationErrors) *Plane {
+	pitchText, azimuthText = strings.TrimSpace(pitchText), strings.TrimSpace(azimuthText)
+	if pitchText == "" && azimuthText == "" {
+		return nil
+	}
+	roof := Plane{Azimuth: EquatorAzimuth(lat)}
+	if pitchText != "" {
+		pitch, err := ParsePitch(pitchText)
+		if err != nil {
+			errs.Add(pitchField, CodeInvalidNumber, "The roof pitch was invalid: "+err.Error()+".")
+		} else {
+			roof.Tilt = pitch
+			CheckRange(pitch, 0, 90, pitchField, "roof pitch", errs)
+		}
+	}
+	if azimuthText != "" {
+		azimuth, err := ParseAzimuth(azimuthText)
+		if err != nil {
+			errs.Add(azimuthField, CodeInvalidNumber, "The roof direction was invalid: "+err.Error()+".")
+		} else {
+			roof.Azimuth = azimuth
+			CheckRange(azimuth, 0, 360, azimuthField, "roof direction", errs)
+		}
+	}
+	return &roof
+}
+
+//Checks that a transposition model is isotropic, hay-davies or perez, or empty for the default.
+func CheckTransposition(model, field string, errs *ValidationErrors) {
+	if !ValidTransposition(model) {
+		errs.Add(field, CodeOutOfRange, "The transposition model must be "+TransposeIsotropic+", "+TransposeHayDavies+" or "+TransposePerez+".")
+	}
+}
+
+//Reads the losses set on a form, from the fields named loss_ and the loss's
+//key, such as loss_soiling. Losses left empty keep their default.
+func ParseLossForm(form url.Values, errs *ValidationErrors) Losses {
+	losses := Losses{}
+	for _, loss := range LossChain {
+		field := "loss_" + loss.Key
+		if strings.TrimSpace(form.Get(field)) != "" {
+			losses[loss.Key] = ParseOptional(form, field, loss.Name(), loss.Min, loss.Max, loss.Default, errs)
+		}
+	}
+	return losses
+}
+
+//Reads the finance settings set on a form, from the fields named by their
+//keys, such as electricity_price. Settings left empty keep their default.
+func ParseFinanceForm(form url.Values, errs *ValidationErrors) FinanceInputs {
+	inputs := FinanceInputs{}
+	for _, setting := range AllFinanceSettings() {
+		if strings.TrimSpace(form.Get(setting.Key)) != "" {
+			inputs[setting.Key] = ParseOptional(form, setting.Key, setting.Name(), setting.Min, setting.Max, setting.Default, errs)
+		}
+	}
+	return inputs
+}
+
+//Checks the finance settings set in a JSON body. Errors are reported on
+//finance. and the setting's key, such as finance.escalation.
+func CheckFinance(inputs FinanceInputs, errs *ValidationErrors) {
+	for key, value := range inputs {
+		field := "finance." + key
+		setting, ok := FindFinanceSetting(key)
+		if !ok {
+			settings := AllFinanceSettings()
+			keys := make([]string, len(settings))
+			for i, setting := range settings {
+				keys[i] = setting.Key
+			}
+			errs.Add(field, CodeUnknown, key+" is not a finance setting. The settings are "+strings.Join(keys, ", ")+".")
+			continue
+		}
+		CheckRange(value, setting.Min, setting.Max, field, setting.Name(), errs)
+	}
+}
+
+//Checks the losses set in a JSON body. Errors are reported on losses. and
+//the loss's key, such as losses.soiling.
+func CheckLosses(losses Losses, errs *ValidationErrors) {
+	for key, value := range losses {
+		field := "losses." + key
+		loss, ok := FindLoss(key)
+		if !ok {
+			keys := make([]string, len(LossChain))
+			for i, loss := range LossChain {
+				keys[i] = loss.Key
+			}
+			errs.Add(field, CodeUnknown, key+" is not a loss. The losses are "+strings.Join(keys, ", ")+".")
+			continue
+		}
+		CheckRange(value, loss.Min, loss.Max, field, loss.Name(), errs)
+	}
+}
+
+//Checks that an inverter is in the inverter catalog, or empty for the loss
+//chain's inverter efficiency.
+func CheckInverter(dataset *Dataset, name, field string, errs *ValidationErrors) {
+	if _, ok := dataset.Inverters[name]; name != "" && !ok {
+		errs.Add(field, CodeUnknown, name+" is not in the inverter catalog.")
+	}
+}
+
+//Checks that a tariff is in the tariffs directory, or empty for a flat rate
+//at the electricity price.
+func CheckTariff(dataset *Dataset, key, field string, errs *ValidationErrors) {
+	if _, ok := dataset.Tariffs[key]; key != "" && !ok {
+		errs.Add(field, CodeUnknown, key+" is not a tariff in the tariffs directory.")
+	}
+}
+
+//Checks that an install date looks like 2025-06-30, or is empty for today.
+func CheckInstallDate(text, field string, errs *ValidationErrors) {
+	if _, err := time.Parse("2006-01-02", text); text != "" && err != nil {
+		errs.Add(field, CodeInvalidNumber, "The install date must look like 2025-06-30.")
+	}
+}
+
+//Checks that an export policy is in export.csv, or empty for the city's
+//policy.
+func CheckExportPolicy(dataset *Dataset, key, field string, errs *ValidationErrors) {
+	if _, ok := dataset.Export[key]; key != "" && !ok {
+		errs.Add(field, CodeUnknown, key+" is not an export policy in export.csv.")
+	}
+}
+
+//Reads and validates the house and roof size from a heat map form. The house
+//size field is named differently on the html page and in the API.
+func ParseHeatmapForm(form url.Values, houseField string) (float64, float64, ValidationErrors) {
+	var errs ValidationErrors
+	house := ParseSize(form, houseField, "house size", &errs)
+	roof := ParseSize(form, "roofsize", "roof size", &errs)
+	return house, roof, errs
+}
+
+/*This is the JSON body accepted by the estimate API. The fields are pointers
+so a missing field can be told apart from a zero.*/
+type EstimateBody struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Location  string   `json:"location"` //latitude and longitude as text, instead of the two numbers
+	HouseSize *float64 `json:"house_size"`
+	RoofSize  *float64 `json:"roof_size"`
+	Mode      string   `json:"mode"`
+	Neighbors int      `json:"neighbors"`
+	Power     float64  `json:"power"`
+
+	Pitch         AngleText `json:"pitch"`   //degrees, or rise/run such as "4/12"
+	Azimuth       AngleText `json:"azimuth"` //degrees clockwise from north, or a compass point such as "SW"
+	Transposition string    `json:"transposition"`
+	Losses        Losses    `json:"losses"` //percent for each loss to change from its default, by key
+
+	Inverter  string  `json:"inverter"`    //model from the inverter catalog
+	DCACRatio float64 `json:"dc_ac_ratio"` //panel watts over inverter AC watts
+
+	RoofWidth    float64  `json:"roof_width"`    //feet along the eave
+	RoofLength   float64  `json:"roof_length"`   //feet from the eave to the ridge
+	RoofPolygon  []Point  `json:"roof_polygon"`  //corners of the roof in feet, instead of the width and length
+	Setback      *float64 `json:"setback"`       //feet from the roof's edges (3 if not given)
+	RidgeSetback *float64 `json:"ridge_setback"` //feet from the ridge (1.5 if not given)
+	Orientation  string   `json:"orientation"`   //portrait, landscape or best
+
+	RoofPlanes []PlaneBody `json:"roof_planes"` //planes of the roof, instead of roof_size and one pitch and azimuth
+	ShadeBody
+
+	Finance FinanceInputs `json:"finance"`       //value for each finance setting to change from its default, by key
+	Tariff  string        `json:"tariff"`        //key of a utility rate in the tariffs directory
+	Export  string        `json:"export_policy"` //key of an export policy in export.csv
+
+	InstallDate string `json:"install_date"` //day the system is installed, like 2025-06-30 (today if empty)
+}
+
+/*This is what shades the roof, or one of its planes, in the JSON body of the
+estimate API.*/
+type ShadeBody struct {
+	Horizon      Horizon           `json:"horizon"`      //azimuth and elevation pairs in degrees, azimuth clockwise from north
+	HorizonCSV   string            `json:"horizon_csv"`  //a horizon profile exported by a site survey tool, instead of horizon
+	Obstructions []ObstructionBody `json:"obstructions"` //trees, chimneys and buildings close to the roof
+	EaveHeight   *float64          `json:"eave_height"`  //feet above the ground (10 if not given)
+}
+
+/*This is a tree, chimney or building close to the roof in the JSON body of
+the estimate API, in feet east and north of the middle of the roof plane.*/
+type ObstructionBody struct {
+	Name   string  `json:"name"`
+	Kind   string  `json:"kind"` //box or cylinder, or tree, chimney or building
+	East   float64 `json:"east"`
+	North  float64 `json:"north"`
+	Width  float64 `json:"width"`  //east to west, for a box
+	Depth  float64 `json:"depth"`  //north to south, for a box
+	Radius float64 `json:"radius"` //for a cylinder
+	Height float64 `json:"height"` //top above the ground
+	Base   float64 `json:"base"`   //bottom above the ground
+}
+
+//Checks the shade in a JSON body and makes it, with the names of its fields
+//starting with prefix. It gives nil if nothing shades the roof.
+func (body ShadeBody) Validate(prefix string, errs *ValidationErrors) *Shade {
+	horizon := body.Horizon
+	switch {
+	case len(horizon) > 0 && body.HorizonCSV != "":
+		errs.Add(prefix+"horizon", CodeOutOfRange, "Please enter either the horizon or a horizon CSV file, not both.")
+	case len(horizon) > 0:
+		if err := CheckHorizon(horizon); err != nil {
+			errs.Add(prefix+"horizon", CodeOutOfRange, "The horizon was invalid: "+err.Error()+".")
+		}
+	case body.HorizonCSV != "":
+		var err error
+		if horizon, err = ReadHorizonCSV(strings.NewReader(body.HorizonCSV)); err != nil {
+			errs.Add(prefix+"horizon_csv", CodeInvalidNumber, "The horizon CSV file was invalid: "+err.Error()+".")
+		}
+	}
+	obstructions := make([]Obstruction, len(body.Obstructions))
+	for i, obstruction := range body.Obstructions {
+		obstructions[i] = Obstruction(obstruction)
+		obstructions[i].Kind = ObstructionKind(obstruction.Kind)
+	}
+	eaveHeight := DefaultEaveHeight / FeetToMeters
+	if body.EaveHeight != nil {
+		eaveHeight = *body.EaveHeight
+		CheckRange(eaveHeight, 0, 200, prefix+"eave_height", "eave height", errs)
+	}
+	return MakeShade(prefix, horizon, obstructions, eaveHeight, errs)
+}
+
+/*This is one plane of the roof in the JSON body of the estimate API.*/
+type PlaneBody struct {
+	Name        string    `json:"name"`
+	Area        float64   `json:"area"`         //square feet (the area of its shape if not given)
+	RoofWidth   float64   `json:"roof_width"`   //feet along the eave
+	RoofLength  float64   `json:"roof_length"`  //feet from the eave to the ridge
+	RoofPolygon []Point   `json:"roof_polygon"` //corners in feet, instead of the width and length
+	Pitch       AngleText `json:"pitch"`
+	Azimuth     AngleText `json:"azimuth"`
+	Shading     *float64  `json:"shading"` //percent of the sunlight lost to shade (the loss chain's shading if not given)
+	ShadeBody             //what shades this plane (the roof's if not given), which sets its shading if shading isn't given
+}
+
+/*This is an angle in a JSON body, which can be given as a number or as text
+such as "4/12" or "SW".*/
+type AngleText string
+
+//Reads an angle from either a JSON number or a JSON string.
+func (a *AngleText) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var number float64
+		if err := json.Unmarshal(data, &number); err != nil {
+			return errors.New("an angle must be a number or a string")
+		}
+		text = FormatNumber(number)
+	}
+	*a = AngleText(text)
+	return nil
+}
+
+//Checks the JSON body of an estimate request and converts it to an EstimateRequest.
+func (body EstimateBody) Validate() (EstimateRequest, ValidationErrors) {
+	var errs ValidationErrors
+	var req EstimateRequest
+	if body.Location != "" {
+		var err error
+		req.Location = body.Location
+		if req.Latitude, req.Longitude, err = ParseLocation(body.Location); err != nil {
+			errs.Add("location", CodeInvalidCoord, err.Error())
+		}
+	} else {
+		if body.Latitude == nil {
+			errs.Add("latitude", CodeRequired, "Please enter a latitude.")
+		} else {
+			req.Latitude = *body.Latitude
+			CheckRange(req.Latitude, MinLatitude, MaxLatitude, "latitude", "latitude", &errs)
+		}
+		if body.Longitude == nil {
+			errs.Add("longitude", CodeRequired, "Please enter a longitude.")
+		} else {
+			req.Longitude = *body.Longitude
+			CheckRange(req.Longitude, MinLongitude, MaxLongitude, "longitude", "longitude", &errs)
+		}
+	}
+	if body.HouseSize == nil {
+		errs.Add("house_size", CodeRequired, "Please enter a house size.")
+	} else {
+		req.HouseSize = *body.HouseSize
+		CheckSize(req.HouseSize, "house_size", "house size", &errs)
+	}
+	if len(body.RoofPlanes) > MaxRoofFaces {
+		errs.Add("roof_planes", CodeOutOfRange, fmt.Sprintf("A roof can have at most %d planes.", MaxRoofFaces))
+	}
+	for i, plane := range body.RoofPlanes {
+		prefix := fmt.Sprintf("roof_planes[%d].", i)
+		if plane.Name == "" {
+			plane.Name = fmt.Sprintf("Plane %d", i+1)
+		}
+		if plane.Area != 0 {
+			CheckSize(plane.Area, prefix+"area", "plane area", &errs)
+		}
+		if plane.RoofWidth != 0 {
+			CheckRange(plane.RoofWidth, 1, 1000, prefix+"roof_width", "plane width", &errs)
+		}
+		if plane.RoofLength != 0 {
+			CheckRange(plane.RoofLength, 1, 1000, prefix+"roof_length", "plane length", &errs)
+		}
+		if plane.Shading != nil {
+			CheckRange(*plane.Shading, 0, 80, prefix+"shading", "shading", &errs)
+		}
+		roof := ParseRoof(string(plane.Pitch), string(plane.Azimuth), req.Latitude, prefix+"pitch", prefix+"azimuth", &errs)
+		face := MakeRoofFace(prefix, plane.Name, plane.Area, plane.RoofWidth, plane.RoofLength, plane.RoofPolygon, roof, plane.Shading, req.Latitude, &errs)
+		face.Shade = plane.ShadeBody.Validate(prefix, &errs)
+		req.Faces = append(req.Faces, face)
+	}
+	if len(req.Faces) > 0 {
+		req.RoofSize = FacesArea(req.Faces)
+	} else if body.RoofSize == nil {
+		errs.Add("roof_size", CodeRequired, "Please enter a roof size.")
+	} else {
+		req.RoofSize = *body.RoofSize
+		CheckSize(req.RoofSize, "roof_size", "roof size", &errs)
+	}
+	req.Roof = ParseRoof(string(body.Pitch), string(body.Azimuth), req.Latitude, "pitch", "azimuth", &errs)
+	req.Transposition = body.Transposition
+	CheckTransposition(req.Transposition, "transposition", &errs)
+	req.Losses = body.Losses
+	CheckLosses(req.Losses, &errs)
+	req.Finance, req.Tariff, req.Export = body.Finance, body.Tariff, body.Export
+	req.InstallDate = body.InstallDate
+	CheckInstallDate(req.InstallDate, "install_date", &errs)
+	CheckFinance(req.Finance, &errs)
+	req.Inverter, req.DCACRatio = body.Inverter, body.DCACRatio
+	if req.DCACRatio != 0 {
+		CheckRange(req.DCACRatio, 0.5, 2, "dc_ac_ratio", "DC/AC ratio", &errs)
+	}
+	if body.RoofWidth != 0 {
+		CheckRange(body.RoofWidth, 1, 1000, "roof_width", "roof width", &errs)
+	}
+	if body.RoofLength != 0 {
+		CheckRange(body.RoofLength, 1, 1000, "roof_length", "roof length", &errs)
+	}
+	setback, ridgeSetback := DefaultEdgeSetback/FeetToMeters, DefaultRidgeSetback/FeetToMeters
+	if body.Setback != nil {
+		setback = *body.Setback
+		CheckRange(setback, 0, 10, "setback", "edge setback", &errs)
+	}
+	if body.RidgeSetback != nil {
+		ridgeSetback = *body.RidgeSetback
+		CheckRange(ridgeSetback, 0, 10, "ridge_setback", "ridge setback", &errs)
+	}
+	req.Layout = MakeRoofPlan(req.RoofSize, body.RoofWidth, body.RoofLength, body.RoofPolygon, setback, ridgeSetback, body.Orientation, &errs)
+	UseSetbacks(req.Faces, req.Layout)
+	req.Shade = body.ShadeBody.Validate("", &errs)
+	req.Mode, req.Neighbors, req.Power = body.Mode, body.Neighbors, body.Power
+	CheckInterpolation(req, "mode", "neighbors", "power", &errs)
+	return req, errs
+}
+
+//Reads the place and time to find the sun for from a form: latitude and
+//longitude (or location), time (RFC 3339, or a date and time without an
+//offset read in tz; now if not given), tz (a time zone name such as
+//America/Denver or an offset in hours such as -7; UTC if not given), and
+//optionally elevation, pressure, temperature and delta_t.
+func ParseSunForm(form url.Values) (time.Time, solarpos.Observer, ValidationErrors) {
+	var errs ValidationErrors
+	lat, lon, _ := ParseLocationForm(form, &errs)
+	obs := solarpos.NewObserver(lat, lon)
+	obs.Elevation = ParseOptional(form, "elevation", "elevation", -500, 9000, 0, &errs)
+	obs.Pressure = ParseOptional(form, "pressure", "pressure", 300, 1100, obs.Pressure, &errs)
+	obs.Temperature = ParseOptional(form, "temperature", "temperature", -90, 60, obs.Temperature, &errs)
+	obs.DeltaT = ParseOptional(form, "delta_t", "delta T", -8000, 8000, 0, &errs)
+
+	loc := time.UTC
+	if tz := strings.TrimSpace(form.Get("tz")); tz != "" {
+		if hours, err := strconv.ParseFloat(tz, 64); err == nil && hours >= -14 && hours <= 14 {
+			loc = time.FixedZone("", int(hours*3600))
+		} else if named, err := time.LoadLocation(tz); err == nil {
+			loc = named
+		} else {
+			errs.Add("tz", CodeInvalidNumber, "The time zone was not a zone name or an offset in hours.")
+		}
+	}
+	t := time.Now().In(loc)
+	if text := strings.TrimSpace(form.Get("time")); text != "" {
+		var err error
+		if t, err = time.Parse(time.RFC3339, text); err == nil {
+			if form.Get("tz") != "" {
+				t = t.In(loc)
+			}
+		} else if t, err = time.ParseInLocation("2006-01-02T15:04:05", text, loc); err != nil {
+			if t, err = time.ParseInLocation("2006-01-02", text, loc); err != nil {
+				errs.Add("time", CodeInvalidNumber, "The time must look like 2003-10-17T12:30:30-07:00 or 2003-10-17.")
+			}
+		}
+	}
+	return t, obs, errs
+}
@@ -0,0 +1,368 @@
+/*Authors: Sarah Hsu and Caryn Willis
+Description: This file reads Typical Meteorological Year weather files, which
+hold one typical year of hourly sunlight and temperature for a weather
+station. Both TMY3 CSV files (from NREL's NSRDB) and EnergyPlus EPW files are
+read. The files go in the weather directory; only their first line is read
+when the data is loaded, and the hours are read the first time a site near
+the station is simulated.*/
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Formats of weather file.
+const (
+	FormatTMY3 = "tmy3"
+	FormatEPW  = "epw"
+)
+
+//Number of hours in a typical (non-leap) year.
+const HoursPerYear = 8760
+
+//Albedo of the ground when the weather file doesn't give one.
+const DefaultAlbedo = 0.2
+
+/*This is a weather station struct which stores where a weather file was
+recorded. TimeZone is the hours from UTC of the file's local standard time.*/
+type WeatherStation struct {
+	Name      string  `json:"name"`
+	File      string  `json:"file"`
+	Format    string  `json:"format"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	TimeZone  float64 `json:"time_zone"`
+	Elevation float64 `json:"elevation_m"`
+}
+
+/*This is one hour of a weather file. Hour is 1 to 24, for the hour ending at
+that time of local standard time. Radiation is in W/m², averaged over the hour.*/
+type WeatherHour struct {
+	Month, Day, Hour int
+	GHI              float64 //global horizontal
+	DNI              float64 //direct normal
+	DHI              float64 //diffuse horizontal
+	Temp             float64 //dry-bulb temperature, °C
+	Wind             float64 //wind speed, m/s
+	Albedo           float64
+}
+
+/*This is a whole weather file: the station and its 8760 hours, starting at
+1 a.m. on January 1st.*/
+type WeatherFile struct {
+	Station WeatherStation
+	Hours   []WeatherHour
+}
+
+/*This is the list of weather files found in the weather directory. The hours
+of each file are kept once they have been read.*/
+type WeatherIndex struct {
+	Dir      string
+	Stations []WeatherStation
+	mu       sync.Mutex
+	files    map[string]*WeatherFile
+}
+
+//Finds the weather files in a directory and reads the station of each one. A
+//directory that doesn't exist gives an empty index, and files that can't be
+//read are added to the diagnostics and left out.
+func LoadWeatherIndex(dir string, diagnostics *Diagnostics) *WeatherIndex {
+	index := &WeatherIndex{Dir: dir, Stations: []WeatherStation{}, files: make(map[string]*WeatherFile)}
+	if dir == "" {
+		return index
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			diagnostics.Add(dir, 0, "", "couldn't read the weather directory: "+err.Error())
+		}
+		return index
+	}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".csv" && ext != ".epw") {
+			continue
+		}
+		filename := filepath.Join(dir, entry.Name())
+		station, err := ReadWeatherStation(filename)
+		if err != nil {
+			diagnostics.Add(filename, 1, "", strings.TrimPrefix(err.Error(), filename+": "))
+			continue
+		}
+		index.Stations = append(index.Stations, station)
+	}
+	sort.Slice(index.Stations, func(i, j int) bool { return index.Stations[i].File < index.Stations[j].File })
+	return index
+}
+
+//Finds the weather station closest to the coordinates and how far away it is
+//in km. It returns false if there are no stations or the closest is further
+//than maxKm.
+func (index *WeatherIndex) Nearest(lat, lon, maxKm float64) (WeatherStation, float64, bool) {
+	var best WeatherStation
+	bestKm := -1.0
+	for _, station := range index.Stations {
+		km := Haversine(lat, lon, station.Latitude, station.Longitude)
+		if bestKm < 0 || km < bestKm {
+			best, bestKm = station, km
+		}
+	}
+	if bestKm < 0 || bestKm > maxKm {
+		return WeatherStation{}, bestKm, false
+	}
+	return best, bestKm, true
+}
+
+//Gives the hours of a station's weather file, reading the file the first
+//time it is asked for.
+func (index *WeatherIndex) Load(station WeatherStation) (*WeatherFile, error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if weather, ok := index.files[station.File]; ok {
+		return weather, nil
+	}
+	weather, err := ReadWeather(station.File)
+	if err != nil {
+		return nil, err
+	}
+	index.files[station.File] = weather
+	return weather, nil
+}
+
+//Reads the station from the first line of a weather file.
+func ReadWeatherStation(filename string) (WeatherStation, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return WeatherStation{}, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	first, err := reader.Read()
+	if err != nil {
+		return WeatherStation{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	return ParseStation(filename, first)
+}
+
+//Reads the station and every hour of a weather file.
+func ReadWeather(filename string) (*WeatherFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	first, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	station, err := ParseStation(filename, first)
+	if err != nil {
+		return nil, err
+	}
+	weather := &WeatherFile{Station: station, Hours: make([]WeatherHour, 0, HoursPerYear)}
+	if station.Format == FormatEPW {
+		err = ReadEPWHours(reader, weather)
+	} else {
+		err = ReadTMY3Hours(reader, weather)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(weather.Hours) != HoursPerYear {
+		return nil, fmt.Errorf("%s: has %d hours, expected %d", filename, len(weather.Hours), HoursPerYear)
+	}
+	return weather, nil
+}
+
+//Reads the station from the first line of a file. An EPW file starts with
+//LOCATION,city,state,country,source,WMO,lat,lon,time zone,elevation and a
+//TMY3 file with id,name,state,time zone,lat,lon,elevation.
+func ParseStation(filename string, first []string) (WeatherStation, error) {
+	station := WeatherStation{File: filename}
+	var numbers []string
+	if len(first) > 0 && strings.EqualFold(strings.TrimSpace(first[0]), "LOCATION") {
+		if len(first) < 10 {
+			return station, fmt.Errorf("%s: LOCATION line has %d fields, expected 10", filename, len(first))
+		}
+		station.Format = FormatEPW
+		station.Name = strings.TrimSpace(first[1])
+		if state := strings.TrimSpace(first[2]); state != "" && state != "-" {
+			station.Name += ", " + state
+		}
+		numbers = first[6:10]
+	} else {
+		if len(first) < 7 {
+			return station, fmt.Errorf("%s: is not a TMY3 or EPW file", filename)
+		}
+		station.Format = FormatTMY3
+		station.Name = strings.TrimSpace(first[1])
+		if state := strings.TrimSpace(first[2]); state != "" {
+			station.Name += ", " + state
+		}
+		numbers = []string{first[4], first[5], first[3], first[6]}
+	}
+	values := make([]float64, 4)
+	for i, text := range numbers {
+		value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return station, fmt.Errorf("%s: %q in the first line is not a number", filename, text)
+		}
+		values[i] = value
+	}
+	station.Latitude, station.Longitude, station.TimeZone, station.Elevation = values[0], values[1], values[2], values[3]
+	if station.Latitude < MinLatitude || station.Latitude > MaxLatitude || station.Longitude < MinLongitude || station.Longitude > MaxLongitude {
+		return station, fmt.Errorf("%s: station coordinates %v, %v are out of range", filename, station.Latitude, station.Longitude)
+	}
+	return station, nil
+}
+
+//Reads the hours of a TMY3 file, after its first line. The second line names
+//the columns.
+func ReadTMY3Hours(reader *csv.Reader, weather *WeatherFile) error {
+	header, err := reader.Read()
+	if err != nil {
+		return errors.New("missing the column names on line 2")
+	}
+	columns := map[string]string{
+		"date": "Date", "time": "Time", "ghi": "GHI (W/m^2)", "dni": "DNI (W/m^2)",
+		"dhi": "DHI (W/m^2)", "temp": "Dry-bulb (C)", "wind": "Wspd (m/s)", "albedo": "Alb (unitless)",
+	}
+	idx := make(map[string]int)
+	for key, name := range columns {
+		idx[key] = -1
+		for i, column := range header {
+			if strings.HasPrefix(strings.TrimSpace(column), name) {
+				idx[key] = i
+				break
+			}
+		}
+		if idx[key] < 0 && key != "albedo" && key != "wind" {
+			return fmt.Errorf("missing the %q column", name)
+		}
+	}
+	for line := 3; ; line++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(fields) < len(header) {
+			return fmt.Errorf("line %d: has %d fields, the header has %d", line, len(fields), len(header))
+		}
+		var hour WeatherHour
+		var year int
+		if _, err := fmt.Sscanf(fields[idx["date"]], "%d/%d/%d", &hour.Month, &hour.Day, &year); err != nil {
+			return fmt.Errorf("line %d: bad date %q", line, fields[idx["date"]])
+		}
+		if _, err := fmt.Sscanf(fields[idx["time"]], "%d:", &hour.Hour); err != nil {
+			return fmt.Errorf("line %d: bad time %q", line, fields[idx["time"]])
+		}
+		values, err := WeatherValues(fields, []int{idx["ghi"], idx["dni"], idx["dhi"], idx["temp"], idx["wind"], idx["albedo"]})
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		hour.GHI, hour.DNI, hour.DHI, hour.Temp, hour.Wind, hour.Albedo = values[0], values[1], values[2], values[3], values[4], values[5]
+		AddWeatherHour(weather, hour)
+	}
+}
+
+//Reads the hours of an EPW file, after its LOCATION line. The data starts
+//after the DATA PERIODS line.
+func ReadEPWHours(reader *csv.Reader, weather *WeatherFile) error {
+	for line := 2; ; line++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := strconv.Atoi(strings.TrimSpace(fields[0])); err != nil {
+			continue //one of the header lines
+		}
+		if len(fields) < 33 {
+			return fmt.Errorf("line %d: has %d fields, expected at least 33", line, len(fields))
+		}
+		var hour WeatherHour
+		numbers, err := WeatherValues(fields, []int{1, 2, 3})
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		hour.Month, hour.Day, hour.Hour = int(numbers[0]), int(numbers[1]), int(numbers[2])
+		values, err := WeatherValues(fields, []int{13, 14, 15, 6, 21, 32})
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		hour.GHI, hour.DNI, hour.DHI, hour.Temp, hour.Wind, hour.Albedo = values[0], values[1], values[2], values[3], values[4], values[5]
+		if hour.Albedo >= 999 { //EPW's value for missing
+			hour.Albedo = 0
+		}
+		AddWeatherHour(weather, hour)
+	}
+}
+
+//Reads the numbers in the given fields. An index of -1 gives 0.
+func WeatherValues(fields []string, indexes []int) ([]float64, error) {
+	values := make([]float64, len(indexes))
+	for i, idx := range indexes {
+		if idx < 0 {
+			continue
+		}
+		if idx >= len(fields) {
+			return nil, fmt.Errorf("has %d fields, expected at least %d", len(fields), idx+1)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(fields[idx]), 64)
+		if err != nil || value != value {
+			return nil, fmt.Errorf("field %d: %q is not a number", idx+1, fields[idx])
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+//Adds an hour to a weather file. February 29th is left out so every file has
+//the same 8760 hours, and radiation below zero (a missing value) is read as 0.
+func AddWeatherHour(weather *WeatherFile, hour WeatherHour) {
+	if hour.Month == 2 && hour.Day == 29 {
+		return
+	}
+	if hour.Albedo <= 0 || hour.Albedo >= 1 {
+		hour.Albedo = DefaultAlbedo
+	}
+	hour.GHI = math.Max(hour.GHI, 0)
+	hour.DNI = math.Max(hour.DNI, 0)
+	hour.DHI = math.Max(hour.DHI, 0)
+	weather.Hours = append(weather.Hours, hour)
+}
+
+/*This function gets how far away in km a weather station can be and still be
+used for a site, from the WEATHER_MAX_KM environment variable. It is 100 km
+if not set.*/
+func getWeatherMaxKm() float64 {
+	p := os.Getenv("WEATHER_MAX_KM")
+	if p == "" {
+		return 100
+	}
+	limit, err := strconv.ParseFloat(p, 64)
+	if err != nil || limit <= 0 {
+		log.Print("invalid WEATHER_MAX_KM, using 100: ", p)
+		return 100
+	}
+	return limit
+}
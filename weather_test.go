@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//Weather files whose station can't be read are reported with their file, and
+//the good stations are still found.
+func TestLoadWeatherIndexDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"sf.csv":      "724940,\"SAN FRANCISCO INTL AP\",CA,-8.0,37.617,-122.400,2\n",
+		"notes.csv":   "not a weather file\n",
+		"bad-lat.epw": "LOCATION,NOWHERE,-,USA,TMY3,000000,97.5,-122.4,-8.0,2.0\n",
+		"readme.txt":  "skipped, not a weather file's extension\n",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var diagnostics Diagnostics
+	index := LoadWeatherIndex(dir, &diagnostics)
+	if len(diagnostics) != 2 {
+		t.Fatalf("got %d diagnostics, want 2: %v", len(diagnostics), diagnostics)
+	}
+	for _, diagnostic := range diagnostics {
+		if name := filepath.Base(diagnostic.File); name != "notes.csv" && name != "bad-lat.epw" {
+			t.Errorf("diagnostic for %s: %v", name, diagnostic)
+		}
+	}
+	if len(index.Stations) != 1 || index.Stations[0].Name != "SAN FRANCISCO INTL AP, CA" {
+		t.Errorf("got stations %v, want only San Francisco", index.Stations)
+	}
+}